@@ -1,18 +1,37 @@
 package db
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"regexp"
+	"slices"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/gsonntag/bruinbite/events"
 	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+	"github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type DBManager struct {
-	DB *gorm.DB
-	TZ *time.Location
+	DB     *gorm.DB
+	TZ     *time.Location
+	Events events.Publisher // optional, gets real-time events for connected clients
+}
+
+// Publish sends events to connected clients, if there's anywhere to send them
+func (m *DBManager) Publish(evts ...events.Event) {
+	if m.Events != nil && len(evts) > 0 {
+		m.Events.Publish(evts...)
+	}
 }
 
 func NewDBManager(db *gorm.DB) (*DBManager, error) {
@@ -20,56 +39,112 @@ func NewDBManager(db *gorm.DB) (*DBManager, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Could not load Pacific timezone: %w", err)
 	}
+	// menu_dishes also records the station each dish was served at
+	if err := db.SetupJoinTable(&models.Menu{}, "Dishes", &models.MenuDish{}); err != nil {
+		return nil, fmt.Errorf("Could not set up menu_dishes join table: %w", err)
+	}
 	return &DBManager{DB: db, TZ: loc}, nil
 }
 
 // Use GORM to automatically migrate our models if there are any changes to them
 func (m *DBManager) Migrate() error {
 
-	return m.DB.AutoMigrate(
+	err := m.DB.AutoMigrate(
 		&models.UpdateTracker{},
 		&models.User{},
 		&models.DiningHall{},
+		&models.Recipe{},
 		&models.Dish{},
+		&models.DishAlias{},
+		&models.DishNutrition{},
+		&models.Station{},
 		&models.Menu{},
+		&models.MenuDish{},
 		&models.Rating{},
+		&models.RatingReply{},
 		&models.Friendship{},
 		&models.FriendRequest{},
+		&models.UserPreferences{},
+		&models.Favorite{},
+		&models.HallHours{},
+		&models.HallHoursOverride{},
+		&models.Notification{},
+		&models.NotificationMute{},
+		&models.EmailPreferences{},
+		&models.UserToken{},
+		&models.Session{},
+		&models.PrivacySettings{},
+		&models.Block{},
+		&models.Activity{},
 	)
+	if err != nil {
+		return err
+	}
+	return m.migratePendingFriendRequests()
 }
 
-// Returns true if the scraper has previously fully
-// loaded the menu for a specific meal period
-func (m *DBManager) HasScraperLoaded(date models.Date) (bool, error) {
-	var tracker models.UpdateTracker
-	result := m.DB.Where("key = ?", "scraper").Find(&tracker)
-
-	if result.Error != nil {
-		return false, result.Error
-	}
+// Only one friend request can be pending between two users, whichever of them
+// sent it. GORM can't declare an index on expressions, so it's created here,
+// replacing the old one that only covered one direction.
+func (m *DBManager) migratePendingFriendRequests() error {
+	return m.Transaction(func(tx *DBManager) error {
+		if err := tx.DB.Exec(`DROP INDEX IF EXISTS idx_friend_requests_pending`).Error; err != nil {
+			return err
+		}
+		// Requests the old index let through in the other direction are cancelled, keeping the first
+		if err := tx.DB.Exec(`UPDATE friend_requests SET status = @cancelled, responded_at = NOW()
+			WHERE status = @pending AND EXISTS (
+				SELECT 1 FROM friend_requests earlier
+				WHERE earlier.status = @pending AND earlier.id < friend_requests.id
+				AND LEAST(earlier.from_id, earlier.to_id) = LEAST(friend_requests.from_id, friend_requests.to_id)
+				AND GREATEST(earlier.from_id, earlier.to_id) = GREATEST(friend_requests.from_id, friend_requests.to_id)
+			)`, map[string]interface{}{"pending": models.FriendRequestPending, "cancelled": models.FriendRequestCancelled}).Error; err != nil {
+			return err
+		}
+		return tx.DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_friend_requests_pending_pair
+			ON friend_requests (LEAST(from_id, to_id), GREATEST(from_id, to_id))
+			WHERE status = 'pending'`).Error
+	})
+}
 
-	if result.RowsAffected == 0 {
-		return false, nil
-	}
+// Transaction runs fn inside a database transaction. The DBManager passed to
+// fn uses the transaction, so any of its methods can be used. If fn returns
+// an error, everything it did is rolled back.
+func (m *DBManager) Transaction(fn func(tx *DBManager) error) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		txManager := *m
+		txManager.DB = tx
+		return fn(&txManager)
+	})
+}
 
-	return tracker.IsEqualOrAfter(date), nil
+// MenuTrackerKey is the update tracker key for one meal period of a hall
+func MenuTrackerKey(hallName string, mealPeriod string) string {
+	return "menu:" + hallName + ":" + mealPeriod
 }
 
-// Should only be called when the scraper has fully
-// ran successfully for a given meal period.
-func (m *DBManager) SetScraperLastRan(date models.Date) error {
-	*date.MealPeriod = "DINNER"
+// GetTracker returns the update tracker for the key, or nil if it has never been set
+func (m *DBManager) GetTracker(key string) (*models.UpdateTracker, error) {
 	var tracker models.UpdateTracker
-	result := m.DB.Where("key = ?", "scraper").Find(&tracker)
+	result := m.DB.Where("key = ?", key).Find(&tracker)
 	if result.Error != nil {
-		return result.Error
+		return nil, result.Error
 	}
 	if result.RowsAffected == 0 {
-		tracker = models.UpdateTracker{
-			Key:       "scraper",
-			LastRunAt: date,
-		}
-		return m.DB.Create(&tracker).Error
+		return nil, nil
+	}
+	return &tracker, nil
+}
+
+// SetTracker moves the update tracker for the key forward to date. It is
+// never moved backwards.
+func (m *DBManager) SetTracker(key string, date models.Date) error {
+	tracker, err := m.GetTracker(key)
+	if err != nil {
+		return err
+	}
+	if tracker == nil {
+		return m.DB.Create(&models.UpdateTracker{Key: key, LastRunAt: date}).Error
 	}
 
 	if tracker.IsEqualOrAfter(date) {
@@ -77,14 +152,40 @@ func (m *DBManager) SetScraperLastRan(date models.Date) error {
 	}
 
 	tracker.LastRunAt = date
-	return m.DB.Save(&tracker).Error
+	return m.DB.Save(tracker).Error
+}
+
+// Returns true if the menu for this hall has previously been fully loaded
+// for the date and meal period (date.MealPeriod must be set). The tracker
+// only remembers the latest date loaded, so other dates (e.g. when
+// backfilling old menus) fall back to checking the menus table.
+func (m *DBManager) HasMenuLoaded(hallName string, date models.Date) (bool, error) {
+	tracker, err := m.GetTracker(MenuTrackerKey(hallName, *date.MealPeriod))
+	if err != nil {
+		return false, err
+	}
+	if tracker != nil && tracker.LastRunAt.SameDay(date) {
+		return true, nil
+	}
+	return m.DoesMenuExist(hallName, date)
+}
+
+// Should only be called once the menu for this hall and meal period
+// (date.MealPeriod must be set) has been fully loaded.
+func (m *DBManager) SetMenuLoaded(hallName string, date models.Date) error {
+	return m.SetTracker(MenuTrackerKey(hallName, *date.MealPeriod), date)
 }
 
 // CreateNewHall creates a new hall if a hall of that name does not already
 // exist. It returns an error if the hall was unable to be created. It returns
 // nil if the hall already exists or if the hall was successfully created.
 func (m *DBManager) CreateNewHall(name string) (models.DiningHall, error) {
-	hall := models.DiningHall{Name: name}
+	hall := models.DiningHall{
+		Name:        name,
+		Slug:        name,
+		DisplayName: models.DisplayNameFromSlug(name),
+		HallType:    models.HallTypeResidential,
+	}
 	result := m.DB.
 		Where(models.DiningHall{Name: name}).
 		FirstOrCreate(&hall)
@@ -96,17 +197,14 @@ func (m *DBManager) CreateNewHall(name string) (models.DiningHall, error) {
 	return hall, nil
 }
 
-// Returns true if the menu for this day and meal period has already been loaded into the database.
-func (m *DBManager) DoesMenuExist(date time.Time, hallName string, mealPeriod string) (bool, error) {
-
-	// Normalize date so it matches what is in our database (at midnight exactly)
-	day, month, year := date.Day(), date.Month(), date.Year()
-	normalizedDate := time.Date(year, month, day, 0, 0, 0, 0, m.TZ)
-
+// Returns true if the menu for this day and meal period (date.MealPeriod
+// must be set) has already been loaded into the database.
+func (m *DBManager) DoesMenuExist(hallName string, date models.Date) (bool, error) {
 	// Search the database for a matching menu (same date, hall, meal period)
 	var count int64
 	err := m.DB.Model(&models.Menu{}).Joins("JOIN dining_halls ON dining_halls.id = menus.hall_id").
-		Where("dining_halls.name = ? AND menus.date = ? AND menus.meal_period = ?", hallName, normalizedDate, mealPeriod).
+		Where("dining_halls.name = ? AND date_day = ? AND date_month = ? AND date_year = ? AND date_meal_period = ?",
+			hallName, date.Day, date.Month, date.Year, *date.MealPeriod).
 		Count(&count).
 		Error
 
@@ -117,45 +215,213 @@ func (m *DBManager) DoesMenuExist(date time.Time, hallName string, mealPeriod st
 	return count > 0, nil
 }
 
-func (m *DBManager) GetOrCreateDishByName(name string, hallId uint, location string, today models.Date) (models.Dish, error) {
+// GetOrCreateDishByName finds the dish served in the hall under the given
+// name, or creates it (along with its recipe if needed). Names are compared
+// after normalizing them (see models.NormalizeDishName), and names of dishes
+// that were merged into another dish find that dish. The returned bool is
+// true if the dish was created. The dish's last seen date is moved forward
+// to today.
+func (m *DBManager) GetOrCreateDishByName(name string, hallId uint, location string, today models.Date) (models.Dish, bool, error) {
+	name = strings.TrimSpace(name)
+	normalized := models.NormalizeDishName(name)
+
+	dish, err := m.findDishByNormalizedName(hallId, normalized)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// New dish, so set its location within the hall and last seen date accordingly
+		recipe, err := m.GetOrCreateRecipe(name, normalized)
+		if err != nil {
+			return models.Dish{}, false, err
+		}
+		firstSeen := today.Time(time.UTC)
+		dish = models.Dish{
+			HallID:         hallId,
+			Name:           name,
+			NormalizedName: normalized,
+			RecipeID:       &recipe.ID,
+			Location:       &location,
+			LastSeenDate:   today,
+			FirstSeenDate:  &firstSeen,
+		}
+		if err := m.DB.Create(&dish).Error; err != nil {
+			return models.Dish{}, false, err
+		}
+		return dish, true, nil
+	}
+	if err != nil {
+		return models.Dish{}, false, err
+	}
 
-	// First, lookup if dish exists by parameters hall id and name
-	queryDish := models.Dish{
-		HallID: hallId,
-		Name:   name,
+	// It already existed, update its last seen date and location (the
+	// station it was last served at), unless this is an older menu being backfilled
+	if !today.Before(dish.LastSeenDate) && (dish.Location == nil || *dish.Location != location || dish.LastSeenDate.Before(today)) {
+		if err := m.DB.
+			Model(&dish).
+			Updates(models.Dish{LastSeenDate: today, Location: &location}).
+			Error; err != nil {
+			return models.Dish{}, false, err
+		}
 	}
 
-	// If it needs to be created, set its location
-	// within the hall and last seen date accordingly
-	result := m.DB.
-		Where("hall_id = ? AND name = ?", hallId, name).
-		Attrs(models.Dish{
-			Location:     &location,
-			LastSeenDate: today,
-		}).
-		FirstOrCreate(&queryDish)
+	// Backfilled menus can be older than the first one the dish was seen on
+	if firstSeen := today.Time(time.UTC); dish.FirstSeenDate == nil || firstSeen.Before(*dish.FirstSeenDate) {
+		if err := m.DB.Model(&dish).Update("first_seen_date", firstSeen).Error; err != nil {
+			return models.Dish{}, false, err
+		}
+		dish.FirstSeenDate = &firstSeen
+	}
+
+	return dish, false, nil
+}
 
-	if err := result.Error; err != nil {
-		return models.Dish{}, err
+// Finds a hall's dish by normalized name, checking the names of merged dishes too
+func (m *DBManager) findDishByNormalizedName(hallID uint, normalized string) (models.Dish, error) {
+	var dish models.Dish
+	err := m.DB.Where("hall_id = ? AND normalized_name = ?", hallID, normalized).Order("id").First(&dish).Error
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return dish, err
 	}
 
-	// If it already existed (RowsAffected == 0), update its last seen date
-	if result.RowsAffected == 0 {
-		if err := m.DB.
-			Model(&queryDish).
-			Updates(models.Dish{LastSeenDate: today}).
-			Error; err != nil {
-			return models.Dish{}, err
+	var alias models.DishAlias
+	if err := m.DB.Where("hall_id = ? AND normalized_name = ?", hallID, normalized).First(&alias).Error; err != nil {
+		return dish, err
+	}
+	err = m.DB.First(&dish, alias.DishID).Error
+	return dish, err
+}
+
+// UpdateDishDietaryInfo replaces the dietary tags of a dish and, if nutrition
+// is not nil, creates or replaces its nutrition facts and allergens
+func (m *DBManager) UpdateDishDietaryInfo(dishID uint, tags []string, nutrition *models.DishNutrition) error {
+	if tags != nil {
+		if err := m.DB.Model(&models.Dish{}).Where("id = ?", dishID).
+			Update("tags", pq.StringArray(tags)).Error; err != nil {
+			return err
 		}
 	}
 
-	return queryDish, nil
+	if nutrition == nil {
+		return nil
+	}
+	nutrition.DishID = dishID
+	if nutrition.Allergens == nil {
+		nutrition.Allergens = pq.StringArray{}
+	}
+	return m.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "dish_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"serving_size", "calories", "protein_grams", "carbs_grams", "fat_grams", "allergens", "recipe_url", "updated_at"}),
+	}).Create(nutrition).Error
 }
 
 func (m *DBManager) AddMenu(menu *models.Menu) error {
 	return m.DB.Create(menu).Error
 }
 
+// AddMenuWithStations saves a menu along with the station each of its dishes
+// was served at. entries are in menu order and only need DishID and
+// StationID set; a dish listed more than once keeps its first station.
+func (m *DBManager) AddMenuWithStations(menu *models.Menu, entries []models.MenuDish) error {
+	if err := m.DB.Omit("Dishes").Create(menu).Error; err != nil {
+		return err
+	}
+
+	seen := make(map[uint]bool, len(entries))
+	rows := make([]models.MenuDish, 0, len(entries))
+	for _, entry := range entries {
+		if seen[entry.DishID] {
+			continue
+		}
+		seen[entry.DishID] = true
+		rows = append(rows, models.MenuDish{
+			MenuID:    menu.ID,
+			DishID:    entry.DishID,
+			StationID: entry.StationID,
+			Position:  len(rows),
+		})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return m.DB.Create(&rows).Error
+}
+
+// GetOrCreateStation returns the hall's station with the given name, creating it if needed
+func (m *DBManager) GetOrCreateStation(hallID uint, name string) (models.Station, error) {
+	station := models.Station{HallID: hallID, Name: name}
+	err := m.DB.Where(models.Station{HallID: hallID, Name: name}).FirstOrCreate(&station).Error
+	return station, err
+}
+
+// GetMenuStations groups a menu's dishes by the station that served them, in
+// menu order. Only the given dishes are included, so the menu's dishes can be
+// filtered first. Dishes from menus loaded before stations existed are
+// grouped by their last known location instead.
+func (m *DBManager) GetMenuStations(menuID uint, dishes []models.Dish) ([]models.MenuStation, error) {
+	var entries []models.MenuDish
+	if err := m.DB.Preload("Station").Where("menu_id = ?", menuID).Order("position, dish_id").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	dishesByID := make(map[uint]models.Dish, len(dishes))
+	for _, dish := range dishes {
+		dishesByID[dish.ID] = dish
+	}
+
+	stations := []models.MenuStation{}
+	stationIndex := make(map[string]int)
+	for _, entry := range entries {
+		dish, ok := dishesByID[entry.DishID]
+		if !ok {
+			continue
+		}
+
+		station := models.MenuStation{Name: "Other"}
+		if entry.Station != nil {
+			station = models.MenuStation{ID: &entry.Station.ID, Name: entry.Station.Name}
+		} else if dish.Location != nil && *dish.Location != "" {
+			station.Name = *dish.Location
+		}
+
+		i, ok := stationIndex[station.Name]
+		if !ok {
+			i = len(stations)
+			stationIndex[station.Name] = i
+			stations = append(stations, station)
+		}
+		stations[i].Dishes = append(stations[i].Dishes, dish)
+	}
+	return stations, nil
+}
+
+// BackfillMenuStations sets the station of menu appearances from before
+// stations existed, using the location stored on the dish
+func (m *DBManager) BackfillMenuStations() error {
+	var locations []struct {
+		HallID   uint
+		Location string
+	}
+	if err := m.DB.Table("menu_dishes").
+		Select("DISTINCT dishes.hall_id, dishes.location").
+		Joins("JOIN dishes ON dishes.id = menu_dishes.dish_id").
+		Where("menu_dishes.station_id IS NULL AND dishes.location IS NOT NULL AND dishes.location <> ''").
+		Scan(&locations).Error; err != nil {
+		return err
+	}
+	if len(locations) == 0 {
+		return nil
+	}
+
+	for _, location := range locations {
+		if _, err := m.GetOrCreateStation(location.HallID, location.Location); err != nil {
+			return err
+		}
+	}
+
+	return m.DB.Exec(`UPDATE menu_dishes SET station_id = stations.id
+		FROM dishes, stations
+		WHERE menu_dishes.station_id IS NULL AND dishes.id = menu_dishes.dish_id
+		AND stations.hall_id = dishes.hall_id AND stations.name = dishes.location`).Error
+}
+
 func (m *DBManager) CreateUser(user *models.User) error {
 	return m.DB.Create(user).Error
 }
@@ -207,7 +473,7 @@ func (m *DBManager) GetAllDishesByHallName(hallName string) ([]models.Dish, erro
 
 func (m *DBManager) GetAllDishes() ([]models.Dish, error) {
 	var dishes []models.Dish
-	err := m.DB.Find(&dishes).Error
+	err := m.DB.Preload("Nutrition").Find(&dishes).Error
 	if err != nil {
 		return nil, err
 	}
@@ -258,13 +524,80 @@ func (m *DBManager) CreateRating(rating *models.Rating) error {
 		return fmt.Errorf("could not update dish average rating: %w", err)
 	}
 	// Create the rating
-	return m.DB.Create(rating).Error
+	if err := m.DB.Create(rating).Error; err != nil {
+		return err
+	}
+	// Ratings are also aggregated across every hall serving the recipe
+	if dish.RecipeID != nil {
+		if err := m.RecomputeRecipeRating(*dish.RecipeID); err != nil {
+			return err
+		}
+	}
+	m.recordRatingActivity(rating, &dish)
+	m.publishFriendRating(rating.ID)
+	return nil
+}
+
+// Adds a new rating to the feed, along with an achievement if it's a milestone
+func (m *DBManager) recordRatingActivity(rating *models.Rating, dish *models.Dish) {
+	data := map[string]interface{}{"dish_name": dish.Name, "score": rating.Score}
+	if hall, err := m.GetHallByID(dish.HallID); err == nil {
+		data["hall_name"] = hallDisplayName(hall)
+	}
+	if rating.Comment != nil {
+		data["comment"] = *rating.Comment
+	}
+	m.recordActivity(&models.Activity{
+		UserID:   rating.UserID,
+		Type:     models.ActivityRating,
+		HallID:   &dish.HallID,
+		DishID:   &dish.ID,
+		RecipeID: dish.RecipeID,
+		RatingID: &rating.ID,
+	}, data)
+
+	var count int64
+	if err := m.DB.Model(&models.Rating{}).Where("user_id = ?", rating.UserID).Count(&count).Error; err != nil {
+		return
+	}
+	if slices.Contains(models.RatingMilestones, int(count)) {
+		title := fmt.Sprintf("Rated %d dishes", count)
+		if count == 1 {
+			title = "Rated their first dish"
+		}
+		m.recordActivity(&models.Activity{
+			UserID:   rating.UserID,
+			Type:     models.ActivityAchievement,
+			RatingID: &rating.ID,
+		}, map[string]interface{}{"achievement": fmt.Sprintf("ratings_%d", count), "title": title, "count": count})
+	}
+}
+
+// Lets the rater's friends see a new rating without polling /friendratings
+func (m *DBManager) publishFriendRating(ratingID uint) {
+	if m.Events == nil {
+		return
+	}
+	rating, err := m.GetRatingByID(ratingID)
+	if err != nil || m.RatingsHiddenFromFriends(rating.UserID) {
+		return
+	}
+	friends, err := m.GetFriendsByUserID(rating.UserID)
+	if err != nil {
+		return
+	}
+	rating.User.Email = "" // events are pushed to every friend, so they never carry emails
+	evts := make([]events.Event, len(friends))
+	for i, friend := range friends {
+		evts[i] = events.Event{Type: events.TypeFriendRating, UserID: friend.ID, Data: rating}
+	}
+	m.Publish(evts...)
 }
 
 func (m *DBManager) GetMenuByHallIDAndDate(hallID uint, date models.Date) (*models.Menu, error) {
 	var menu models.Menu
 
-	err := m.DB.Preload("Dishes").
+	err := m.DB.Preload("Dishes.Nutrition").
 		Where("hall_id = ? AND date_day = ? AND date_month = ? AND date_year = ? AND date_meal_period = ?",
 			hallID,
 			date.Day,
@@ -347,9 +680,12 @@ func (m *DBManager) GetRatingsByFriends(userID uint) ([]models.Rating, error) {
 	for i, friend := range friends {
 		friendIDs[i] = friend.ID
 	}
-	// Query ratings made by friends
+	// Query ratings made by friends, except friends who keep their ratings private
 	err = m.DB.Preload("Dish").Preload("User").
 		Where("user_id IN (?)", friendIDs).
+		Where("user_id NOT IN (?)", m.DB.Model(&models.PrivacySettings{}).
+			Select("user_id").
+			Where("ratings_visibility = ?", models.VisibilityPrivate)).
 		Find(&ratings).Error
 	if err != nil {
 		return nil, err
@@ -360,7 +696,7 @@ func (m *DBManager) GetRatingsByFriends(userID uint) ([]models.Rating, error) {
 func (m *DBManager) GetMenuByHallNameAndDate(hallName string, date models.Date) (*models.Menu, error) {
 	var menu models.Menu
 
-	err := m.DB.Preload("Dishes").
+	err := m.DB.Preload("Dishes.Nutrition").
 		Joins("JOIN dining_halls ON dining_halls.id = menus.hall_id").
 		Where("dining_halls.name = ? AND date_day = ? AND date_month = ? AND date_year = ? AND date_meal_period = ?",
 			hallName,
@@ -406,12 +742,18 @@ func (m *DBManager) GetAllHallsWithRatings() ([]map[string]interface{}, error) {
 			dh.id,
 			dh.name,
 			dh.location,
+			dh.display_name,
+			dh.description,
+			dh.latitude,
+			dh.longitude,
+			dh.image_url,
+			dh.hall_type,
 			COALESCE(AVG(r.score), 0) as average_rating,
 			COUNT(r.id) as review_count
 		FROM dining_halls dh
 		LEFT JOIN dishes d ON dh.id = d.hall_id
 		LEFT JOIN ratings r ON d.id = r.dish_id
-		GROUP BY dh.id, dh.name, dh.location
+		GROUP BY dh.id
 		ORDER BY dh.name
 	`
 
@@ -425,10 +767,13 @@ func (m *DBManager) GetAllHallsWithRatings() ([]map[string]interface{}, error) {
 		var id uint
 		var name string
 		var location *string
+		var displayName, hallType string
+		var description, imageURL *string
+		var latitude, longitude *float64
 		var avgRating float64
 		var reviewCount int64
 
-		err := rows.Scan(&id, &name, &location, &avgRating, &reviewCount)
+		err := rows.Scan(&id, &name, &location, &displayName, &description, &latitude, &longitude, &imageURL, &hallType, &avgRating, &reviewCount)
 		if err != nil {
 			return nil, err
 		}
@@ -439,6 +784,13 @@ func (m *DBManager) GetAllHallsWithRatings() ([]map[string]interface{}, error) {
 		result := map[string]interface{}{
 			"id":          id,
 			"name":        name,
+			"slug":        name,
+			"displayName": displayName,
+			"description": description,
+			"latitude":    latitude,
+			"longitude":   longitude,
+			"imageUrl":    imageURL,
+			"hallType":    hallType,
 			"location":    location,
 			"rating":      avgRating,
 			"reviewCount": reviewCount,
@@ -472,14 +824,14 @@ func (m *DBManager) GetFriendsByUserID(userID uint) ([]models.User, error) {
 	return friends, nil
 }
 
-// GetOutgoingFriendRequestsByUserID retrieves all outgoing friend requests for a user
+// GetOutgoingFriendRequestsByUserID retrieves all pending outgoing friend requests for a user
 // gets the full user objects for the requests
 func (m *DBManager) GetOutgoingFriendRequestsByUserID(userID uint) ([]models.FriendRequest, error) {
 	var requests []models.FriendRequest
 
 	// Use Preload to get the user information for the recipient of each request
 	err := m.DB.Preload("ToUser").
-		Where("from_id = ?", userID).
+		Where("from_id = ? AND status = ?", userID, models.FriendRequestPending).
 		Find(&requests).Error
 
 	if err != nil {
@@ -489,11 +841,11 @@ func (m *DBManager) GetOutgoingFriendRequestsByUserID(userID uint) ([]models.Fri
 	return requests, nil
 }
 
-// GetIncomingFriendRequestsByUserID retrieves all incoming friend requests for a user
+// GetIncomingFriendRequestsByUserID retrieves all pending incoming friend requests for a user
 func (m *DBManager) GetIncomingFriendRequestsByUserID(userID uint) ([]models.FriendRequest, error) {
 	var requests []models.FriendRequest
 	err := m.DB.Preload("FromUser").
-		Where("to_id = ?", userID).
+		Where("to_id = ? AND status = ?", userID, models.FriendRequestPending).
 		Find(&requests).Error
 	if err != nil {
 		return nil, err
@@ -501,8 +853,17 @@ func (m *DBManager) GetIncomingFriendRequestsByUserID(userID uint) ([]models.Fri
 	return requests, nil
 }
 
-// CreateFriendship creates a new friendship between two users
+// CreateFriendship creates a new friendship between two users. userID is
+// the one who accepted, who the feed shows as making the new friend.
 func (m *DBManager) CreateFriendship(userID, friendID uint) error {
+	activity := models.Activity{UserID: userID, Type: models.ActivityFriendship, OtherUserID: &friendID}
+	var activityData map[string]interface{}
+	if user, err := m.GetUserByID(userID); err == nil {
+		if friend, err := m.GetUserByID(friendID); err == nil {
+			activityData = map[string]interface{}{"username": user.Username, "friend_username": friend.Username}
+		}
+	}
+
 	// Create a new friendship record, put lower ID first to avoid duplicates
 	if userID > friendID {
 		userID, friendID = friendID, userID
@@ -511,60 +872,172 @@ func (m *DBManager) CreateFriendship(userID, friendID uint) error {
 		UserID:   userID,
 		FriendID: friendID,
 	}
-	return m.DB.Create(&friendship).Error
+	if err := m.DB.Create(&friendship).Error; err != nil {
+		return err
+	}
+	m.recordActivity(&activity, activityData)
+	return nil
 }
 
-// SendFriendRequest sends a friend request from one user to another
-func (m *DBManager) SendFriendRequest(fromID, toID uint) error {
-	// Check if the friend request already exists
-	var existingRequest models.FriendRequest
+// Friend request errors, so handlers can tell the user what went wrong
+var (
+	ErrFriendRequestNotFound   = errors.New("friend request not found")
+	ErrFriendRequestNotPending = errors.New("friend request has already been answered or cancelled")
+	ErrNotRequestRecipient     = errors.New("only the recipient can accept or decline a friend request")
+	ErrNotRequestSender        = errors.New("only the sender can cancel a friend request")
+	ErrFriendRequestToSelf     = errors.New("cannot send a friend request to yourself")
+	ErrFriendRequestPending    = errors.New("a friend request between you is already pending")
+	ErrAlreadyFriends          = errors.New("already friends")
+)
 
-	// Check if a request already exists from fromID to toID
-	// and from toID to fromID (to avoid duplicates in both directions)
-	err := m.DB.Where("(from_id = ? AND to_id = ?) OR (from_id = ? AND to_id = ?)",
-		fromID, toID, toID, fromID).
-		First(&existingRequest).Error
-	if err == nil {
-		// If a request already exists, return an error
-		return errors.New("friend request already exists")
+// isUniqueViolation returns true if err is from inserting a row that breaks a
+// unique index, whichever postgres driver returned it
+func isUniqueViolation(err error) bool {
+	var sqlErr interface{ SQLState() string }
+	return errors.As(err, &sqlErr) && sqlErr.SQLState() == "23505"
+}
+
+// AreFriends returns true if the two users are friends
+func (m *DBManager) AreFriends(userID, otherID uint) (bool, error) {
+	if userID > otherID {
+		userID, otherID = otherID, userID
 	}
+	var count int64
+	err := m.DB.Model(&models.Friendship{}).
+		Where("user_id = ? AND friend_id = ?", userID, otherID).
+		Count(&count).Error
+	return count > 0, err
+}
 
+// SendFriendRequest sends a friend request from one user to another. It
+// fails if they're already friends, either of them has a pending request
+// to the other, or either of them blocked the other.
+func (m *DBManager) SendFriendRequest(fromID, toID uint) (*models.FriendRequest, error) {
 	if fromID == toID {
-		return errors.New("cannot send a friend request to yourself")
+		return nil, ErrFriendRequestToSelf
 	}
 
-	request := models.FriendRequest{
-		FromID: fromID,
-		ToID:   toID,
-		Status: "pending",
+	var request models.FriendRequest
+	err := m.Transaction(func(tx *DBManager) error {
+		if _, err := tx.GetUserByID(toID); err != nil {
+			return err
+		}
+
+		blocked, err := tx.IsBlocked(fromID, toID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+
+		friends, err := tx.AreFriends(fromID, toID)
+		if err != nil {
+			return err
+		}
+		if friends {
+			return ErrAlreadyFriends
+		}
+
+		var pending int64
+		if err := tx.DB.Model(&models.FriendRequest{}).
+			Where("status = ? AND ((from_id = ? AND to_id = ?) OR (from_id = ? AND to_id = ?))",
+				models.FriendRequestPending, fromID, toID, toID, fromID).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return ErrFriendRequestPending
+		}
+
+		request = models.FriendRequest{
+			FromID: fromID,
+			ToID:   toID,
+			Status: models.FriendRequestPending,
+		}
+		return tx.DB.Create(&request).Error
+	})
+	if err != nil {
+		// Two requests sent at the same time (even in opposite directions) both
+		// pass the check above, idx_friend_requests_pending_pair only lets one in
+		if isUniqueViolation(err) {
+			return nil, ErrFriendRequestPending
+		}
+		return nil, err
 	}
-	return m.DB.Create(&request).Error
+	return &request, nil
 }
 
-// AcceptFriendRequest accepts a friend request
-func (m *DBManager) AcceptFriendRequest(requestID uint) error {
-	// get the request to find out who sent it
-	var request models.FriendRequest
-	if err := m.DB.First(&request, requestID).Error; err != nil {
-		return fmt.Errorf("could not find friend request: %w", err)
-	}
+// AcceptFriendRequest accepts a friend request sent to the user, making them
+// friends and returning the accepted request
+func (m *DBManager) AcceptFriendRequest(userID, requestID uint) (*models.FriendRequest, error) {
+	var request *models.FriendRequest
+	err := m.Transaction(func(tx *DBManager) error {
+		var err error
+		request, err = tx.transitionFriendRequest(userID, requestID, models.FriendRequestAccepted)
+		if err != nil {
+			return err
+		}
 
-	// create a friendship between the two users
-	if err := m.CreateFriendship(request.ToID, request.FromID); err != nil {
-		return fmt.Errorf("could not create friendship: %w", err)
+		// They may have become friends through another request in the meantime
+		friends, err := tx.AreFriends(request.FromID, request.ToID)
+		if err != nil || friends {
+			return err
+		}
+		return tx.CreateFriendship(request.ToID, request.FromID)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return request, nil
+}
 
-	// delete the friend request
-	if err := m.DB.Delete(&models.FriendRequest{}, requestID).Error; err != nil {
-		return fmt.Errorf("could not delete friend request: %w", err)
-	}
+// DeclineFriendRequest declines a friend request sent to the user
+func (m *DBManager) DeclineFriendRequest(userID, requestID uint) (*models.FriendRequest, error) {
+	return m.transitionFriendRequest(userID, requestID, models.FriendRequestDeclined)
+}
 
-	return nil
+// CancelFriendRequest takes back a friend request the user sent
+func (m *DBManager) CancelFriendRequest(userID, requestID uint) (*models.FriendRequest, error) {
+	return m.transitionFriendRequest(userID, requestID, models.FriendRequestCancelled)
 }
 
-// DeleteFriendRequest deletes a friend request by its ID
-func (m *DBManager) DeleteFriendRequest(requestID uint) error {
-	return m.DB.Delete(&models.FriendRequest{}, requestID).Error
+// Moves a friend request to a new status on behalf of the user. Only the
+// recipient can accept or decline and only the sender can cancel; users who
+// aren't part of the request get ErrFriendRequestNotFound.
+func (m *DBManager) transitionFriendRequest(userID, requestID uint, status string) (*models.FriendRequest, error) {
+	var request models.FriendRequest
+	err := m.Transaction(func(tx *DBManager) error {
+		err := tx.DB.Clauses(clause.Locking{Strength: "UPDATE"}).First(&request, requestID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && request.FromID != userID && request.ToID != userID) {
+			return ErrFriendRequestNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if status == models.FriendRequestCancelled && request.FromID != userID {
+			return ErrNotRequestSender
+		}
+		if status != models.FriendRequestCancelled && request.ToID != userID {
+			return ErrNotRequestRecipient
+		}
+		if !request.CanTransition(status) {
+			return ErrFriendRequestNotPending
+		}
+
+		now := time.Now()
+		request.Status = status
+		request.RespondedAt = &now
+		return tx.DB.Model(&request).Updates(map[string]interface{}{
+			"status":       status,
+			"responded_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
 }
 
 // GetUsersByUsername searches for users by their username
@@ -610,7 +1083,7 @@ func (m *DBManager) GetHallByID(hallID uint) (*models.DiningHall, error) {
 // get dish information based on ID (used for search)
 func (m *DBManager) GetDishByID(dishID uint) (*models.Dish, error) {
 	var dish models.Dish
-	err := m.DB.Preload("Hall").First(&dish, dishID).Error
+	err := m.DB.Preload("Hall").Preload("Nutrition").Preload("Recipe").First(&dish, dishID).Error
 	if err != nil {
 		return nil, err
 	}
@@ -623,6 +1096,8 @@ func (m *DBManager) UpdateUserProfile(userID uint, username, email string, profi
 	updates := map[string]interface{}{
 		"username": username,
 		"email":    email,
+		// A new email has to be verified again (SET sees the old email)
+		"email_verified": gorm.Expr("email_verified AND email = ?", email),
 	}
 
 	// Only update profile picture if provided
@@ -642,3 +1117,2089 @@ func (m *DBManager) UpdateUserProfile(userID uint, username, email string, profi
 
 	return nil
 }
+
+// GetUserPreferences returns the user's dietary preferences. Users who never
+// saved any get empty preferences, which don't filter anything.
+func (m *DBManager) GetUserPreferences(userID uint) (*models.UserPreferences, error) {
+	var prefs models.UserPreferences
+	err := m.DB.Where("user_id = ?", userID).First(&prefs).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &models.UserPreferences{UserID: userID}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// SaveUserPreferences creates or replaces the user's dietary preferences
+func (m *DBManager) SaveUserPreferences(prefs *models.UserPreferences) error {
+	return m.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"diet_type", "avoid_allergens", "disliked_ingredients", "favorite_halls", "updated_at"}),
+	}).Create(prefs).Error
+}
+
+// GetHallByName returns the hall with the given name (slug)
+func (m *DBManager) GetHallByName(name string) (*models.DiningHall, error) {
+	var hall models.DiningHall
+	if err := m.DB.Where("name = ?", name).First(&hall).Error; err != nil {
+		return nil, err
+	}
+	return &hall, nil
+}
+
+// GetHallHours returns a hall's regular weekly hours
+func (m *DBManager) GetHallHours(hallID uint) ([]models.HallHours, error) {
+	var hours []models.HallHours
+	err := m.DB.Where("hall_id = ?", hallID).Order("day_of_week, opens").Find(&hours).Error
+	return hours, err
+}
+
+// SetHallHours replaces a hall's regular weekly hours
+func (m *DBManager) SetHallHours(hallID uint, hours []models.HallHours) error {
+	return m.Transaction(func(tx *DBManager) error {
+		if err := tx.DB.Where("hall_id = ?", hallID).Delete(&models.HallHours{}).Error; err != nil {
+			return err
+		}
+		if len(hours) == 0 {
+			return nil
+		}
+		for i := range hours {
+			hours[i].ID = 0
+			hours[i].HallID = hallID
+		}
+		return tx.DB.Create(&hours).Error
+	})
+}
+
+// SeedHallHours adds the given hours for any day and meal period of the hall
+// that doesn't have hours yet. Existing hours are never changed.
+func (m *DBManager) SeedHallHours(hours []models.HallHours) error {
+	if len(hours) == 0 {
+		return nil
+	}
+	return m.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&hours).Error
+}
+
+// GetHallHoursOverrides returns a hall's overrides on or after the given date
+func (m *DBManager) GetHallHoursOverrides(hallID uint, from models.Date) ([]models.HallHoursOverride, error) {
+	var overrides []models.HallHoursOverride
+	err := m.DB.
+		Where("hall_id = ? AND (date_year, date_month, date_day) >= (?, ?, ?)", hallID, from.Year, from.Month, from.Day).
+		Order("date_year, date_month, date_day, date_meal_period").
+		Find(&overrides).Error
+	return overrides, err
+}
+
+// AddHallHoursOverride saves an override of a hall's hours for one date
+func (m *DBManager) AddHallHoursOverride(override *models.HallHoursOverride) error {
+	return m.DB.Create(override).Error
+}
+
+// DeleteHallHoursOverride removes one of a hall's overrides
+func (m *DBManager) DeleteHallHoursOverride(hallID uint, overrideID uint) error {
+	result := m.DB.Where("id = ? AND hall_id = ?", overrideID, hallID).Delete(&models.HallHoursOverride{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetHoursForDate returns a hall's hours on a date: its regular hours for
+// that day of the week with the date's overrides applied, ordered by opening time
+func (m *DBManager) GetHoursForDate(hallID uint, date models.Date) ([]models.HallHours, error) {
+	hours, err := m.getHoursForDate(date, hallID)
+	if err != nil {
+		return nil, err
+	}
+	return hours[hallID], nil
+}
+
+// Returns the hours on a date of the given halls (or of every hall if none
+// are given) keyed by hall ID, loading them all at once
+func (m *DBManager) getHoursForDate(date models.Date, hallIDs ...uint) (map[uint][]models.HallHours, error) {
+	weeklyQuery := m.DB.Where("day_of_week = ?", int(date.Time(m.TZ).Weekday()))
+	overrideQuery := m.DB.Where("date_day = ? AND date_month = ? AND date_year = ?", date.Day, date.Month, date.Year)
+	if len(hallIDs) > 0 {
+		weeklyQuery = weeklyQuery.Where("hall_id IN ?", hallIDs)
+		overrideQuery = overrideQuery.Where("hall_id IN ?", hallIDs)
+	}
+
+	var weekly []models.HallHours
+	if err := weeklyQuery.Find(&weekly).Error; err != nil {
+		return nil, err
+	}
+	var overrides []models.HallHoursOverride
+	if err := overrideQuery.Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+
+	weeklyByHall := make(map[uint][]models.HallHours)
+	for _, h := range weekly {
+		weeklyByHall[h.HallID] = append(weeklyByHall[h.HallID], h)
+	}
+	overridesByHall := make(map[uint][]models.HallHoursOverride)
+	for _, o := range overrides {
+		overridesByHall[o.HallID] = append(overridesByHall[o.HallID], o)
+	}
+
+	hours := make(map[uint][]models.HallHours)
+	for hallID, hallWeekly := range weeklyByHall {
+		hours[hallID] = models.ApplyOverrides(hallWeekly, overridesByHall[hallID])
+	}
+	for hallID, hallOverrides := range overridesByHall {
+		if _, ok := weeklyByHall[hallID]; !ok {
+			hours[hallID] = models.ApplyOverrides(nil, hallOverrides)
+		}
+	}
+	for _, hallHours := range hours {
+		sort.Slice(hallHours, func(i, j int) bool {
+			return hallHours[i].Opens < hallHours[j].Opens
+		})
+	}
+	return hours, nil
+}
+
+// GetHallPeriods returns the meal periods a hall serves on a date, with the
+// times they open and close
+func (m *DBManager) GetHallPeriods(hall models.DiningHall, date models.Date) ([]models.HallPeriod, error) {
+	hours, err := m.GetHoursForDate(hall.ID, date)
+	if err != nil {
+		return nil, err
+	}
+	return m.hallPeriods(hall, date, hours), nil
+}
+
+// Turns a hall's hours on a date into the times its meal periods open and close
+func (m *DBManager) hallPeriods(hall models.DiningHall, date models.Date, hours []models.HallHours) []models.HallPeriod {
+	periods := make([]models.HallPeriod, 0, len(hours))
+	for _, h := range hours {
+		opens, closes, err := h.Window(date, m.TZ)
+		if err != nil {
+			fmt.Printf("[DEBUG] Skipping bad hours for %s %s: %v\n", hall.Name, h.MealPeriod, err)
+			continue
+		}
+		periods = append(periods, models.HallPeriod{
+			HallID:     hall.ID,
+			HallName:   hall.Name,
+			Date:       date.String(),
+			MealPeriod: h.MealPeriod,
+			Label:      h.Label,
+			Opens:      opens,
+			Closes:     closes,
+		})
+	}
+	return periods
+}
+
+// GetOpenHallPeriods returns the meal periods being served right now at
+// every hall. Periods that started yesterday and run past midnight count.
+func (m *DBManager) GetOpenHallPeriods(now time.Time) ([]models.HallPeriod, error) {
+	var halls []models.DiningHall
+	if err := m.DB.Order("id").Find(&halls).Error; err != nil {
+		return nil, err
+	}
+
+	now = now.In(m.TZ)
+	days := []models.Date{models.DateOf(now.AddDate(0, 0, -1)), models.DateOf(now)}
+	hoursByDay := make([]map[uint][]models.HallHours, len(days))
+	for i, day := range days {
+		hours, err := m.getHoursForDate(day)
+		if err != nil {
+			return nil, err
+		}
+		hoursByDay[i] = hours
+	}
+
+	var open []models.HallPeriod
+	for _, hall := range halls {
+		for i, day := range days {
+			for _, period := range m.hallPeriods(hall, day, hoursByDay[i][hall.ID]) {
+				if !now.Before(period.Opens) && now.Before(period.Closes) {
+					open = append(open, period)
+				}
+			}
+		}
+	}
+	return open, nil
+}
+
+// GetStoredMealPeriod returns the meal period a hall's menus are stored under
+// for a period label shown to users, e.g. ALL_DAY is stored as BREAKFAST.
+// Labels that aren't used by the hall are returned unchanged.
+func (m *DBManager) GetStoredMealPeriod(hallName string, label string) (string, error) {
+	var periods []string
+	err := m.DB.Model(&models.HallHours{}).
+		Joins("JOIN dining_halls ON dining_halls.id = hall_hours.hall_id").
+		Where("dining_halls.name = ? AND hall_hours.label = ?", hallName, label).
+		Limit(1).
+		Pluck("hall_hours.meal_period", &periods).Error
+	if err != nil || len(periods) == 0 {
+		return label, err
+	}
+	return periods[0], nil
+}
+
+// GetMealPeriodLabels maps the meal periods a hall's menus are stored under
+// to the labels shown to users, e.g. BREAKFAST -> ALL_DAY for bruin-cafe
+func (m *DBManager) GetMealPeriodLabels(hallName string) (map[string]string, error) {
+	var hours []models.HallHours
+	err := m.DB.
+		Joins("JOIN dining_halls ON dining_halls.id = hall_hours.hall_id").
+		Where("dining_halls.name = ?", hallName).
+		Find(&hours).Error
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]string, len(hours))
+	for _, h := range hours {
+		labels[h.MealPeriod] = h.Label
+	}
+	return labels, nil
+}
+
+// GetAllMealPeriodLabels is GetMealPeriodLabels for every hall at once,
+// keyed by hall name
+func (m *DBManager) GetAllMealPeriodLabels() (map[string]map[string]string, error) {
+	var rows []struct {
+		HallName   string
+		MealPeriod string
+		Label      string
+	}
+	err := m.DB.Model(&models.HallHours{}).
+		Select("DISTINCT dining_halls.name AS hall_name, hall_hours.meal_period, hall_hours.label").
+		Joins("JOIN dining_halls ON dining_halls.id = hall_hours.hall_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]map[string]string)
+	for _, row := range rows {
+		if labels[row.HallName] == nil {
+			labels[row.HallName] = make(map[string]string)
+		}
+		labels[row.HallName][row.MealPeriod] = row.Label
+	}
+	return labels, nil
+}
+
+// GetHallsWithoutHours returns the halls that have no regular hours at all
+func (m *DBManager) GetHallsWithoutHours() ([]models.DiningHall, error) {
+	var halls []models.DiningHall
+	err := m.DB.
+		Where("NOT EXISTS (SELECT 1 FROM hall_hours WHERE hall_hours.hall_id = dining_halls.id)").
+		Order("id").
+		Find(&halls).Error
+	return halls, err
+}
+
+// GetServedMealPeriods returns the meal periods a hall has had menus for
+func (m *DBManager) GetServedMealPeriods(hallID uint) ([]string, error) {
+	var periods []string
+	err := m.DB.Model(&models.Menu{}).
+		Where("hall_id = ? AND date_meal_period IS NOT NULL", hallID).
+		Distinct("date_meal_period").
+		Order("date_meal_period").
+		Pluck("date_meal_period", &periods).Error
+	return periods, err
+}
+
+// SeedHalls adds the given halls if they don't exist yet and fills in any
+// metadata that's missing from existing halls. Metadata that is already set
+// (e.g. edited by an admin) is never overwritten.
+func (m *DBManager) SeedHalls(defaults []models.DiningHall) error {
+	defaultsByName := make(map[string]models.DiningHall, len(defaults))
+	for _, seed := range defaults {
+		defaultsByName[seed.Name] = seed
+		hall := seed
+		hall.Slug = seed.Name
+		if err := m.DB.Where(models.DiningHall{Name: seed.Name}).FirstOrCreate(&hall).Error; err != nil {
+			return err
+		}
+	}
+
+	// Halls from before these columns existed have them empty
+	var halls []models.DiningHall
+	if err := m.DB.Where("slug = '' OR display_name = '' OR hall_type = ''").Find(&halls).Error; err != nil {
+		return err
+	}
+	for _, hall := range halls {
+		seed, ok := defaultsByName[hall.Name]
+		if !ok {
+			seed = models.DiningHall{DisplayName: models.DisplayNameFromSlug(hall.Name), HallType: models.HallTypeResidential}
+		}
+
+		updates := map[string]interface{}{}
+		if hall.Slug == "" {
+			updates["slug"] = hall.Name
+		}
+		if hall.DisplayName == "" {
+			updates["display_name"] = seed.DisplayName
+		}
+		if hall.HallType == "" {
+			updates["hall_type"] = seed.HallType
+		}
+		if err := m.DB.Model(&models.DiningHall{}).Where("id = ?", hall.ID).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateHall saves changes to a hall's metadata. The name (slug) can't change
+// since ingest uses it to match menus to the hall.
+func (m *DBManager) UpdateHall(hallID uint, updates map[string]interface{}) error {
+	delete(updates, "name")
+	delete(updates, "slug")
+	if len(updates) == 0 {
+		return nil
+	}
+	return m.DB.Model(&models.DiningHall{}).Where("id = ?", hallID).Updates(updates).Error
+}
+
+var (
+	ErrSameDish               = errors.New("can't merge a dish into itself")
+	ErrDishesInDifferentHalls = errors.New("dishes are in different halls, merge their recipes instead")
+	ErrSameRecipe             = errors.New("can't merge a recipe into itself")
+	ErrDishNameTaken          = errors.New("the hall already has a dish with that name")
+)
+
+// GetOrCreateRecipe returns the recipe for a dish name. Names that another
+// dish already has use that dish's recipe (so merged recipes stay merged),
+// otherwise a recipe with the normalized name is found or created.
+func (m *DBManager) GetOrCreateRecipe(name string, normalized string) (models.Recipe, error) {
+	var recipe models.Recipe
+
+	var dish models.Dish
+	err := m.DB.Where("normalized_name = ? AND recipe_id IS NOT NULL", normalized).Order("id").First(&dish).Error
+	if err == nil {
+		err = m.DB.First(&recipe, *dish.RecipeID).Error
+		return recipe, err
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return recipe, err
+	}
+
+	err = m.DB.
+		Where(models.Recipe{NormalizedName: normalized}).
+		Order("id").
+		Attrs(models.Recipe{Name: name}).
+		FirstOrCreate(&recipe).Error
+	return recipe, err
+}
+
+// GetRecipeByID returns a recipe with all of its dishes and their halls
+func (m *DBManager) GetRecipeByID(recipeID uint) (*models.Recipe, error) {
+	var recipe models.Recipe
+	err := m.DB.Preload("Dishes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("hall_id, id")
+	}).Preload("Dishes.Hall").First(&recipe, recipeID).Error
+	if err != nil {
+		return nil, err
+	}
+	return &recipe, nil
+}
+
+// GetAllRatingsByRecipeID returns the ratings of every dish of a recipe, at any hall
+func (m *DBManager) GetAllRatingsByRecipeID(recipeID uint) ([]models.Rating, error) {
+	var ratings []models.Rating
+	err := m.DB.Preload("User").
+		Joins("JOIN dishes ON dishes.id = ratings.dish_id").
+		Where("dishes.recipe_id = ?", recipeID).
+		Order("ratings.created_at DESC").
+		Find(&ratings).Error
+	return ratings, err
+}
+
+// RecomputeDishRating recalculates a dish's average rating from its ratings
+func (m *DBManager) RecomputeDishRating(dishID uint) error {
+	var average float64
+	if err := m.DB.Model(&models.Rating{}).
+		Where("dish_id = ?", dishID).
+		Select("COALESCE(AVG(score), 0)").
+		Scan(&average).Error; err != nil {
+		return err
+	}
+	return m.DB.Model(&models.Dish{}).Where("id = ?", dishID).Update("average_rating", average).Error
+}
+
+// RecomputeRecipeRating recalculates a recipe's average rating and rating
+// count from the ratings of all of its dishes
+func (m *DBManager) RecomputeRecipeRating(recipeID uint) error {
+	var totals struct {
+		Average float64
+		Count   int64
+	}
+	if err := m.DB.Model(&models.Rating{}).
+		Joins("JOIN dishes ON dishes.id = ratings.dish_id").
+		Where("dishes.recipe_id = ?", recipeID).
+		Select("COALESCE(AVG(ratings.score), 0) AS average, COUNT(ratings.id) AS count").
+		Scan(&totals).Error; err != nil {
+		return err
+	}
+	return m.DB.Model(&models.Recipe{}).Where("id = ?", recipeID).Updates(map[string]interface{}{
+		"average_rating": totals.Average,
+		"rating_count":   totals.Count,
+	}).Error
+}
+
+// Recomputes a recipe's rating, or deletes it if it no longer has any dishes
+func (m *DBManager) refreshRecipe(recipeID *uint) error {
+	if recipeID == nil {
+		return nil
+	}
+	var dishCount int64
+	if err := m.DB.Model(&models.Dish{}).Where("recipe_id = ?", *recipeID).Count(&dishCount).Error; err != nil {
+		return err
+	}
+	if dishCount == 0 {
+		return m.DB.Delete(&models.Recipe{}, *recipeID).Error
+	}
+	return m.RecomputeRecipeRating(*recipeID)
+}
+
+// MergeDishes merges the source dish into the target dish, which must be in
+// the same hall. The source's ratings, menu appearances and dietary info
+// (if the target has none) move to the target, the source's name becomes an
+// alias of the target, and the source is deleted. Users who rated both
+// dishes keep only their latest rating.
+func (m *DBManager) MergeDishes(sourceID uint, targetID uint) error {
+	if sourceID == targetID {
+		return ErrSameDish
+	}
+
+	return m.Transaction(func(tx *DBManager) error {
+		var source, target models.Dish
+		if err := tx.DB.Preload("Nutrition").First(&source, sourceID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Preload("Nutrition").First(&target, targetID).Error; err != nil {
+			return err
+		}
+		if source.HallID != target.HallID {
+			return ErrDishesInDifferentHalls
+		}
+
+		if err := tx.deleteDuplicateRatings(sourceID, targetID); err != nil {
+			return err
+		}
+		if err := tx.DB.Model(&models.Rating{}).Where("dish_id = ?", sourceID).Update("dish_id", targetID).Error; err != nil {
+			return err
+		}
+
+		// Menus that list both dishes only keep the target
+		if err := tx.DB.Exec(`DELETE FROM menu_dishes WHERE dish_id = ? AND menu_id IN
+			(SELECT menu_id FROM menu_dishes WHERE dish_id = ?)`, sourceID, targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Exec("UPDATE menu_dishes SET dish_id = ? WHERE dish_id = ?", targetID, sourceID).Error; err != nil {
+			return err
+		}
+
+		if target.Nutrition == nil && source.Nutrition != nil {
+			if err := tx.DB.Model(&models.DishNutrition{}).Where("dish_id = ?", sourceID).Update("dish_id", targetID).Error; err != nil {
+				return err
+			}
+		} else if err := tx.DB.Where("dish_id = ?", sourceID).Delete(&models.DishNutrition{}).Error; err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{}
+		if len(target.Tags) == 0 && len(source.Tags) > 0 {
+			updates["tags"] = source.Tags
+		}
+		if target.LastSeenDate.Before(source.LastSeenDate) {
+			updates["last_seen_date_day"] = source.LastSeenDate.Day
+			updates["last_seen_date_month"] = source.LastSeenDate.Month
+			updates["last_seen_date_year"] = source.LastSeenDate.Year
+		}
+		if len(updates) > 0 {
+			if err := tx.DB.Model(&models.Dish{}).Where("id = ?", targetID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		// Users following both dishes only keep the target
+		if err := tx.DB.Exec(`DELETE FROM favorites WHERE dish_id = ? AND user_id IN
+			(SELECT user_id FROM favorites WHERE dish_id = ?)`, sourceID, targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Model(&models.Favorite{}).Where("dish_id = ?", sourceID).Update("dish_id", targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Model(&models.Activity{}).Where("dish_id = ?", sourceID).Update("dish_id", targetID).Error; err != nil {
+			return err
+		}
+
+		// Keep future menus listing the source's name on the target
+		if err := tx.DB.Model(&models.DishAlias{}).Where("dish_id = ?", sourceID).Update("dish_id", targetID).Error; err != nil {
+			return err
+		}
+		if source.NormalizedName != "" && source.NormalizedName != target.NormalizedName {
+			alias := models.DishAlias{HallID: source.HallID, NormalizedName: source.NormalizedName, DishID: targetID}
+			if err := tx.DB.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "hall_id"}, {Name: "normalized_name"}},
+				DoUpdates: clause.AssignmentColumns([]string{"dish_id"}),
+			}).Create(&alias).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.DB.Delete(&models.Dish{}, sourceID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.RecomputeDishRating(targetID); err != nil {
+			return err
+		}
+		if err := tx.refreshRecipe(target.RecipeID); err != nil {
+			return err
+		}
+		if source.RecipeID != nil && (target.RecipeID == nil || *source.RecipeID != *target.RecipeID) {
+			return tx.refreshRecipe(source.RecipeID)
+		}
+		return nil
+	})
+}
+
+// Deletes the older ratings (and their replies and feed activities) of users
+// who rated both dishes, so that after a merge each of them has one rating of the dish
+func (m *DBManager) deleteDuplicateRatings(sourceID uint, targetID uint) error {
+	var olderIDs []uint
+	if err := m.DB.Raw(`SELECT DISTINCT older.id FROM ratings older
+		JOIN ratings newer ON newer.user_id = older.user_id AND newer.dish_id IN (@source, @target)
+			AND (newer.created_at, newer.id) > (older.created_at, older.id)
+		WHERE older.dish_id IN (@source, @target)
+		AND older.user_id IN (SELECT user_id FROM ratings WHERE dish_id = @source)
+		AND older.user_id IN (SELECT user_id FROM ratings WHERE dish_id = @target)`,
+		map[string]interface{}{"source": sourceID, "target": targetID}).
+		Scan(&olderIDs).Error; err != nil {
+		return err
+	}
+	if len(olderIDs) == 0 {
+		return nil
+	}
+	if err := m.DB.Where("rating_id IN ?", olderIDs).Delete(&models.RatingReply{}).Error; err != nil {
+		return err
+	}
+	if err := m.DB.Where("rating_id IN ?", olderIDs).Delete(&models.Activity{}).Error; err != nil {
+		return err
+	}
+	return m.DB.Delete(&models.Rating{}, olderIDs).Error
+}
+
+// SplitDish undoes a bad merge: it creates a new dish in the same hall with
+// the given name and moves the given menu appearances and ratings to it.
+// With separateRecipe the new dish gets a recipe of its own, otherwise it
+// uses the recipe for its name. Returns the new dish.
+func (m *DBManager) SplitDish(dishID uint, name string, menuIDs []uint, ratingIDs []uint, separateRecipe bool) (*models.Dish, error) {
+	name = strings.TrimSpace(name)
+	normalized := models.NormalizeDishName(name)
+
+	var newDish models.Dish
+	err := m.Transaction(func(tx *DBManager) error {
+		var dish models.Dish
+		if err := tx.DB.First(&dish, dishID).Error; err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.DB.Model(&models.Dish{}).
+			Where("hall_id = ? AND normalized_name = ?", dish.HallID, normalized).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrDishNameTaken
+		}
+
+		var recipe models.Recipe
+		var err error
+		if separateRecipe {
+			recipe = models.Recipe{Name: name, NormalizedName: normalized}
+			err = tx.DB.Create(&recipe).Error
+		} else {
+			recipe, err = tx.GetOrCreateRecipe(name, normalized)
+		}
+		if err != nil {
+			return err
+		}
+
+		// The new dish was last seen on the latest menu it's taking
+		lastSeen := models.Date{}
+		var menus []models.Menu
+		if len(menuIDs) > 0 {
+			if err := tx.DB.
+				Joins("JOIN menu_dishes ON menu_dishes.menu_id = menus.id").
+				Where("menu_dishes.dish_id = ? AND menus.id IN ?", dishID, menuIDs).
+				Find(&menus).Error; err != nil {
+				return err
+			}
+		}
+		for _, menu := range menus {
+			if lastSeen.Before(menu.Date) {
+				lastSeen = menu.Date
+			}
+		}
+		lastSeen.MealPeriod = nil
+
+		newDish = models.Dish{
+			HallID:         dish.HallID,
+			Name:           name,
+			NormalizedName: normalized,
+			RecipeID:       &recipe.ID,
+			Description:    dish.Description,
+			Tags:           dish.Tags,
+			Location:       dish.Location,
+			LastSeenDate:   lastSeen,
+		}
+		if err := tx.DB.Create(&newDish).Error; err != nil {
+			return err
+		}
+
+		if len(menuIDs) > 0 {
+			if err := tx.DB.Exec("UPDATE menu_dishes SET dish_id = ? WHERE dish_id = ? AND menu_id IN ?",
+				newDish.ID, dishID, menuIDs).Error; err != nil {
+				return err
+			}
+		}
+		if len(ratingIDs) > 0 {
+			if err := tx.DB.Model(&models.Rating{}).
+				Where("dish_id = ? AND id IN ?", dishID, ratingIDs).
+				Update("dish_id", newDish.ID).Error; err != nil {
+				return err
+			}
+			if err := tx.DB.Model(&models.Activity{}).
+				Where("dish_id = ? AND rating_id IN ?", dishID, ratingIDs).
+				Updates(map[string]interface{}{"dish_id": newDish.ID, "recipe_id": recipe.ID}).Error; err != nil {
+				return err
+			}
+		}
+
+		// If the name was an alias of the old dish, ingest should now use the new one
+		if err := tx.DB.Where("hall_id = ? AND normalized_name = ?", dish.HallID, normalized).
+			Delete(&models.DishAlias{}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.RecomputeDishRating(dishID); err != nil {
+			return err
+		}
+		if err := tx.RecomputeDishRating(newDish.ID); err != nil {
+			return err
+		}
+		if err := tx.refreshRecipe(dish.RecipeID); err != nil {
+			return err
+		}
+		if dish.RecipeID == nil || *dish.RecipeID != recipe.ID {
+			return tx.refreshRecipe(&recipe.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &newDish, m.DB.First(&newDish, newDish.ID).Error
+}
+
+// MergeRecipes moves every dish of the source recipe to the target recipe
+// and deletes the source
+func (m *DBManager) MergeRecipes(sourceID uint, targetID uint) error {
+	if sourceID == targetID {
+		return ErrSameRecipe
+	}
+
+	return m.Transaction(func(tx *DBManager) error {
+		var source, target models.Recipe
+		if err := tx.DB.First(&source, sourceID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.First(&target, targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Model(&models.Dish{}).Where("recipe_id = ?", sourceID).Update("recipe_id", targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Exec(`DELETE FROM favorites WHERE recipe_id = ? AND user_id IN
+			(SELECT user_id FROM favorites WHERE recipe_id = ?)`, sourceID, targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Model(&models.Favorite{}).Where("recipe_id = ?", sourceID).Update("recipe_id", targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Model(&models.Activity{}).Where("recipe_id = ?", sourceID).Update("recipe_id", targetID).Error; err != nil {
+			return err
+		}
+		if err := tx.DB.Delete(&models.Recipe{}, sourceID).Error; err != nil {
+			return err
+		}
+		return tx.RecomputeRecipeRating(targetID)
+	})
+}
+
+// NormalizeDishes gives dishes from before recipes existed a normalized name
+// and a recipe. Dishes in the same hall whose names only differ in
+// capitalization, spacing or punctuation are merged into the oldest one.
+// Returns the number of dishes merged away.
+func (m *DBManager) NormalizeDishes() (int, error) {
+	var dishes []models.Dish
+	if err := m.DB.Where("normalized_name = '' OR recipe_id IS NULL").Order("id").Find(&dishes).Error; err != nil {
+		return 0, err
+	}
+
+	merged := 0
+	for _, dish := range dishes {
+		normalized := models.NormalizeDishName(dish.Name)
+
+		var existing models.Dish
+		err := m.DB.Where("hall_id = ? AND normalized_name = ? AND id <> ?", dish.HallID, normalized, dish.ID).
+			Order("id").
+			First(&existing).Error
+		if err == nil {
+			if err := m.MergeDishes(dish.ID, existing.ID); err != nil {
+				return merged, fmt.Errorf("merging dish %d into %d: %w", dish.ID, existing.ID, err)
+			}
+			merged++
+			continue
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return merged, err
+		}
+
+		recipe, err := m.GetOrCreateRecipe(strings.TrimSpace(dish.Name), normalized)
+		if err != nil {
+			return merged, err
+		}
+		if err := m.DB.Model(&models.Dish{}).Where("id = ?", dish.ID).Updates(map[string]interface{}{
+			"normalized_name": normalized,
+			"recipe_id":       recipe.ID,
+		}).Error; err != nil {
+			return merged, err
+		}
+		if err := m.RecomputeRecipeRating(recipe.ID); err != nil {
+			return merged, err
+		}
+	}
+	return merged, nil
+}
+
+// GetDishAppearances returns every menu the given dishes were served on, in the order they were served
+func (m *DBManager) GetDishAppearances(dishIDs []uint) ([]models.DishAppearance, error) {
+	var rows []struct {
+		DishID         uint
+		DateDay        int
+		DateMonth      int
+		DateYear       int
+		DateMealPeriod string
+		HallName       string
+		Opens          *string
+		StationName    *string
+	}
+	err := m.DB.Table("menu_dishes").
+		Select("menu_dishes.dish_id, menus.date_day, menus.date_month, menus.date_year, menus.date_meal_period, "+
+			"dining_halls.name AS hall_name, hall_hours.opens, stations.name AS station_name").
+		Joins("JOIN menus ON menus.id = menu_dishes.menu_id").
+		Joins("JOIN dining_halls ON dining_halls.id = menus.hall_id").
+		Joins("LEFT JOIN hall_hours ON hall_hours.hall_id = menus.hall_id AND hall_hours.meal_period = menus.date_meal_period "+
+			"AND hall_hours.day_of_week = EXTRACT(DOW FROM "+menuDateSQL+")").
+		Joins("LEFT JOIN stations ON stations.id = menu_dishes.station_id").
+		Where("menu_dishes.dish_id IN ?", dishIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	appearances := make([]models.DishAppearance, 0, len(rows))
+	for _, row := range rows {
+		date := models.Date{Day: row.DateDay, Month: row.DateMonth, Year: row.DateYear}
+		appearances = append(appearances, models.DishAppearance{
+			DishID:     row.DishID,
+			Date:       date.String(),
+			Weekday:    date.Time(m.TZ).Weekday().String(),
+			HallName:   row.HallName,
+			MealPeriod: row.DateMealPeriod,
+			Opens:      row.Opens,
+			Station:    row.StationName,
+		})
+	}
+	models.SortAppearances(appearances)
+	return appearances, nil
+}
+
+// BackfillFirstSeenDates sets the first seen date of dishes from before it
+// was recorded to the date of the earliest menu they appear on
+func (m *DBManager) BackfillFirstSeenDates() error {
+	return m.DB.Exec(`UPDATE dishes SET first_seen_date = first_menus.first_date
+		FROM (
+			SELECT menu_dishes.dish_id, MIN(make_date(menus.date_year, menus.date_month, menus.date_day)) AS first_date
+			FROM menu_dishes JOIN menus ON menus.id = menu_dishes.menu_id
+			GROUP BY menu_dishes.dish_id
+		) AS first_menus
+		WHERE dishes.first_seen_date IS NULL AND dishes.id = first_menus.dish_id`).Error
+}
+
+// menuDateSQL turns a menu's date columns into a date for comparisons
+const menuDateSQL = "make_date(menus.date_year, menus.date_month, menus.date_day)"
+
+// GetMenuDiff compares a menu against the same hall and meal period over the
+// previous weeks:
+//   - new dishes have never been served before this date
+//   - returning dishes weren't served in those weeks but were before them
+//   - dropped dishes are regulars on this weekday (served on it in at least
+//     half of those weeks) that aren't on this menu
+func (m *DBManager) GetMenuDiff(menu *models.Menu, weeks int) (*models.MenuDiff, error) {
+	date := menu.Date.Time(time.UTC)
+	windowStart := date.AddDate(0, 0, -7*weeks)
+	diff := &models.MenuDiff{
+		Date:       menu.Date.String(),
+		MealPeriod: *menu.Date.MealPeriod,
+		Weeks:      weeks,
+		New:        []models.DiffDish{},
+		Returning:  []models.DiffDish{},
+		Dropped:    []models.DiffDish{},
+	}
+
+	onMenu := make(map[uint]bool, len(menu.Dishes))
+	dishIDs := make([]uint, 0, len(menu.Dishes))
+	for _, dish := range menu.Dishes {
+		onMenu[dish.ID] = true
+		dishIDs = append(dishIDs, dish.ID)
+	}
+
+	// When each dish on the menu was last served before this date
+	lastServed := make(map[uint]time.Time)
+	if len(dishIDs) > 0 {
+		var rows []struct {
+			DishID     uint
+			LastServed time.Time
+		}
+		if err := m.DB.Table("menu_dishes").
+			Select("menu_dishes.dish_id, MAX("+menuDateSQL+") AS last_served").
+			Joins("JOIN menus ON menus.id = menu_dishes.menu_id").
+			Where("menu_dishes.dish_id IN ? AND "+menuDateSQL+" < ?", dishIDs, menu.Date.String()).
+			Group("menu_dishes.dish_id").
+			Scan(&rows).Error; err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			lastServed[row.DishID] = row.LastServed
+		}
+	}
+
+	for _, dish := range menu.Dishes {
+		last, servedBefore := lastServed[dish.ID]
+		switch {
+		case !servedBefore && (dish.FirstSeenDate == nil || !dish.FirstSeenDate.Before(date)):
+			diff.New = append(diff.New, models.DiffDish{Dish: dish})
+		case servedBefore && last.Before(windowStart):
+			lastDate := models.DateOf(last).String()
+			diff.Returning = append(diff.Returning, models.DiffDish{Dish: dish, LastServed: &lastDate})
+		}
+	}
+
+	// Regulars on this weekday and meal period that aren't on the menu
+	var regulars []struct {
+		DishID     uint
+		Weeks      int
+		LastServed time.Time
+	}
+	if err := m.DB.Table("menu_dishes").
+		Select("menu_dishes.dish_id, COUNT(DISTINCT "+menuDateSQL+") AS weeks, MAX("+menuDateSQL+") AS last_served").
+		Joins("JOIN menus ON menus.id = menu_dishes.menu_id").
+		Where("menus.hall_id = ? AND menus.date_meal_period = ?", menu.HallID, *menu.Date.MealPeriod).
+		Where(menuDateSQL+" >= ? AND "+menuDateSQL+" < ?", models.DateOf(windowStart).String(), menu.Date.String()).
+		Where("EXTRACT(DOW FROM "+menuDateSQL+") = ?", int(date.Weekday())).
+		Group("menu_dishes.dish_id").
+		Having("COUNT(DISTINCT "+menuDateSQL+") * 2 >= ?", weeks).
+		Scan(&regulars).Error; err != nil {
+		return nil, err
+	}
+
+	droppedIDs := []uint{}
+	droppedLast := make(map[uint]string)
+	for _, regular := range regulars {
+		if !onMenu[regular.DishID] {
+			droppedIDs = append(droppedIDs, regular.DishID)
+			droppedLast[regular.DishID] = models.DateOf(regular.LastServed).String()
+		}
+	}
+	if len(droppedIDs) > 0 {
+		var dropped []models.Dish
+		if err := m.DB.Preload("Nutrition").Where("id IN ?", droppedIDs).Order("name").Find(&dropped).Error; err != nil {
+			return nil, err
+		}
+		for _, dish := range dropped {
+			last := droppedLast[dish.ID]
+			diff.Dropped = append(diff.Dropped, models.DiffDish{Dish: dish, LastServed: &last})
+		}
+	}
+
+	return diff, nil
+}
+
+// GetNewDishes returns dishes first served on or after the given date (this
+// includes menus that are posted ahead of time), newest first
+func (m *DBManager) GetNewDishes(since models.Date, hallName string, limit int) ([]models.Dish, error) {
+	query := m.DB.Preload("Hall").Preload("Nutrition").
+		Where("dishes.first_seen_date >= ?", since.String()).
+		Order("dishes.first_seen_date DESC, dishes.id DESC").
+		Limit(limit)
+	if hallName != "" {
+		query = query.Joins("JOIN dining_halls ON dining_halls.id = dishes.hall_id").
+			Where("dining_halls.name = ?", hallName)
+	}
+
+	var dishes []models.Dish
+	err := query.Find(&dishes).Error
+	return dishes, err
+}
+
+// AddFavorite makes the user follow a dish or, if recipeID is set instead,
+// a recipe. Adding a favorite that already exists returns the existing one.
+func (m *DBManager) AddFavorite(userID uint, dishID *uint, recipeID *uint) (*models.Favorite, error) {
+	if dishID != nil {
+		if err := m.DB.First(&models.Dish{}, *dishID).Error; err != nil {
+			return nil, err
+		}
+	} else if err := m.DB.First(&models.Recipe{}, *recipeID).Error; err != nil {
+		return nil, err
+	}
+
+	favorite := models.Favorite{UserID: userID, DishID: dishID, RecipeID: recipeID}
+	query := m.DB.Where("user_id = ?", userID)
+	if dishID != nil {
+		query = query.Where("dish_id = ?", *dishID)
+	} else {
+		query = query.Where("recipe_id = ?", *recipeID)
+	}
+	result := query.FirstOrCreate(&favorite)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		m.recordFavoriteActivity(&favorite)
+	}
+	return &favorite, nil
+}
+
+// Adds a new favorite to the feed
+func (m *DBManager) recordFavoriteActivity(favorite *models.Favorite) {
+	activity := models.Activity{UserID: favorite.UserID, Type: models.ActivityFavorite, DishID: favorite.DishID, RecipeID: favorite.RecipeID}
+	data := map[string]interface{}{}
+	if favorite.DishID != nil {
+		dish, err := m.GetDishByID(*favorite.DishID)
+		if err != nil {
+			return
+		}
+		activity.HallID = &dish.HallID
+		data["dish_name"] = dish.Name
+		data["hall_name"] = hallDisplayName(&dish.Hall)
+	} else {
+		var recipe models.Recipe
+		if err := m.DB.First(&recipe, *favorite.RecipeID).Error; err != nil {
+			return
+		}
+		data["recipe_name"] = recipe.Name
+	}
+	m.recordActivity(&activity, data)
+}
+
+// RemoveFavorite deletes one of the user's favorites
+func (m *DBManager) RemoveFavorite(userID uint, favoriteID uint) error {
+	result := m.DB.Where("id = ? AND user_id = ?", favoriteID, userID).Delete(&models.Favorite{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetFavorites returns the user's favorites with their dishes or recipes, newest first
+func (m *DBManager) GetFavorites(userID uint) ([]models.Favorite, error) {
+	var favorites []models.Favorite
+	err := m.DB.Preload("Dish.Hall").Preload("Recipe").
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&favorites).Error
+	return favorites, err
+}
+
+// GetFavoriteMatches finds users' favorite dishes (directly or through their
+// recipe) on the given menus. Each user gets at most one match per dish and menu.
+func (m *DBManager) GetFavoriteMatches(menuIDs []uint) ([]models.FavoriteMatch, error) {
+	if len(menuIDs) == 0 {
+		return nil, nil
+	}
+
+	var rows []struct {
+		UserID          uint
+		DishID          uint
+		DishName        string
+		MenuID          uint
+		HallName        string
+		HallDisplayName string
+		DateDay         int
+		DateMonth       int
+		DateYear        int
+		DateMealPeriod  string
+	}
+	err := m.DB.Table("menu_dishes").
+		Select("DISTINCT favorites.user_id, dishes.id AS dish_id, dishes.name AS dish_name, menus.id AS menu_id, "+
+			"dining_halls.name AS hall_name, dining_halls.display_name AS hall_display_name, "+
+			"menus.date_day, menus.date_month, menus.date_year, menus.date_meal_period").
+		Joins("JOIN menus ON menus.id = menu_dishes.menu_id").
+		Joins("JOIN dishes ON dishes.id = menu_dishes.dish_id").
+		Joins("JOIN dining_halls ON dining_halls.id = menus.hall_id").
+		Joins("JOIN favorites ON favorites.dish_id = dishes.id OR favorites.recipe_id = dishes.recipe_id").
+		Where("menu_dishes.menu_id IN ?", menuIDs).
+		Order("favorites.user_id, menus.id, dishes.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]models.FavoriteMatch, 0, len(rows))
+	for _, row := range rows {
+		mealPeriod := row.DateMealPeriod
+		matches = append(matches, models.FavoriteMatch{
+			UserID:          row.UserID,
+			DishID:          row.DishID,
+			DishName:        row.DishName,
+			MenuID:          row.MenuID,
+			HallName:        row.HallName,
+			HallDisplayName: row.HallDisplayName,
+			Date:            models.Date{Day: row.DateDay, Month: row.DateMonth, Year: row.DateYear, MealPeriod: &mealPeriod},
+		})
+	}
+	return matches, nil
+}
+
+// CreateNotifications saves messages as in-app notifications, leaving out the
+// ones whose type the user muted. It can be used as a notify.Notifier with notify.Func.
+func (m *DBManager) CreateNotifications(messages ...notify.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	messages, err := m.FilterMutedMessages(messages)
+	if err != nil {
+		return err
+	}
+
+	notifications := make([]models.Notification, 0, len(messages))
+	for _, message := range messages {
+		notification := models.Notification{
+			UserID: message.UserID,
+			Type:   message.Type,
+			Title:  message.Title,
+			Body:   message.Body,
+		}
+		if message.Data != nil {
+			data, err := json.Marshal(message.Data)
+			if err != nil {
+				return fmt.Errorf("notification data: %w", err)
+			}
+			notification.Data = data
+		}
+		notifications = append(notifications, notification)
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := m.DB.CreateInBatches(&notifications, 100).Error; err != nil {
+		return err
+	}
+
+	evts := make([]events.Event, len(notifications))
+	for i, notification := range notifications {
+		evts[i] = events.Event{Type: events.TypeNotification, UserID: notification.UserID, Data: notification}
+	}
+	m.Publish(evts...)
+	return nil
+}
+
+// FilterMutedMessages leaves out the messages whose type their user muted
+func (m *DBManager) FilterMutedMessages(messages []notify.Message) ([]notify.Message, error) {
+	if len(messages) == 0 {
+		return messages, nil
+	}
+	userIDs := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		userIDs = append(userIDs, message.UserID)
+	}
+	var mutes []models.NotificationMute
+	if err := m.DB.Where("user_id IN ?", userIDs).Find(&mutes).Error; err != nil {
+		return nil, err
+	}
+	muted := make(map[models.NotificationMute]bool, len(mutes))
+	for _, mute := range mutes {
+		muted[models.NotificationMute{UserID: mute.UserID, Type: mute.Type}] = true
+	}
+
+	unmuted := make([]notify.Message, 0, len(messages))
+	for _, message := range messages {
+		if !muted[models.NotificationMute{UserID: message.UserID, Type: message.Type}] {
+			unmuted = append(unmuted, message)
+		}
+	}
+	return unmuted, nil
+}
+
+// GetNotifications returns a user's notifications, newest first. If beforeID
+// is set only older notifications are returned, for paging.
+func (m *DBManager) GetNotifications(userID uint, unreadOnly bool, beforeID uint, limit int) ([]models.Notification, error) {
+	var notifications []models.Notification
+	query := m.DB.Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	if beforeID > 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+	err := query.Order("id DESC").Limit(limit).Find(&notifications).Error
+	return notifications, err
+}
+
+// CountUnreadNotifications returns how many of a user's notifications haven't been read
+func (m *DBManager) CountUnreadNotifications(userID uint) (int64, error) {
+	var count int64
+	err := m.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// MarkNotificationRead marks one of a user's notifications as read
+func (m *DBManager) MarkNotificationRead(userID uint, notificationID uint) error {
+	var notification models.Notification
+	if err := m.DB.Where("id = ? AND user_id = ?", notificationID, userID).First(&notification).Error; err != nil {
+		return err
+	}
+	if notification.ReadAt != nil {
+		return nil
+	}
+	return m.DB.Model(&notification).Update("read_at", time.Now()).Error
+}
+
+// MarkAllNotificationsRead marks every unread notification of a user as read,
+// returning how many there were
+func (m *DBManager) MarkAllNotificationsRead(userID uint) (int64, error) {
+	result := m.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// GetNotificationMutes returns the notification types a user muted
+func (m *DBManager) GetNotificationMutes(userID uint) ([]string, error) {
+	types := []string{}
+	err := m.DB.Model(&models.NotificationMute{}).Where("user_id = ?", userID).Order("type").Pluck("type", &types).Error
+	return types, err
+}
+
+// SetNotificationMutes replaces the notification types a user muted
+func (m *DBManager) SetNotificationMutes(userID uint, types []string) error {
+	return m.Transaction(func(tx *DBManager) error {
+		if err := tx.DB.Where("user_id = ?", userID).Delete(&models.NotificationMute{}).Error; err != nil {
+			return err
+		}
+		if len(types) == 0 {
+			return nil
+		}
+		mutes := make([]models.NotificationMute, len(types))
+		for i, notificationType := range types {
+			mutes[i] = models.NotificationMute{UserID: userID, Type: notificationType}
+		}
+		return tx.DB.Create(&mutes).Error
+	})
+}
+
+// GetFriendIDsWhoRatedDish returns the user's friends who rated the dish
+func (m *DBManager) GetFriendIDsWhoRatedDish(userID uint, dishID uint) ([]uint, error) {
+	var friendIDs []uint
+	err := m.DB.Raw(`
+		SELECT DISTINCT r.user_id FROM ratings r
+		JOIN friendships f ON (
+			(f.user_id = ? AND f.friend_id = r.user_id) OR
+			(f.friend_id = ? AND f.user_id = r.user_id)
+		)
+		WHERE r.dish_id = ? AND r.user_id != ?
+	`, userID, userID, dishID, userID).Scan(&friendIDs).Error
+	return friendIDs, err
+}
+
+// GetRatingByID retrieves a rating with its user and dish
+func (m *DBManager) GetRatingByID(ratingID uint) (*models.Rating, error) {
+	var rating models.Rating
+	if err := m.DB.Preload("User").Preload("Dish").First(&rating, ratingID).Error; err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+// CreateRatingReply adds a reply to a rating's comment
+func (m *DBManager) CreateRatingReply(reply *models.RatingReply) error {
+	return m.DB.Create(reply).Error
+}
+
+// GetRatingReplies returns the replies to a rating, oldest first
+func (m *DBManager) GetRatingReplies(ratingID uint) ([]models.RatingReply, error) {
+	var replies []models.RatingReply
+	err := m.DB.Preload("User").Where("rating_id = ?", ratingID).Order("created_at, id").Find(&replies).Error
+	return replies, err
+}
+
+// Returns a random hex token that's safe to put in links
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetEmailPreferences returns a user's email preferences, creating them (opted
+// out of everything) if the user doesn't have any yet
+func (m *DBManager) GetEmailPreferences(userID uint) (*models.EmailPreferences, error) {
+	var prefs models.EmailPreferences
+	err := m.DB.Where("user_id = ?", userID).First(&prefs).Error
+	if err == nil {
+		return &prefs, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	prefs = models.EmailPreferences{UserID: userID, UnsubscribeToken: token}
+	err = m.DB.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, DoNothing: true}).Create(&prefs).Error
+	if err != nil {
+		return nil, err
+	}
+	// Another request may have created them first
+	if err := m.DB.Where("user_id = ?", userID).First(&prefs).Error; err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// UpdateEmailPreferences sets which emails a user gets
+func (m *DBManager) UpdateEmailPreferences(userID uint, digest bool, notifications bool) (*models.EmailPreferences, error) {
+	prefs, err := m.GetEmailPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	prefs.Digest = digest
+	prefs.Notifications = notifications
+	if err := m.DB.Model(prefs).Select("digest", "notifications", "updated_at").Updates(prefs).Error; err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// GetEmailPreferencesByUnsubscribeToken returns the email preferences with the unsubscribe token
+func (m *DBManager) GetEmailPreferencesByUnsubscribeToken(token string) (*models.EmailPreferences, error) {
+	var prefs models.EmailPreferences
+	if err := m.DB.Where("unsubscribe_token = ?", token).First(&prefs).Error; err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// Unsubscribe turns off emails for the user with the unsubscribe token. list
+// is "digest", "notifications" or "" for both.
+func (m *DBManager) Unsubscribe(token string, list string) (*models.EmailPreferences, error) {
+	prefs, err := m.GetEmailPreferencesByUnsubscribeToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if list == "" || list == "digest" {
+		prefs.Digest = false
+	}
+	if list == "" || list == "notifications" {
+		prefs.Notifications = false
+	}
+	if err := m.DB.Model(prefs).Select("digest", "notifications", "updated_at").Updates(prefs).Error; err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// GetDigestRecipients returns the users with a verified email opted in to
+// the digest who haven't been sent one for the date yet
+func (m *DBManager) GetDigestRecipients(date models.Date) ([]models.EmailRecipient, error) {
+	var recipients []models.EmailRecipient
+	err := m.DB.Table("email_preferences").
+		Select("users.id AS user_id, users.username, users.email, email_preferences.unsubscribe_token").
+		Joins("JOIN users ON users.id = email_preferences.user_id AND users.deleted_at IS NULL").
+		Where("email_preferences.digest AND users.email_verified AND (email_preferences.last_digest_date IS NULL OR email_preferences.last_digest_date < ?)", date.String()).
+		Order("users.id").
+		Scan(&recipients).Error
+	return recipients, err
+}
+
+// MarkDigestSent records that a user was sent the digest for the date
+func (m *DBManager) MarkDigestSent(userID uint, date models.Date) error {
+	return m.DB.Model(&models.EmailPreferences{}).
+		Where("user_id = ?", userID).
+		Update("last_digest_date", date.String()).Error
+}
+
+// GetNotificationEmailRecipients returns which of the users opted in to
+// notification emails and verified their email
+func (m *DBManager) GetNotificationEmailRecipients(userIDs []uint) ([]models.EmailRecipient, error) {
+	var recipients []models.EmailRecipient
+	if len(userIDs) == 0 {
+		return recipients, nil
+	}
+	err := m.DB.Table("email_preferences").
+		Select("users.id AS user_id, users.username, users.email, email_preferences.unsubscribe_token").
+		Joins("JOIN users ON users.id = email_preferences.user_id AND users.deleted_at IS NULL").
+		Where("email_preferences.notifications AND users.email_verified AND users.id IN ?", userIDs).
+		Scan(&recipients).Error
+	return recipients, err
+}
+
+// ErrInvalidToken is returned for tokens that don't exist, have expired or were already used
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Tokens are random, so a plain hash is enough to keep them useless if the table leaks
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetUserByEmail retrieves a user by their email, ignoring case
+func (m *DBManager) GetUserByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := m.DB.Where("LOWER(email) = LOWER(?)", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateUserToken creates a token for the user, sent to the email, that can
+// be used once before it expires. Earlier unused tokens for the same purpose
+// stop working. Only the returned token can be used, its hash is stored.
+func (m *DBManager) CreateUserToken(userID uint, purpose string, email string, ttl time.Duration) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = m.Transaction(func(tx *DBManager) error {
+		if err := tx.DB.Model(&models.UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.DB.Create(&models.UserToken{
+			UserID:    userID,
+			Purpose:   purpose,
+			TokenHash: hashToken(token),
+			Email:     email,
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CountRecentUserTokens returns how many tokens for the purpose were sent to the email since the given time
+func (m *DBManager) CountRecentUserTokens(email string, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := m.DB.Model(&models.UserToken{}).
+		Where("LOWER(email) = LOWER(?) AND purpose = ? AND created_at >= ?", email, purpose, since).
+		Count(&count).Error
+	return count, err
+}
+
+// Marks a token as used, returning ErrInvalidToken if it can't be used
+func (m *DBManager) consumeUserToken(token string, purpose string) (*models.UserToken, error) {
+	var userToken models.UserToken
+	err := m.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), purpose, time.Now()).
+		First(&userToken).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := m.DB.Model(&userToken).Update("used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+	return &userToken, nil
+}
+
+// VerifyEmail marks the email a verification token was sent to as verified.
+// Tokens sent to an address the user has since changed from don't work.
+func (m *DBManager) VerifyEmail(token string) (*models.User, error) {
+	var user models.User
+	err := m.Transaction(func(tx *DBManager) error {
+		userToken, err := tx.consumeUserToken(token, models.TokenVerifyEmail)
+		if err != nil {
+			return err
+		}
+		if err := tx.DB.First(&user, userToken.UserID).Error; err != nil {
+			return err
+		}
+		if !strings.EqualFold(user.Email, userToken.Email) {
+			return ErrInvalidToken
+		}
+		user.EmailVerified = true
+		return tx.DB.Model(&user).Update("email_verified", true).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ResetPassword sets a new (already hashed) password using a password reset
+// token and logs the user out everywhere. Receiving the token also proves
+// the user owns the email, so it's marked as verified.
+func (m *DBManager) ResetPassword(token string, hashedPassword string) (*models.User, error) {
+	var user models.User
+	err := m.Transaction(func(tx *DBManager) error {
+		userToken, err := tx.consumeUserToken(token, models.TokenResetPassword)
+		if err != nil {
+			return err
+		}
+		if err := tx.DB.First(&user, userToken.UserID).Error; err != nil {
+			return err
+		}
+		if !strings.EqualFold(user.Email, userToken.Email) {
+			return ErrInvalidToken
+		}
+		user.EmailVerified = true
+		if err := tx.DB.Model(&user).Updates(map[string]interface{}{
+			"hashed_password": hashedPassword,
+			"email_verified":  true,
+		}).Error; err != nil {
+			return err
+		}
+		// Whoever knew the old password shouldn't stay logged in
+		_, err = tx.RevokeAllSessions(user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ErrSessionRevoked is returned when a session can't be used any more
+var ErrSessionRevoked = errors.New("session revoked or expired")
+
+// CreateSession starts a session for a device, returning it with its refresh token
+func (m *DBManager) CreateSession(userID uint, userAgent string, ipAddress string, ttl time.Duration) (*models.Session, string, error) {
+	refreshToken, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	session := models.Session{
+		UserID:           userID,
+		RefreshTokenHash: hashToken(refreshToken),
+		UserAgent:        userAgent,
+		IPAddress:        ipAddress,
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(ttl),
+	}
+	if err := m.DB.Create(&session).Error; err != nil {
+		return nil, "", err
+	}
+	return &session, refreshToken, nil
+}
+
+// RefreshSession swaps a refresh token for a new one, extending the session.
+// A refresh token that was already swapped means it was copied, so the
+// session is revoked and ErrSessionRevoked returned.
+func (m *DBManager) RefreshSession(refreshToken string, ttl time.Duration) (*models.Session, string, error) {
+	newToken, err := randomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var session models.Session
+	err = m.Transaction(func(tx *DBManager) error {
+		tokenHash := hashToken(refreshToken)
+		if err := tx.DB.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("refresh_token_hash = ?", tokenHash).
+			First(&session).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if !session.Active(now) {
+			return ErrSessionRevoked
+		}
+		session.PreviousTokenHash = tokenHash
+		session.RefreshTokenHash = hashToken(newToken)
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(ttl)
+		return tx.DB.Model(&session).Select("previous_token_hash", "refresh_token_hash", "last_used_at", "expires_at").Updates(&session).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// An old token being used again, e.g. by whoever stole it
+		result := m.DB.Model(&models.Session{}).
+			Where("previous_token_hash = ? AND revoked_at IS NULL", hashToken(refreshToken)).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			return nil, "", result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil, "", ErrSessionRevoked
+		}
+		return nil, "", ErrInvalidToken
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, newToken, nil
+}
+
+// IsSessionActive returns true if the session exists and hasn't been revoked or expired
+func (m *DBManager) IsSessionActive(sessionID uint) (bool, error) {
+	var session models.Session
+	err := m.DB.Select("id", "revoked_at", "expires_at").First(&session, sessionID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return session.Active(time.Now()), nil
+}
+
+// GetActiveSessions returns a user's sessions that can still be used, most recently used first
+func (m *DBManager) GetActiveSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := m.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession logs out one of a user's sessions, closing its event streams
+func (m *DBManager) RevokeSession(userID uint, sessionID uint) error {
+	result := m.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	m.closeStreams(sessionID)
+	return nil
+}
+
+// RevokeAllSessions logs a user out everywhere, closing their event streams.
+// Returns how many sessions were revoked.
+func (m *DBManager) RevokeAllSessions(userID uint) (int64, error) {
+	var revoked []models.Session
+	result := m.DB.Model(&revoked).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	sessionIDs := make([]uint, len(revoked))
+	for i, session := range revoked {
+		sessionIDs[i] = session.ID
+	}
+	m.closeStreams(sessionIDs...)
+	return result.RowsAffected, nil
+}
+
+// Ends the event streams opened by sessions that were logged out, since
+// they were authenticated when they started and aren't checked again
+func (m *DBManager) closeStreams(sessionIDs ...uint) {
+	if closer, ok := m.Events.(events.SessionCloser); ok && len(sessionIDs) > 0 {
+		closer.CloseSessions(sessionIDs...)
+	}
+}
+
+// ErrLastAdmin is returned when removing the admin role from the only admin
+var ErrLastAdmin = errors.New("can't remove the last admin")
+
+// BackfillUserRoles gives users flagged as admins from before roles existed the admin role
+func (m *DBManager) BackfillUserRoles() error {
+	return m.DB.Model(&models.User{}).
+		Where("is_admin AND role = ?", models.RoleUser).
+		Update("role", models.RoleAdmin).Error
+}
+
+// GetUserRole returns a user's role, read fresh so a revoked role takes effect right away
+func (m *DBManager) GetUserRole(userID uint) (string, error) {
+	var user models.User
+	err := m.DB.Select("role").First(&user, userID).Error
+	if err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+// SetUserRole changes a user's role, keeping IsAdmin in sync. There must
+// always be at least one admin left.
+func (m *DBManager) SetUserRole(userID uint, role string) (*models.User, error) {
+	if !models.ValidRole(role) {
+		return nil, fmt.Errorf("unknown role: %s", role)
+	}
+
+	var user models.User
+	err := m.Transaction(func(tx *DBManager) error {
+		// Lock the admins so two admins can't demote each other at the same time
+		var adminIDs []uint
+		if err := tx.DB.Model(&models.User{}).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("role = ?", models.RoleAdmin).
+			Pluck("id", &adminIDs).Error; err != nil {
+			return err
+		}
+
+		if err := tx.DB.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.Role == models.RoleAdmin && role != models.RoleAdmin && len(adminIDs) <= 1 {
+			return ErrLastAdmin
+		}
+
+		user.Role = role
+		user.IsAdmin = role == models.RoleAdmin
+		return tx.DB.Model(&user).Updates(map[string]interface{}{
+			"role":     user.Role,
+			"is_admin": user.IsAdmin,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Block errors
+var (
+	ErrBlocked   = errors.New("you can't interact with this user")
+	ErrBlockSelf = errors.New("cannot block yourself")
+)
+
+// GetPrivacySettings returns the user's privacy settings, or the defaults if they haven't changed them
+func (m *DBManager) GetPrivacySettings(userID uint) (*models.PrivacySettings, error) {
+	var settings models.PrivacySettings
+	err := m.DB.Where("user_id = ?", userID).First(&settings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return models.DefaultPrivacySettings(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SavePrivacySettings creates or replaces the user's privacy settings
+func (m *DBManager) SavePrivacySettings(settings *models.PrivacySettings) error {
+	return m.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"profile_visibility", "ratings_visibility", "searchable_by_email", "updated_at"}),
+	}).Create(settings).Error
+}
+
+// GetPrivacySettingsForUsers returns the privacy settings of each of the users, by user ID
+func (m *DBManager) GetPrivacySettingsForUsers(userIDs []uint) (map[uint]*models.PrivacySettings, error) {
+	var saved []models.PrivacySettings
+	if len(userIDs) > 0 {
+		if err := m.DB.Where("user_id IN ?", userIDs).Find(&saved).Error; err != nil {
+			return nil, err
+		}
+	}
+	settings := make(map[uint]*models.PrivacySettings, len(userIDs))
+	for _, userID := range userIDs {
+		settings[userID] = models.DefaultPrivacySettings(userID)
+	}
+	for i := range saved {
+		settings[saved[i].UserID] = &saved[i]
+	}
+	return settings, nil
+}
+
+// RatingsHiddenFromFriends returns true if the user doesn't let even their
+// friends see their ratings, so they shouldn't be told about new ones
+func (m *DBManager) RatingsHiddenFromFriends(userID uint) bool {
+	settings, err := m.GetPrivacySettings(userID)
+	return err != nil || settings.RatingsVisibility == models.VisibilityPrivate
+}
+
+// CanSeeRatings returns true if neither user blocked the other and the user's
+// ratings visibility lets the viewer see their ratings
+func (m *DBManager) CanSeeRatings(viewerID, userID uint) (bool, error) {
+	relationship, err := m.GetRelationship(viewerID, userID)
+	if err != nil {
+		return false, err
+	}
+	settings, err := m.GetPrivacySettings(userID)
+	if err != nil {
+		return false, err
+	}
+	return relationship.CanSee(settings.RatingsVisibility), nil
+}
+
+// IsBlocked returns true if either user blocked the other
+func (m *DBManager) IsBlocked(userID, otherID uint) (bool, error) {
+	var count int64
+	err := m.DB.Model(&models.Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userID, otherID, otherID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GetBlockedUserIDs returns the users the user blocked or was blocked by,
+// who are hidden from each other
+func (m *DBManager) GetBlockedUserIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := m.DB.Raw(`
+		SELECT blocked_id FROM blocks WHERE blocker_id = ?
+		UNION
+		SELECT blocker_id FROM blocks WHERE blocked_id = ?
+	`, userID, userID).Scan(&userIDs).Error
+	return userIDs, err
+}
+
+// GetRelationship returns how the viewer is related to the user. A viewerID of
+// 0 is a logged out visitor.
+func (m *DBManager) GetRelationship(viewerID, userID uint) (models.Relationship, error) {
+	if viewerID == 0 {
+		return models.Relationship{}, nil
+	}
+	if viewerID == userID {
+		return models.Relationship{Self: true}, nil
+	}
+	blocked, err := m.IsBlocked(viewerID, userID)
+	if err != nil {
+		return models.Relationship{}, err
+	}
+	friends, err := m.AreFriends(viewerID, userID)
+	if err != nil {
+		return models.Relationship{}, err
+	}
+	return models.Relationship{Friends: friends, Blocked: blocked}, nil
+}
+
+// RemoveFriend ends a friendship, returning gorm.ErrRecordNotFound if they weren't friends
+func (m *DBManager) RemoveFriend(userID, friendID uint) error {
+	if userID > friendID {
+		userID, friendID = friendID, userID
+	}
+	result := m.DB.Where("user_id = ? AND friend_id = ?", userID, friendID).Delete(&models.Friendship{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// BlockUser blocks a user, ending their friendship and closing any pending
+// friend requests between them. Blocking someone twice is a no-op.
+func (m *DBManager) BlockUser(blockerID, blockedID uint) (*models.Block, error) {
+	if blockerID == blockedID {
+		return nil, ErrBlockSelf
+	}
+
+	var block models.Block
+	err := m.Transaction(func(tx *DBManager) error {
+		if _, err := tx.GetUserByID(blockedID); err != nil {
+			return err
+		}
+
+		block = models.Block{BlockerID: blockerID, BlockedID: blockedID}
+		if err := tx.DB.Where(block).FirstOrCreate(&block).Error; err != nil {
+			return err
+		}
+
+		if err := tx.RemoveFriend(blockerID, blockedID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.DB.Model(&models.FriendRequest{}).
+			Where("from_id = ? AND to_id = ? AND status = ?", blockerID, blockedID, models.FriendRequestPending).
+			Updates(map[string]interface{}{"status": models.FriendRequestCancelled, "responded_at": now}).Error; err != nil {
+			return err
+		}
+		return tx.DB.Model(&models.FriendRequest{}).
+			Where("from_id = ? AND to_id = ? AND status = ?", blockedID, blockerID, models.FriendRequestPending).
+			Updates(map[string]interface{}{"status": models.FriendRequestDeclined, "responded_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
+
+// UnblockUser removes a block, returning gorm.ErrRecordNotFound if the user wasn't blocked.
+// It doesn't bring back their friendship.
+func (m *DBManager) UnblockUser(blockerID, blockedID uint) error {
+	result := m.DB.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&models.Block{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetBlocks returns the users the user blocked, most recent first
+func (m *DBManager) GetBlocks(userID uint) ([]models.Block, error) {
+	var blocks []models.Block
+	err := m.DB.Preload("Blocked").
+		Where("blocker_id = ?", userID).
+		Order("created_at DESC").
+		Find(&blocks).Error
+	return blocks, err
+}
+
+// GetMutualFriendCounts returns how many friends the user has in common with
+// each user who isn't already their friend, by user ID
+func (m *DBManager) GetMutualFriendCounts(userID uint) (map[uint]int, error) {
+	var rows []struct {
+		CandidateID uint
+		Mutual      int
+	}
+	err := m.DB.Raw(`
+		WITH my_friends AS (
+			SELECT CASE WHEN user_id = @user THEN friend_id ELSE user_id END AS id
+			FROM friendships WHERE user_id = @user OR friend_id = @user
+		), friends_of_friends AS (
+			SELECT CASE WHEN f.user_id = mf.id THEN f.friend_id ELSE f.user_id END AS candidate_id, mf.id AS via
+			FROM friendships f JOIN my_friends mf ON f.user_id = mf.id OR f.friend_id = mf.id
+		)
+		SELECT candidate_id, COUNT(DISTINCT via) AS mutual
+		FROM friends_of_friends
+		WHERE candidate_id != @user AND candidate_id NOT IN (SELECT id FROM my_friends)
+		GROUP BY candidate_id
+	`, map[string]interface{}{"user": userID}).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[uint]int, len(rows))
+	for _, row := range rows {
+		counts[row.CandidateID] = row.Mutual
+	}
+	return counts, nil
+}
+
+// GetTasteMatches compares the user's ratings with every other user who rated
+// at least minShared of the same dishes. Only users whose ratings are public
+// are compared, since matches are shown to people who aren't their friends.
+func (m *DBManager) GetTasteMatches(userID uint, minShared int) (map[uint]*models.TasteMatch, error) {
+	// Users can rate a dish more than once, so their scores are averaged per dish first
+	const sharedRatingsSQL = `
+		WITH mine AS (
+			SELECT dish_id, AVG(score) AS score FROM ratings WHERE user_id = @user GROUP BY dish_id
+		), theirs AS (
+			SELECT user_id, dish_id, AVG(score) AS score FROM ratings
+			WHERE user_id != @user
+				AND dish_id IN (SELECT dish_id FROM mine)
+				AND user_id NOT IN (SELECT user_id FROM privacy_settings WHERE ratings_visibility != @public)
+			GROUP BY user_id, dish_id
+		), shared AS (
+			SELECT theirs.user_id, theirs.dish_id, mine.score AS my_score, theirs.score AS their_score
+			FROM theirs JOIN mine ON mine.dish_id = theirs.dish_id
+		)`
+	params := map[string]interface{}{"user": userID, "public": models.VisibilityPublic, "min_shared": minShared}
+
+	var rows []models.TasteMatch
+	err := m.DB.Raw(sharedRatingsSQL+`
+		SELECT user_id, COUNT(*) AS shared_dishes, AVG(ABS(my_score - their_score)) AS avg_diff
+		FROM shared GROUP BY user_id HAVING COUNT(*) >= @min_shared
+	`, params).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	matches := make(map[uint]*models.TasteMatch, len(rows))
+	for i := range rows {
+		matches[rows[i].UserID] = &rows[i]
+	}
+	if len(matches) == 0 {
+		return matches, nil
+	}
+
+	var loved []struct {
+		UserID uint
+		DishID uint
+	}
+	err = m.DB.Raw(sharedRatingsSQL+`
+		SELECT DISTINCT ON (user_id) user_id, dish_id
+		FROM shared WHERE my_score >= 4 AND their_score >= 4
+		ORDER BY user_id, my_score + their_score DESC, dish_id
+	`, params).Scan(&loved).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range loved {
+		if match, ok := matches[row.UserID]; ok {
+			match.LovedDishID = &row.DishID
+		}
+	}
+	return matches, nil
+}
+
+// GetPendingFriendRequestUserIDs returns the users the user has a pending
+// friend request to or from
+func (m *DBManager) GetPendingFriendRequestUserIDs(userID uint) ([]uint, error) {
+	var userIDs []uint
+	err := m.DB.Raw(`
+		SELECT to_id FROM friend_requests WHERE from_id = ? AND status = ?
+		UNION
+		SELECT from_id FROM friend_requests WHERE to_id = ? AND status = ?
+	`, userID, models.FriendRequestPending, userID, models.FriendRequestPending).Scan(&userIDs).Error
+	return userIDs, err
+}
+
+// GetUsersByIDs returns the users with the given IDs, in no particular order
+func (m *DBManager) GetUsersByIDs(userIDs []uint) ([]models.User, error) {
+	var users []models.User
+	if len(userIDs) == 0 {
+		return users, nil
+	}
+	err := m.DB.Where("id IN ?", userIDs).Find(&users).Error
+	return users, err
+}
+
+// hallDisplayName returns the hall's display name, or one made from its slug
+func hallDisplayName(hall *models.DiningHall) string {
+	if hall.DisplayName != "" {
+		return hall.DisplayName
+	}
+	return models.DisplayNameFromSlug(hall.Name)
+}
+
+// recordActivity appends an activity to the feed. The feed is secondary to
+// what the user did, so failures are only logged. Inside a transaction the
+// insert gets its own savepoint, so a failure doesn't abort the transaction.
+func (m *DBManager) recordActivity(activity *models.Activity, data map[string]interface{}) {
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			fmt.Printf("Warning: failed to record %s activity: %v\n", activity.Type, err)
+			return
+		}
+		activity.Data = encoded
+	}
+	err := m.Transaction(func(tx *DBManager) error {
+		return tx.DB.Create(activity).Error
+	})
+	if err != nil {
+		fmt.Printf("Warning: failed to record %s activity: %v\n", activity.Type, err)
+	}
+}
+
+// BackfillActivities adds the ratings, favorites and friendships from before
+// the feed existed to it, in the order they happened. It runs at startup
+// before anything new is recorded, so they come before newer activities.
+func (m *DBManager) BackfillActivities() error {
+	hallName := "COALESCE(NULLIF(h.display_name, ''), INITCAP(REPLACE(h.name, '-', ' ')))"
+	return m.DB.Exec(`INSERT INTO activities (user_id, type, other_user_id, hall_id, dish_id, recipe_id, rating_id, data, created_at)
+		SELECT * FROM (
+			SELECT r.user_id, CAST(@rating AS text), CAST(NULL AS bigint), d.hall_id, d.id, d.recipe_id, r.id,
+				jsonb_strip_nulls(jsonb_build_object('dish_name', d.name, 'score', r.score, 'hall_name', `+hallName+`, 'comment', r.comment)), r.created_at
+			FROM ratings r
+			JOIN users u ON u.id = r.user_id AND u.deleted_at IS NULL
+			JOIN dishes d ON d.id = r.dish_id
+			JOIN dining_halls h ON h.id = d.hall_id
+			WHERE NOT EXISTS (SELECT 1 FROM activities a WHERE a.type = @rating AND a.rating_id = r.id)
+			UNION ALL
+			SELECT f.user_id, CAST(@favorite AS text), NULL, d.hall_id, f.dish_id, f.recipe_id, NULL,
+				jsonb_strip_nulls(jsonb_build_object('dish_name', d.name, 'hall_name', `+hallName+`, 'recipe_name', rc.name)), f.created_at
+			FROM favorites f
+			JOIN users u ON u.id = f.user_id AND u.deleted_at IS NULL
+			LEFT JOIN dishes d ON d.id = f.dish_id
+			LEFT JOIN dining_halls h ON h.id = d.hall_id
+			LEFT JOIN recipes rc ON rc.id = f.recipe_id
+			WHERE NOT EXISTS (SELECT 1 FROM activities a WHERE a.type = @favorite AND a.user_id = f.user_id
+				AND a.dish_id IS NOT DISTINCT FROM f.dish_id AND a.recipe_id IS NOT DISTINCT FROM f.recipe_id)
+			UNION ALL
+			SELECT fs.user_id, CAST(@friendship AS text), fs.friend_id, NULL, NULL, NULL, NULL,
+				jsonb_build_object('username', u.username, 'friend_username', fu.username), fs.created_at
+			FROM friendships fs
+			JOIN users u ON u.id = fs.user_id AND u.deleted_at IS NULL
+			JOIN users fu ON fu.id = fs.friend_id AND fu.deleted_at IS NULL
+			WHERE NOT EXISTS (SELECT 1 FROM activities a WHERE a.type = @friendship AND (
+				(a.user_id = fs.user_id AND a.other_user_id = fs.friend_id) OR
+				(a.user_id = fs.friend_id AND a.other_user_id = fs.user_id)))
+		) AS missing
+		ORDER BY created_at`, map[string]interface{}{
+		"rating":     models.ActivityRating,
+		"favorite":   models.ActivityFavorite,
+		"friendship": models.ActivityFriendship,
+	}).Error
+}
+
+// GetFeed returns a page of what the user's friends have been up to, newest
+// first. Ratings of friends who keep them private, activities involving users
+// the viewer blocked (or was blocked by) and friendships with non-friends
+// whose profiles the viewer can't see are left out.
+func (m *DBManager) GetFeed(userID uint, q models.FeedQuery) ([]models.Activity, error) {
+	friendIDs := m.DB.Table("friendships").
+		Select("CASE WHEN user_id = ? THEN friend_id ELSE user_id END", userID).
+		Where("user_id = ? OR friend_id = ?", userID, userID)
+	privateRaters := m.DB.Model(&models.PrivacySettings{}).
+		Select("user_id").
+		Where("ratings_visibility = ?", models.VisibilityPrivate)
+	// Only public profiles are visible to non-friends
+	hiddenProfiles := m.DB.Model(&models.PrivacySettings{}).
+		Select("user_id").
+		Where("profile_visibility != ?", models.VisibilityPublic)
+	blockedIDs, err := m.GetBlockedUserIDs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := m.DB.Preload("User").
+		Where("user_id IN (?) OR (type = ? AND other_user_id IN (?) AND user_id != ?)",
+			friendIDs, models.ActivityFriendship, friendIDs, userID).
+		Where("NOT (type = ? AND user_id IN (?))", models.ActivityRating, privateRaters).
+		Where("NOT (type = ? AND user_id NOT IN (?) AND user_id IN (?))",
+			models.ActivityFriendship, friendIDs, hiddenProfiles).
+		Where("NOT (type = ? AND other_user_id != ? AND other_user_id NOT IN (?) AND other_user_id IN (?))",
+			models.ActivityFriendship, userID, friendIDs, hiddenProfiles)
+	if len(blockedIDs) > 0 {
+		query = query.Where("user_id NOT IN ?", blockedIDs).
+			Where("other_user_id IS NULL OR other_user_id NOT IN ?", blockedIDs)
+	}
+	if q.Before > 0 {
+		query = query.Where("id < ?", q.Before)
+	}
+	if q.HallID > 0 {
+		query = query.Where("hall_id = ?", q.HallID)
+	}
+	if q.FriendID > 0 {
+		query = query.Where("user_id = ? OR (type = ? AND other_user_id = ?)", q.FriendID, models.ActivityFriendship, q.FriendID)
+	}
+	if q.Type != "" {
+		query = query.Where("type = ?", q.Type)
+	}
+
+	var activities []models.Activity
+	err = query.Order("id DESC").Limit(q.Limit).Find(&activities).Error
+	return activities, err
+}
@@ -0,0 +1,115 @@
+package db_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gsonntag/bruinbite/db/dbtest"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+func TestGetFeedFriendships(t *testing.T) {
+	mgr := dbtest.New(t)
+	viewer := createTestUser(t, mgr, "viewer")
+	friend := createTestUser(t, mgr, "friend")
+	public := createTestUser(t, mgr, "public")
+	hidden := createTestUser(t, mgr, "hidden")
+	blocked := createTestUser(t, mgr, "blocked")
+
+	settings := models.DefaultPrivacySettings(hidden.ID)
+	settings.ProfileVisibility = models.VisibilityFriends
+	if err := mgr.SavePrivacySettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.BlockUser(viewer.ID, blocked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	friendships := [][2]uint{
+		{viewer.ID, friend.ID},  // the viewer's own friendship
+		{friend.ID, public.ID},  // shown
+		{friend.ID, hidden.ID},  // the viewer can't see hidden's profile
+		{blocked.ID, friend.ID}, // blocked made a friend, not shown
+	}
+	for _, f := range friendships {
+		if err := mgr.CreateFriendship(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	feed, err := mgr.GetFeed(viewer.ID, models.FeedQuery{Limit: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 1 || feed[0].UserID != friend.ID || feed[0].OtherUserID == nil || *feed[0].OtherUserID != public.ID {
+		t.Errorf("feed = %+v, want only friend's friendship with public", feed)
+	}
+
+	// Backfilling brings back the friendships recorded before the feed existed, once
+	if err := mgr.DB.Where("1 = 1").Delete(&models.Activity{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := mgr.BackfillActivities(); err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		if err := mgr.DB.Model(&models.Activity{}).Where("type = ?", models.ActivityFriendship).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count != int64(len(friendships)) {
+			t.Errorf("backfill %d left %d friendship activities, want %d", i+1, count, len(friendships))
+		}
+	}
+}
+
+func TestMergeDishesRemovesDuplicateRatingActivities(t *testing.T) {
+	mgr := dbtest.New(t)
+	viewer := createTestUser(t, mgr, "viewer")
+	rater := createTestUser(t, mgr, "rater")
+	if err := mgr.CreateFriendship(viewer.ID, rater.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	hall, err := mgr.CreateNewHall("bruin-plate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := models.DateOf(time.Now())
+	source, _, err := mgr.GetOrCreateDishByName("Orange Chicken", hall.ID, "Harvest", today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _, err := mgr.GetOrCreateDishByName("Chicken Teriyaki", hall.ID, "Harvest", today)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rater rated both dishes, so the merge only keeps their newer rating
+	older := models.Rating{UserID: rater.ID, DishID: source.ID, Score: 2, CreatedAt: time.Now().Add(-time.Hour)}
+	if err := mgr.CreateRating(&older); err != nil {
+		t.Fatal(err)
+	}
+	newer := models.Rating{UserID: rater.ID, DishID: target.ID, Score: 5}
+	if err := mgr.CreateRating(&newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.MergeDishes(source.ID, target.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	feed, err := mgr.GetFeed(viewer.ID, models.FeedQuery{Limit: 20, Type: models.ActivityRating})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed) != 1 || feed[0].RatingID == nil || *feed[0].RatingID != newer.ID {
+		t.Errorf("rating activities after merge = %+v, want only the newer rating %d", feed, newer.ID)
+	}
+	var orphaned int64
+	if err := mgr.DB.Model(&models.Activity{}).Where("rating_id = ?", older.ID).Count(&orphaned).Error; err != nil {
+		t.Fatal(err)
+	}
+	if orphaned != 0 {
+		t.Errorf("%d activities still point at the deleted rating", orphaned)
+	}
+}
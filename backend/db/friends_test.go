@@ -0,0 +1,35 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/db/dbtest"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+func TestPendingFriendRequestEitherDirection(t *testing.T) {
+	mgr := dbtest.New(t)
+	alice := createTestUser(t, mgr, "alice")
+	bob := createTestUser(t, mgr, "bob")
+
+	if _, err := mgr.SendFriendRequest(alice.ID, bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.SendFriendRequest(bob.ID, alice.ID); !errors.Is(err, db.ErrFriendRequestPending) {
+		t.Errorf("request back returned %v, want ErrFriendRequestPending", err)
+	}
+
+	// The index catches requests that got past the check, like two sent at once
+	crossed := models.FriendRequest{FromID: bob.ID, ToID: alice.ID, Status: models.FriendRequestPending}
+	if err := mgr.DB.Create(&crossed).Error; err == nil {
+		t.Error("a second pending request between the same users was saved")
+	}
+
+	// Answered requests don't count
+	answered := models.FriendRequest{FromID: bob.ID, ToID: alice.ID, Status: models.FriendRequestDeclined}
+	if err := mgr.DB.Create(&answered).Error; err != nil {
+		t.Errorf("saving a declined request failed: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/db/dbtest"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+	"gorm.io/gorm"
+)
+
+func createTestUser(t *testing.T, mgr *db.DBManager, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", HashedPassword: "x"}
+	if err := mgr.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func notificationTypes(t *testing.T, mgr *db.DBManager, userID uint) []string {
+	t.Helper()
+	notifications, err := mgr.GetNotifications(userID, false, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for _, notification := range notifications {
+		types = append(types, notification.Type)
+	}
+	return types
+}
+
+func unreadCount(t *testing.T, mgr *db.DBManager, userID uint) int64 {
+	t.Helper()
+	count, err := mgr.CountUnreadNotifications(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestCreateNotificationsMutes(t *testing.T) {
+	mgr := dbtest.New(t)
+	muter := createTestUser(t, mgr, "muter")
+	other := createTestUser(t, mgr, "other")
+
+	if err := mgr.SetNotificationMutes(muter.ID, []string{notify.TypeFriendRated, notify.TypeFavoriteOnMenu}); err != nil {
+		t.Fatal(err)
+	}
+	err := mgr.CreateNotifications(
+		notify.Message{UserID: muter.ID, Type: notify.TypeFriendRated, Title: "muted"},
+		notify.Message{UserID: muter.ID, Type: notify.TypeCommentReply, Title: "not muted"},
+		notify.Message{UserID: other.ID, Type: notify.TypeFriendRated, Title: "someone else's mute"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notificationTypes(t, mgr, muter.ID); len(got) != 1 || got[0] != notify.TypeCommentReply {
+		t.Errorf("muter's notifications = %v, want [%s]", got, notify.TypeCommentReply)
+	}
+	if got := notificationTypes(t, mgr, other.ID); len(got) != 1 || got[0] != notify.TypeFriendRated {
+		t.Errorf("other user's notifications = %v, want [%s]", got, notify.TypeFriendRated)
+	}
+
+	// Only muted messages is not an error
+	if err := mgr.CreateNotifications(notify.Message{UserID: muter.ID, Type: notify.TypeFavoriteOnMenu, Title: "muted"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCount(t, mgr, muter.ID); got != 1 {
+		t.Errorf("unread count after muted message = %d, want 1", got)
+	}
+
+	// Replacing the mutes lets the type through again
+	if err := mgr.SetNotificationMutes(muter.ID, []string{notify.TypeFavoriteOnMenu}); err != nil {
+		t.Fatal(err)
+	}
+	mutes, err := mgr.GetNotificationMutes(muter.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mutes) != 1 || mutes[0] != notify.TypeFavoriteOnMenu {
+		t.Errorf("mutes = %v, want [%s]", mutes, notify.TypeFavoriteOnMenu)
+	}
+	if err := mgr.CreateNotifications(notify.Message{UserID: muter.ID, Type: notify.TypeFriendRated, Title: "unmuted"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCount(t, mgr, muter.ID); got != 2 {
+		t.Errorf("unread count after unmuting = %d, want 2", got)
+	}
+}
+
+func TestUnreadNotifications(t *testing.T) {
+	mgr := dbtest.New(t)
+	user := createTestUser(t, mgr, "reader")
+	other := createTestUser(t, mgr, "other")
+
+	err := mgr.CreateNotifications(
+		notify.Message{UserID: user.ID, Type: notify.TypeFriendRequest, Title: "first"},
+		notify.Message{UserID: user.ID, Type: notify.TypeFriendAccepted, Title: "second"},
+		notify.Message{UserID: user.ID, Type: notify.TypeCommentReply, Title: "third"},
+		notify.Message{UserID: other.ID, Type: notify.TypeCommentReply, Title: "not theirs"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := unreadCount(t, mgr, user.ID); got != 3 {
+		t.Fatalf("unread count = %d, want 3", got)
+	}
+
+	notifications, err := mgr.GetNotifications(user.ID, true, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifications) != 3 || notifications[0].Title != "third" {
+		t.Fatalf("unread notifications should be newest first, got %+v", notifications)
+	}
+
+	// Reading a notification twice only counts once
+	for i := 0; i < 2; i++ {
+		if err := mgr.MarkNotificationRead(user.ID, notifications[0].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := unreadCount(t, mgr, user.ID); got != 2 {
+		t.Errorf("unread count after reading one = %d, want 2", got)
+	}
+
+	// Users can't read someone else's notifications
+	theirs, err := mgr.GetNotifications(other.ID, false, 0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.MarkNotificationRead(user.ID, theirs[0].ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("reading someone else's notification returned %v, want gorm.ErrRecordNotFound", err)
+	}
+	if got := unreadCount(t, mgr, other.ID); got != 1 {
+		t.Errorf("other user's unread count = %d, want 1", got)
+	}
+
+	read, err := mgr.MarkAllNotificationsRead(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read != 2 {
+		t.Errorf("MarkAllNotificationsRead() = %d, want 2", read)
+	}
+	if got := unreadCount(t, mgr, user.ID); got != 0 {
+		t.Errorf("unread count after reading all = %d, want 0", got)
+	}
+	if got := unreadCount(t, mgr, other.ID); got != 1 {
+		t.Errorf("other user's unread count after reading all = %d, want 1", got)
+	}
+}
+
+func TestCanSeeRatings(t *testing.T) {
+	mgr := dbtest.New(t)
+	rater := createTestUser(t, mgr, "rater")
+	friend := createTestUser(t, mgr, "friend")
+	stranger := createTestUser(t, mgr, "stranger")
+	blocker := createTestUser(t, mgr, "blocker")
+
+	if err := mgr.CreateFriendship(rater.ID, friend.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.BlockUser(blocker.ID, rater.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		visibility string
+		viewerID   uint
+		want       bool
+	}{
+		{models.VisibilityPublic, 0, true},
+		{models.VisibilityPublic, stranger.ID, true},
+		{models.VisibilityPublic, blocker.ID, false},
+		{models.VisibilityFriends, stranger.ID, false},
+		{models.VisibilityFriends, friend.ID, true},
+		{models.VisibilityPrivate, friend.ID, false},
+		{models.VisibilityPrivate, rater.ID, true},
+	}
+	for _, tt := range tests {
+		settings := models.DefaultPrivacySettings(rater.ID)
+		settings.RatingsVisibility = tt.visibility
+		if err := mgr.SavePrivacySettings(settings); err != nil {
+			t.Fatal(err)
+		}
+		got, err := mgr.CanSeeRatings(tt.viewerID, rater.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("CanSeeRatings(%d) with %s ratings = %v, want %v", tt.viewerID, tt.visibility, got, tt.want)
+		}
+	}
+}
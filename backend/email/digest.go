@@ -0,0 +1,270 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/schedule"
+	"gorm.io/gorm"
+)
+
+// DefaultDigestTimes is when the digest goes out (Pacific time), after the morning ingest run
+const DefaultDigestTimes = "07:00"
+
+// How many top rated dishes are listed for each meal period
+const digestTopRated = 3
+
+// Dishes rated lower than this aren't called out as top rated
+const digestMinRating = 4.0
+
+// DigestReport is the result of sending the digest for one day
+type DigestReport struct {
+	Date     string   `json:"date"`
+	Sent     int      `json:"sent"`
+	Skipped  int      `json:"skipped"`            // users with nothing to send, e.g. no favorite halls or no menus
+	Failures []string `json:"failures,omitempty"` // emails that couldn't be sent
+}
+
+// A hall's menus for the day, shared between every user that follows the hall
+type hallMenus struct {
+	name    string
+	periods []periodMenu
+}
+
+type periodMenu struct {
+	label  string
+	dishes []models.Dish
+}
+
+// NewDigestScheduler creates a scheduler that sends the digest at the Pacific
+// times in $DIGEST_TIMES (or DefaultDigestTimes if unset)
+func NewDigestScheduler(m *Mailer) (*schedule.Scheduler, error) {
+	timesSpec := os.Getenv("DIGEST_TIMES")
+	if timesSpec == "" {
+		timesSpec = DefaultDigestTimes
+	}
+	times, err := schedule.ParseClockTimes(timesSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	return schedule.New("digest", m.Mgr.TZ, times, func() (interface{}, error) {
+		report, err := m.SendDigests(models.DateOf(time.Now().In(m.Mgr.TZ)))
+		if err == nil && len(report.Failures) > 0 {
+			err = fmt.Errorf("failed to send %d digests", len(report.Failures))
+		}
+		return report, err
+	}), nil
+}
+
+// SendDigests emails the digest for the date to every user who opted in and
+// hasn't been sent it yet, so it is safe to run more than once a day
+func (m *Mailer) SendDigests(date models.Date) (*DigestReport, error) {
+	date.MealPeriod = nil
+	report := &DigestReport{Date: date.String()}
+
+	recipients, err := m.Mgr.GetDigestRecipients(date)
+	if err != nil {
+		return report, err
+	}
+
+	cache := make(map[string]*hallMenus)
+	for _, recipient := range recipients {
+		data, err := m.buildDigest(recipient, date, cache)
+		if err != nil {
+			return report, err
+		}
+		if data == nil {
+			report.Skipped++
+			continue
+		}
+
+		unsubscribeURL := m.unsubscribeURL(recipient.UnsubscribeToken, "digest")
+		data.UnsubscribeURL = unsubscribeURL
+		email, err := m.compose(recipient, digestSubject(data), "digest", data, unsubscribeURL)
+		if err != nil {
+			return report, err
+		}
+		if err := m.Sender.Send(email); err != nil {
+			report.Failures = append(report.Failures, fmt.Sprintf("%s: %v", recipient.Email, err))
+			continue
+		}
+		if err := m.Mgr.MarkDigestSent(recipient.UserID, date); err != nil {
+			return report, err
+		}
+		report.Sent++
+	}
+	return report, nil
+}
+
+// Builds a user's digest, or returns nil if there's nothing to tell them
+func (m *Mailer) buildDigest(recipient models.EmailRecipient, date models.Date, cache map[string]*hallMenus) (*DigestData, error) {
+	prefs, err := m.Mgr.GetUserPreferences(recipient.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefs.FavoriteHalls) == 0 {
+		return nil, nil
+	}
+	filter := prefs.DietaryFilter()
+
+	favorites, err := m.Mgr.GetFavorites(recipient.UserID)
+	if err != nil {
+		return nil, err
+	}
+	favoriteDishes := make(map[uint]bool)
+	favoriteRecipes := make(map[uint]bool)
+	for _, favorite := range favorites {
+		if favorite.DishID != nil {
+			favoriteDishes[*favorite.DishID] = true
+		}
+		if favorite.RecipeID != nil {
+			favoriteRecipes[*favorite.RecipeID] = true
+		}
+	}
+
+	data := &DigestData{
+		Username: recipient.Username,
+		Date:     date.Time(m.Mgr.TZ).Format("Monday, January 2"),
+		AppURL:   m.AppURL,
+	}
+	for _, hallName := range prefs.FavoriteHalls {
+		menus, err := m.loadHallMenus(hallName, date, cache)
+		if err != nil {
+			return nil, err
+		}
+		if menus == nil {
+			continue
+		}
+
+		hall := DigestHall{Name: menus.name}
+		for _, period := range menus.periods {
+			dishes := filter.FilterDishes(period.dishes)
+			if len(dishes) == 0 {
+				continue
+			}
+
+			digestPeriod := DigestPeriod{Label: period.label, DishCount: len(dishes)}
+			var others []models.Dish
+			for _, dish := range dishes {
+				if favoriteDishes[dish.ID] || (dish.RecipeID != nil && favoriteRecipes[*dish.RecipeID]) {
+					digestPeriod.Favorites = append(digestPeriod.Favorites, DigestDish{Name: dish.Name, Rating: dish.AverageRating})
+				} else if dish.AverageRating >= digestMinRating {
+					others = append(others, dish)
+				}
+			}
+			sort.SliceStable(others, func(i, j int) bool { return others[i].AverageRating > others[j].AverageRating })
+			for _, dish := range others[:min(len(others), digestTopRated)] {
+				digestPeriod.TopRated = append(digestPeriod.TopRated, DigestDish{Name: dish.Name, Rating: dish.AverageRating})
+			}
+			hall.Periods = append(hall.Periods, digestPeriod)
+		}
+		if len(hall.Periods) > 0 {
+			data.Halls = append(data.Halls, hall)
+		}
+	}
+
+	if len(data.Halls) == 0 {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// Loads a hall's menus for the date (in meal period order), or returns nil if
+// the hall doesn't exist or has no menus that day
+func (m *Mailer) loadHallMenus(hallName string, date models.Date, cache map[string]*hallMenus) (*hallMenus, error) {
+	if menus, ok := cache[hallName]; ok {
+		return menus, nil
+	}
+
+	hall, err := m.Mgr.GetHallByName(hallName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cache[hallName] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	periods, err := m.Mgr.GetMealPeriodsForDate(hallName, date)
+	if err != nil {
+		return nil, err
+	}
+	if len(periods) == 0 {
+		cache[hallName] = nil
+		return nil, nil
+	}
+	sort.SliceStable(periods, func(i, j int) bool {
+		return models.MealPeriodRank(periods[i]) < models.MealPeriodRank(periods[j])
+	})
+	labels, err := m.Mgr.GetMealPeriodLabels(hallName)
+	if err != nil {
+		return nil, err
+	}
+
+	name := hall.DisplayName
+	if name == "" {
+		name = models.DisplayNameFromSlug(hall.Name)
+	}
+	menus := &hallMenus{name: name}
+	for _, period := range periods {
+		periodDate := date
+		periodDate.MealPeriod = &period
+		menu, err := m.Mgr.GetMenuByHallNameAndDate(hallName, periodDate)
+		if err != nil {
+			return nil, err
+		}
+		label := period
+		if hallLabel, ok := labels[period]; ok {
+			label = hallLabel
+		}
+		menus.periods = append(menus.periods, periodMenu{label: periodHeading(label), dishes: menu.Dishes})
+	}
+	cache[hallName] = menus
+	return menus, nil
+}
+
+// e.g. LUNCH_DINNER -> "Lunch & dinner"
+func periodHeading(period string) string {
+	if period == models.PeriodAllDay {
+		return "All day"
+	}
+	word := models.MealPeriodWord(period)
+	if word == "" {
+		return word
+	}
+	return strings.ToUpper(word[:1]) + word[1:]
+}
+
+// e.g. "Garlic Noodles and 2 more favorites on today's menus"
+func digestSubject(data *DigestData) string {
+	var favorites []string
+	for _, hall := range data.Halls {
+		for _, period := range hall.Periods {
+			for _, dish := range period.Favorites {
+				if !slices.Contains(favorites, dish.Name) {
+					favorites = append(favorites, dish.Name)
+				}
+			}
+		}
+	}
+	switch len(favorites) {
+	case 0:
+		if len(data.Halls) > 1 {
+			return fmt.Sprintf("Today's menus at %s and %d more halls", data.Halls[0].Name, len(data.Halls)-1)
+		}
+		return "Today's menus at " + data.Halls[0].Name
+	case 1:
+		return favorites[0] + " is on today's menu"
+	case 2:
+		return fmt.Sprintf("%s and %s are on today's menus", favorites[0], favorites[1])
+	default:
+		return fmt.Sprintf("%s and %d more favorites are on today's menus", favorites[0], len(favorites)-1)
+	}
+}
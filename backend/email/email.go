@@ -0,0 +1,192 @@
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email with an HTML and a plain text version
+type Message struct {
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+	Headers map[string]string // extra headers, e.g. List-Unsubscribe
+}
+
+// Sender delivers emails
+type Sender interface {
+	Send(msg Message) error
+}
+
+// NewSenderFromEnv creates the sender picked by $EMAIL_BACKEND: "smtp", "file"
+// (writes .eml files to $EMAIL_FILE_DIR) or "log" (the default, for development)
+func NewSenderFromEnv() (Sender, error) {
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		from = "BruinBite <no-reply@bruinbite.local>"
+	}
+
+	switch backend := os.Getenv("EMAIL_BACKEND"); backend {
+	case "", "log":
+		return LogSender{}, nil
+	case "file":
+		dir := os.Getenv("EMAIL_FILE_DIR")
+		if dir == "" {
+			dir = "emails"
+		}
+		return &FileSender{Dir: dir, From: from}, nil
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp email backend")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPSender{
+			Host:     host,
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown EMAIL_BACKEND %q, expected smtp, file or log", backend)
+	}
+}
+
+// SMTPSender sends emails through an SMTP server, using STARTTLS when the
+// server supports it
+type SMTPSender struct {
+	Host     string
+	Port     string
+	Username string // no authentication if empty
+	Password string
+	From     string
+}
+
+func (s *SMTPSender) Send(msg Message) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+	body, err := buildMIME(s.From, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.Host+":"+s.Port, auth, envelopeAddress(s.From), []string{msg.To}, body)
+}
+
+// FileSender writes every email to a .eml file in Dir, which can be opened
+// with any mail client
+type FileSender struct {
+	Dir  string
+	From string
+	mu   sync.Mutex
+}
+
+func (s *FileSender) Send(msg Message) error {
+	body, err := buildMIME(s.From, msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000"), sanitizeFilename(msg.To))
+	return os.WriteFile(filepath.Join(s.Dir, name), body, 0644)
+}
+
+// LogSender only logs emails
+type LogSender struct{}
+
+func (LogSender) Send(msg Message) error {
+	log.Printf("[email] to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// Builds a multipart/alternative email with both the text and HTML versions
+func buildMIME(from string, msg Message) ([]byte, error) {
+	boundaryBytes := make([]byte, 12)
+	if _, err := rand.Read(boundaryBytes); err != nil {
+		return nil, err
+	}
+	boundary := "bruinbite-" + hex.EncodeToString(boundaryBytes)
+
+	var buf bytes.Buffer
+	headers := map[string]string{
+		"From":         from,
+		"To":           msg.To,
+		"Subject":      mime.QEncoding.Encode("utf-8", msg.Subject),
+		"Date":         time.Now().Format(time.RFC1123Z),
+		"MIME-Version": "1.0",
+		"Content-Type": `multipart/alternative; boundary="` + boundary + `"`,
+	}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	keys := make([]string, 0, len(headers))
+	for key := range headers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, headers[key])
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		fmt.Fprintf(&buf, "--%s\r\n", boundary)
+		fmt.Fprintf(&buf, "Content-Type: %s; charset=utf-8\r\n", part.contentType)
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writer := quotedprintable.NewWriter(&buf)
+		if _, err := writer.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\r\n")
+	}
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+	return buf.Bytes(), nil
+}
+
+// e.g. "BruinBite <no-reply@bruinbite.app>" -> "no-reply@bruinbite.app"
+func envelopeAddress(from string) string {
+	if start := strings.LastIndex(from, "<"); start >= 0 {
+		if end := strings.LastIndex(from, ">"); end > start {
+			return from[start+1 : end]
+		}
+	}
+	return strings.TrimSpace(from)
+}
+
+func sanitizeFilename(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '@' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,133 @@
+package email
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+)
+
+// Mailer sends the app's emails: the daily digest and notification emails
+type Mailer struct {
+	Mgr    *db.DBManager
+	Sender Sender
+	APIURL string // where unsubscribe links go, the backend's public URL
+	AppURL string // the frontend, linked to from emails
+}
+
+// NewMailerFromEnv creates a mailer using NewSenderFromEnv, $API_URL and $FRONTEND_URL
+func NewMailerFromEnv(mgr *db.DBManager) (*Mailer, error) {
+	sender, err := NewSenderFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	apiURL := os.Getenv("API_URL")
+	if apiURL == "" {
+		apiURL = "http://localhost:8080"
+	}
+	return &Mailer{Mgr: mgr, Sender: sender, APIURL: apiURL, AppURL: os.Getenv("FRONTEND_URL")}, nil
+}
+
+// Unsubscribe link for one list ("digest" or "notifications")
+func (m *Mailer) unsubscribeURL(token string, list string) string {
+	return m.APIURL + "/unsubscribe?" + url.Values{"token": {token}, "list": {list}}.Encode()
+}
+
+// Builds an email from the named templates with one-click unsubscribe headers
+func (m *Mailer) compose(recipient models.EmailRecipient, subject string, template string, data interface{}, unsubscribeURL string) (Message, error) {
+	html, text, err := Render(template, data)
+	if err != nil {
+		return Message{}, fmt.Errorf("%s email: %w", template, err)
+	}
+	return Message{
+		To:      recipient.Email,
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	}, nil
+}
+
+// Notify emails messages to the users who opted in to notification emails,
+// leaving out types they muted. Emails are sent in the background so the
+// request that caused the notification isn't held up by the mail server.
+func (m *Mailer) Notify(messages ...notify.Message) error {
+	messages, err := m.Mgr.FilterMutedMessages(messages)
+	if err != nil || len(messages) == 0 {
+		return err
+	}
+
+	userIDs := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		userIDs = append(userIDs, message.UserID)
+	}
+	recipients, err := m.Mgr.GetNotificationEmailRecipients(userIDs)
+	if err != nil {
+		return err
+	}
+	byUser := make(map[uint]models.EmailRecipient, len(recipients))
+	for _, recipient := range recipients {
+		byUser[recipient.UserID] = recipient
+	}
+
+	var emails []Message
+	for _, message := range messages {
+		recipient, ok := byUser[message.UserID]
+		if !ok {
+			continue
+		}
+		unsubscribeURL := m.unsubscribeURL(recipient.UnsubscribeToken, "notifications")
+		email, err := m.compose(recipient, message.Title, "notification", NotificationData{
+			Username:       recipient.Username,
+			Title:          message.Title,
+			Body:           message.Body,
+			AppURL:         m.AppURL,
+			UnsubscribeURL: unsubscribeURL,
+		}, unsubscribeURL)
+		if err != nil {
+			return err
+		}
+		emails = append(emails, email)
+	}
+
+	if len(emails) > 0 {
+		go func() {
+			for _, email := range emails {
+				if err := m.Sender.Send(email); err != nil {
+					log.Printf("[email] Failed to send notification to %s: %v", email.To, err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// SendVerificationEmail emails the user a link to verify their email with
+func (m *Mailer) SendVerificationEmail(user *models.User, token string, expiresIn string) error {
+	return m.sendAccountEmail(user, "Verify your BruinBite email", "verify_email", "/verify-email", token, expiresIn)
+}
+
+// SendPasswordResetEmail emails the user a link to reset their password with
+func (m *Mailer) SendPasswordResetEmail(user *models.User, token string, expiresIn string) error {
+	return m.sendAccountEmail(user, "Reset your BruinBite password", "password_reset", "/reset-password", token, expiresIn)
+}
+
+// Account emails link to a frontend page that passes the token back to the API
+func (m *Mailer) sendAccountEmail(user *models.User, subject string, template string, path string, token string, expiresIn string) error {
+	html, text, err := Render(template, AccountEmailData{
+		Username:  user.Username,
+		ActionURL: m.AppURL + path + "?" + url.Values{"token": {token}}.Encode(),
+		ExpiresIn: expiresIn,
+	})
+	if err != nil {
+		return fmt.Errorf("%s email: %w", template, err)
+	}
+	return m.Sender.Send(Message{To: user.Email, Subject: subject, HTML: html, Text: text})
+}
@@ -0,0 +1,74 @@
+package email
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+// Every email has an HTML (templates/<name>.html) and a plain text (templates/<name>.txt) template
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+)
+
+// DigestData is what the digest templates are rendered with
+type DigestData struct {
+	Username       string
+	Date           string // e.g. "Friday, October 17"
+	Halls          []DigestHall
+	AppURL         string
+	UnsubscribeURL string
+}
+
+// DigestHall is one of the user's favorite halls in the digest
+type DigestHall struct {
+	Name    string
+	Periods []DigestPeriod
+}
+
+// DigestPeriod is one meal period's menu, cut down to the dishes worth mentioning
+type DigestPeriod struct {
+	Label     string // e.g. "Lunch & dinner"
+	Favorites []DigestDish
+	TopRated  []DigestDish
+	DishCount int // every dish on the menu that fits the user's dietary preferences
+}
+
+// DigestDish is a dish mentioned in the digest
+type DigestDish struct {
+	Name   string
+	Rating float64 // 0 if not rated yet
+}
+
+// NotificationData is what the notification templates are rendered with
+type NotificationData struct {
+	Username       string
+	Title          string
+	Body           string
+	AppURL         string
+	UnsubscribeURL string
+}
+
+// AccountEmailData is what the account emails (verification, password reset) are rendered with
+type AccountEmailData struct {
+	Username  string
+	ActionURL string // the link that uses the token
+	ExpiresIn string // e.g. "1 hour"
+}
+
+// Render renders the named template in both formats
+func Render(name string, data interface{}) (string, string, error) {
+	var html, text bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return "", "", err
+	}
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return "", "", err
+	}
+	return html.String(), text.String(), nil
+}
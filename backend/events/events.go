@@ -0,0 +1,125 @@
+package events
+
+import "sync"
+
+// Event types
+const (
+	TypeNotification   = "notification"    // a notification was saved for the user
+	TypeFriendRating   = "friend_rating"   // one of the user's friends rated a dish
+	TypeMenusAvailable = "menus_available" // an ingest run loaded new menus
+)
+
+// DefaultBuffer is how many events a subscriber can fall behind by before
+// new events are dropped for it
+const DefaultBuffer = 32
+
+// Event is something that happened that connected clients should hear about
+type Event struct {
+	Type   string      `json:"type"`
+	UserID uint        `json:"-"` // the user it's for, 0 for everyone
+	Data   interface{} `json:"data"`
+}
+
+// Publisher sends events to whoever is listening
+type Publisher interface {
+	Publish(events ...Event)
+}
+
+// SessionCloser is implemented by publishers that can end the streams of
+// sessions that were logged out
+type SessionCloser interface {
+	CloseSessions(sessionIDs ...uint)
+}
+
+// Hub is an in-process Publisher that fans events out to subscribers. Slow
+// subscribers never block publishers, events they can't keep up with are dropped.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	buffer      int
+}
+
+// NewHub creates a hub whose subscribers can fall behind by DefaultBuffer events
+func NewHub() *Hub {
+	return &Hub{subscribers: make(map[*Subscription]struct{}), buffer: DefaultBuffer}
+}
+
+// Subscription receives a user's events until it's closed
+type Subscription struct {
+	UserID    uint
+	SessionID uint // the session that opened it, so logging out can close it
+	hub       *Hub
+	events    chan Event
+	closed    bool // guarded by hub.mu
+}
+
+// Subscribe starts receiving the user's events, and events for everyone
+func (h *Hub) Subscribe(userID uint, sessionID uint) *Subscription {
+	sub := &Subscription{UserID: userID, SessionID: sessionID, hub: h, events: make(chan Event, h.buffer)}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// Publish sends events to the subscribers they're for
+func (h *Hub) Publish(events ...Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		for _, event := range events {
+			if event.UserID != 0 && event.UserID != sub.UserID {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+			}
+		}
+	}
+}
+
+// CloseSessions closes the subscriptions opened by the sessions
+func (h *Hub) CloseSessions(sessionIDs ...uint) {
+	closing := make(map[uint]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		closing[id] = true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if closing[sub.SessionID] {
+			sub.close()
+		}
+	}
+}
+
+// Subscribers returns how many subscriptions are open
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Events is the channel events are delivered on. It's closed when the subscription is.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close stops the subscription. It's safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.close()
+}
+
+// Closes the subscription, with hub.mu held
+func (s *Subscription) close() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.hub.subscribers, s)
+	close(s.events)
+}
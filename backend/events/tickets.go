@@ -0,0 +1,73 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// DefaultTicketTTL is how long a stream ticket can be redeemed for
+const DefaultTicketTTL = 30 * time.Second
+
+// Ticket lets a client open an event stream without putting its access token
+// in the URL, where it would end up in logs
+type Ticket struct {
+	UserID    uint
+	SessionID uint
+	ExpiresAt time.Time
+}
+
+// Tickets hands out short-lived, single-use stream tickets. Like Hub it only
+// lives in this process.
+type Tickets struct {
+	mu      sync.Mutex
+	tickets map[string]Ticket
+	ttl     time.Duration
+}
+
+// NewTickets creates a ticket store whose tickets expire after DefaultTicketTTL
+func NewTickets() *Tickets {
+	return &Tickets{tickets: make(map[string]Ticket), ttl: DefaultTicketTTL}
+}
+
+// TTL returns how long tickets last
+func (t *Tickets) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue creates a ticket for the user's session
+func (t *Tickets) Issue(userID, sessionID uint) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for key, ticket := range t.tickets {
+		if now.After(ticket.ExpiresAt) {
+			delete(t.tickets, key)
+		}
+	}
+	t.tickets[id] = Ticket{UserID: userID, SessionID: sessionID, ExpiresAt: now.Add(t.ttl)}
+	return id, nil
+}
+
+// Redeem uses up a ticket, returning false if it doesn't exist, was already
+// used or expired
+func (t *Tickets) Redeem(id string) (Ticket, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ticket, ok := t.tickets[id]
+	if !ok {
+		return Ticket{}, false
+	}
+	delete(t.tickets, id)
+	if time.Now().After(ticket.ExpiresAt) {
+		return Ticket{}, false
+	}
+	return ticket, true
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/email"
+	"github.com/gsonntag/bruinbite/models"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// How long emailed links work for
+const (
+	verifyEmailTTL   = 48 * time.Hour
+	resetPasswordTTL = time.Hour
+)
+
+// At most tokenRequestLimit emails of each kind are sent to an address per tokenRequestWindow
+const (
+	tokenRequestLimit  = 3
+	tokenRequestWindow = time.Hour
+)
+
+// PasswordResetRequest represents the request body for asking for a password reset email
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+// ConfirmPasswordResetRequest represents the request body for setting a new password
+type ConfirmPasswordResetRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// VerifyEmailRequest represents the request body for verifying an email
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Returns true if another email of the kind can be sent to the address
+func underTokenLimit(mgr *db.DBManager, address string, purpose string) (bool, error) {
+	count, err := mgr.CountRecentUserTokens(address, purpose, time.Now().Add(-tokenRequestWindow))
+	if err != nil {
+		return false, err
+	}
+	return count < tokenRequestLimit, nil
+}
+
+// Creates a verification token for the user's current email and sends it
+func sendVerificationEmail(mgr *db.DBManager, mailer *email.Mailer, user *models.User) error {
+	token, err := mgr.CreateUserToken(user.ID, models.TokenVerifyEmail, user.Email, verifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return mailer.SendVerificationEmail(user, token, "2 days")
+}
+
+// RequestEmailVerificationHandler sends the current user a new verification email
+func RequestEmailVerificationHandler(mgr *db.DBManager, mailer *email.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		user, err := mgr.GetUserByID(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user info"})
+			return
+		}
+		if user.EmailVerified {
+			c.JSON(http.StatusConflict, gin.H{"error": "email is already verified"})
+			return
+		}
+
+		allowed, err := underTokenLimit(mgr, user.Email, models.TokenVerifyEmail)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many verification emails, try again later"})
+			return
+		}
+
+		if err := sendVerificationEmail(mgr, mailer, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+	}
+}
+
+// VerifyEmailHandler verifies an email using the token from a verification email
+func VerifyEmailHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request VerifyEmailRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := mgr.VerifyEmail(request.Token)
+		if err != nil {
+			if errors.Is(err, db.ErrInvalidToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully", "user": user})
+	}
+}
+
+// RequestPasswordResetHandler emails a password reset link. It answers the
+// same way whether or not the email has an account, so it can't be used to
+// find out who's signed up.
+func RequestPasswordResetHandler(mgr *db.DBManager, mailer *email.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request PasswordResetRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		address := strings.TrimSpace(request.Email)
+		if !emailRegex.MatchString(address) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid email"})
+			return
+		}
+
+		// The lookup and email happen after answering, so how long the request
+		// takes doesn't give away whether an account uses the email
+		go sendPasswordReset(mgr, mailer, address)
+		c.JSON(http.StatusOK, gin.H{"message": "If an account uses that email, a password reset link has been sent"})
+	}
+}
+
+// Emails a password reset link to the account using the address, if there is
+// one and it hasn't been sent too many already
+func sendPasswordReset(mgr *db.DBManager, mailer *email.Mailer, address string) {
+	user, err := mgr.GetUserByEmail(address)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+	if err != nil {
+		fmt.Printf("Warning: failed to look up user for password reset: %v\n", err)
+		return
+	}
+
+	allowed, err := underTokenLimit(mgr, user.Email, models.TokenResetPassword)
+	if err != nil {
+		fmt.Printf("Warning: failed to check password reset limit: %v\n", err)
+		return
+	}
+	if !allowed {
+		return
+	}
+
+	token, err := mgr.CreateUserToken(user.ID, models.TokenResetPassword, user.Email, resetPasswordTTL)
+	if err != nil {
+		fmt.Printf("Warning: failed to create password reset token: %v\n", err)
+		return
+	}
+	if err := mailer.SendPasswordResetEmail(user, token, "1 hour"); err != nil {
+		fmt.Printf("Warning: failed to send password reset email: %v\n", err)
+	}
+}
+
+// ConfirmPasswordResetHandler sets a new password using the token from a password reset email
+func ConfirmPasswordResetHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request ConfirmPasswordResetRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !passwordRegex.MatchString(request.Password) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid password"})
+			return
+		}
+
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to hash password"})
+			return
+		}
+
+		if _, err := mgr.ResetPassword(request.Token, string(hashedPassword)); err != nil {
+			if errors.Is(err, db.ErrInvalidToken) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully, you can now log in"})
+	}
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -9,11 +10,13 @@ import (
 	"github.com/gsonntag/bruinbite/search"
 )
 
-// BleveSearchRequest defines the query parameters for the Bleve search endpoint
+// BleveSearchRequest defines the query parameters for the Bleve search endpoint.
+// Optional include_tags/exclude_allergens dietary filters are read separately.
 type BleveSearchRequest struct {
 	Keyword string `form:"keyword" binding:"required"`
-	Hall    string `form:"hall"`  // Optional hall filter
-	Limit   int    `form:"limit"` // Optional result limit
+	Hall    string `form:"hall"`     // Optional hall filter
+	Limit   int    `form:"limit"`    // Optional result limit
+	GroupBy string `form:"group_by"` // Optional, "recipe" returns each recipe once with every hall serving it
 }
 
 // BleveSearchHandler returns a handler for Bleve-based dish search
@@ -32,7 +35,12 @@ func BleveSearchHandler(mgr *db.DBManager, searchMgr *search.BleveSearchManager)
 		}
 
 		// Search for dishes using Bleve
-		dishes, err := searchMgr.SearchDishes(request.Keyword, request.Hall, limit)
+		filter, _, err := ResolveDietaryFilter(c, mgr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		dishes, err := searchMgr.SearchDishes(request.Keyword, request.Hall, filter, limit)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -40,6 +48,7 @@ func BleveSearchHandler(mgr *db.DBManager, searchMgr *search.BleveSearchManager)
 
 		// Format response by getting full dish data from database
 		results := make([]map[string]interface{}, 0, len(dishes))
+		seenRecipes := make(map[uint]bool)
 		for _, dish := range dishes {
 			dishID, err := strconv.ParseUint(dish.ID, 10, 32)
 			if err != nil {
@@ -52,6 +61,11 @@ func BleveSearchHandler(mgr *db.DBManager, searchMgr *search.BleveSearchManager)
 				continue
 			}
 
+			// The index may be stale, so check the filter against the current dish too
+			if !filter.Allows(fullDish) {
+				continue
+			}
+
 			// add average_rating to response
 			dishResponse := map[string]interface{}{
 				"id":             fullDish.ID,
@@ -60,6 +74,32 @@ func BleveSearchHandler(mgr *db.DBManager, searchMgr *search.BleveSearchManager)
 				"description":    fullDish.Description,
 				"location":       fullDish.Location,
 				"average_rating": fullDish.AverageRating,
+				"tags":           fullDish.Tags,
+				"allergens":      fullDish.Allergens(),
+			}
+
+			// Ratings of the same recipe across every hall
+			if fullDish.Recipe != nil {
+				dishResponse["recipe_id"] = fullDish.Recipe.ID
+				dishResponse["recipe_average_rating"] = fullDish.Recipe.AverageRating
+				dishResponse["recipe_rating_count"] = fullDish.Recipe.RatingCount
+
+				if request.GroupBy == "recipe" {
+					if seenRecipes[fullDish.Recipe.ID] {
+						continue
+					}
+					seenRecipes[fullDish.Recipe.ID] = true
+
+					if recipe, err := mgr.GetRecipeByID(fullDish.Recipe.ID); err == nil {
+						hallNames := make([]string, 0, len(recipe.Dishes))
+						for _, recipeDish := range recipe.Dishes {
+							if !slices.Contains(hallNames, recipeDish.Hall.Name) {
+								hallNames = append(hallNames, recipeDish.Hall.Name)
+							}
+						}
+						dishResponse["hall_names"] = hallNames
+					}
+				}
 			}
 
 			results = append(results, dishResponse)
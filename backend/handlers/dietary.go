@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// ParseDietaryFilter reads the include_tags and exclude_allergens query
+// params, both comma separated lists, e.g. ?include_tags=vegan&exclude_allergens=peanuts,tree-nuts
+func ParseDietaryFilter(c *gin.Context) models.DietaryFilter {
+	return models.DietaryFilter{
+		IncludeTags:      splitList(c.Query("include_tags")),
+		ExcludeAllergens: splitList(c.Query("exclude_allergens")),
+	}
+}
+
+// ResolveDietaryFilter returns the dietary filter for a request: the query
+// param filters, combined with the logged in user's saved preferences unless
+// the request has ?use_preferences=false. The preferences are also returned
+// (nil for anonymous users or when they aren't used). If the preferences
+// can't be loaded an error is returned, so dishes with the user's allergens
+// are never shown unfiltered.
+func ResolveDietaryFilter(c *gin.Context, mgr *db.DBManager) (models.DietaryFilter, *models.UserPreferences, error) {
+	filter := ParseDietaryFilter(c)
+	if c.Query("use_preferences") == "false" {
+		return filter, nil, nil
+	}
+
+	userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+	if err != nil {
+		return filter, nil, nil // not logged in
+	}
+
+	prefs, err := mgr.GetUserPreferences(uint(userID))
+	if err != nil {
+		return filter, nil, fmt.Errorf("failed to load dietary preferences: %w", err)
+	}
+	return filter.Merge(prefs.DietaryFilter()), prefs, nil
+}
+
+// Splits a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
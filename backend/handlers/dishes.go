@@ -3,9 +3,11 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
 )
 
 type DishSearchRequest struct {
@@ -82,8 +84,11 @@ func GetDishDetailsHandler(mgr *db.DBManager) gin.HandlerFunc {
 			"description":    dish.Description,
 			"average_rating": dish.AverageRating,
 			"tags":           dish.Tags,
+			"allergens":      dish.Allergens(),
+			"nutrition":      dish.Nutrition,
 			"location":       dish.Location,
 			"last_seen_date": dish.LastSeenDate,
+			"recipe":         dish.Recipe, // ratings aggregated over every hall serving this dish
 			"hall": map[string]interface{}{
 				"id":       hall.ID,
 				"name":     hall.Name,
@@ -94,3 +99,71 @@ func GetDishDetailsHandler(mgr *db.DBManager) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"dish": response})
 	}
 }
+
+// GetDishAppearancesHandler returns every date, hall and meal period a dish
+// was served, how often it's served and when it's likely to be served next.
+// Optional query params: weeks (how far back the stats look, default 8) and
+// scope=recipe to include the same recipe at other halls.
+func GetDishAppearancesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dishID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || dishID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dish_id"})
+			return
+		}
+
+		weeks := 8
+		if weeksStr := c.Query("weeks"); weeksStr != "" {
+			weeks, err = strconv.Atoi(weeksStr)
+			if err != nil || weeks <= 0 || weeks > 104 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "weeks must be between 1 and 104"})
+				return
+			}
+		}
+
+		dish, err := mgr.GetDishByID(uint(dishID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dish not found"})
+			return
+		}
+
+		dishIDs := []uint{dish.ID}
+		if c.Query("scope") == "recipe" && dish.RecipeID != nil {
+			recipe, err := mgr.GetRecipeByID(*dish.RecipeID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			dishIDs = dishIDs[:0]
+			for _, recipeDish := range recipe.Dishes {
+				dishIDs = append(dishIDs, recipeDish.ID)
+			}
+		}
+
+		appearances, err := mgr.GetDishAppearances(dishIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Show meal periods the way each hall labels them, e.g. ALL_DAY instead of BREAKFAST
+		hallLabels, err := mgr.GetAllMealPeriodLabels()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i, appearance := range appearances {
+			if label, ok := hallLabels[appearance.HallName][appearance.MealPeriod]; ok {
+				appearances[i].MealPeriod = label
+			}
+		}
+
+		today := models.DateOf(time.Now().In(mgr.TZ))
+		c.JSON(http.StatusOK, gin.H{
+			"dish_id":     dish.ID,
+			"name":        dish.Name,
+			"appearances": appearances,
+			"stats":       models.ComputeAppearanceStats(appearances, today, weeks, mgr.TZ),
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/schedule"
+	"gorm.io/gorm"
+)
+
+// Confirmation page for unsubscribe links. Following the link only shows it,
+// so link scanners and prefetching can't unsubscribe anyone.
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta name="viewport" content="width=device-width, initial-scale=1"><title>Unsubscribe - BruinBite</title></head>
+<body style="font-family: Helvetica, Arial, sans-serif; color: #1f2937; max-width: 600px; margin: 40px auto;">
+  {{if .Done}}
+  <h2 style="color: #2774ae;">You're unsubscribed</h2>
+  <p>You won't get {{.List}} anymore. You can turn emails back on in your BruinBite settings.</p>
+  {{else}}
+  <h2 style="color: #2774ae;">Unsubscribe from {{.List}}?</h2>
+  <form method="post" action="{{.Action}}">
+    <button type="submit" style="background: #2774ae; color: #fff; border: 0; padding: 10px 16px; border-radius: 4px;">Unsubscribe</button>
+  </form>
+  {{end}}
+</body>
+</html>
+`))
+
+// Describes the emails an unsubscribe link is for
+func unsubscribeListName(list string) string {
+	switch list {
+	case "digest":
+		return "the daily digest"
+	case "notifications":
+		return "notification emails"
+	default:
+		return "BruinBite emails"
+	}
+}
+
+// Reads the token and list from an unsubscribe link. Returns false after
+// responding if they aren't valid.
+func unsubscribeParams(c *gin.Context) (string, string, bool) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return "", "", false
+	}
+	list := c.Query("list")
+	if list != "" && list != "digest" && list != "notifications" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid list, expected digest or notifications"})
+		return "", "", false
+	}
+	return token, list, true
+}
+
+// UnsubscribePageHandler shows the confirmation page for an email's
+// unsubscribe link, which posts back to UnsubscribeHandler
+func UnsubscribePageHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, list, ok := unsubscribeParams(c)
+		if !ok {
+			return
+		}
+
+		if _, err := mgr.GetEmailPreferencesByUnsubscribeToken(token); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "invalid unsubscribe link"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load unsubscribe link"})
+			return
+		}
+
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		unsubscribePage.Execute(c.Writer, gin.H{"List": unsubscribeListName(list), "Action": c.Request.URL.RequestURI()})
+	}
+}
+
+// UnsubscribeHandler turns off emails using the token from an email's
+// unsubscribe link, without logging in. It's posted to by the confirmation
+// page and by mail clients' one-click unsubscribe (RFC 8058).
+func UnsubscribeHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, list, ok := unsubscribeParams(c)
+		if !ok {
+			return
+		}
+
+		prefs, err := mgr.Unsubscribe(token, list)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "invalid unsubscribe link"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unsubscribe"})
+			return
+		}
+
+		// The confirmation page's form wants a page back, mail clients don't care
+		if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusOK)
+			unsubscribePage.Execute(c.Writer, gin.H{"List": unsubscribeListName(list), "Done": true})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":           "Unsubscribed successfully",
+			"email_preferences": prefs,
+		})
+	}
+}
+
+// DigestStatusHandler returns the last and next run of the daily digest
+func DigestStatusHandler(scheduler *schedule.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": scheduler.Status()})
+	}
+}
+
+// TriggerDigestHandler sends today's digest in the background to everyone who hasn't gotten it yet
+func TriggerDigestHandler(scheduler *schedule.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := scheduler.Trigger(); err != nil {
+			if errors.Is(err, schedule.ErrAlreadyRunning) {
+				c.JSON(http.StatusConflict, gin.H{"error": "digest is already being sent"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Digest started"})
+	}
+}
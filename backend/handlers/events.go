@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/events"
+)
+
+// How often a comment is sent on idle streams so proxies don't close them
+const eventsHeartbeat = 25 * time.Second
+
+// StreamTicketHandler issues a single-use ticket for opening /events, for
+// clients like the browser's EventSource that can't set headers. The ticket
+// goes in the URL instead of the access token so the token never gets logged.
+func StreamTicketHandler(tickets *events.Tickets) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		ticket, err := tickets.Issue(uint(userID), c.GetUint("sessionId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not create ticket"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ticket": ticket, "expires_in": int(tickets.TTL().Seconds())})
+	}
+}
+
+// StreamAuthMiddleware authenticates a request with a ?ticket= from
+// StreamTicketHandler, or falls back to AuthMiddleware without one
+func StreamAuthMiddleware(mgr *db.DBManager, tickets *events.Tickets) gin.HandlerFunc {
+	auth := AuthMiddleware(mgr)
+	return func(c *gin.Context) {
+		id := c.Query("ticket")
+		if id == "" {
+			auth(c)
+			return
+		}
+
+		ticket, ok := tickets.Redeem(id)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired ticket"})
+			c.Abort()
+			return
+		}
+		active, err := mgr.IsSessionActive(ticket.SessionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userId", strconv.FormatUint(uint64(ticket.UserID), 10))
+		c.Set("sessionId", ticket.SessionID)
+		c.Next()
+	}
+}
+
+// EventsHandler streams the user's events as Server-Sent Events until the
+// client disconnects. The SSE event name is the event type, e.g. "notification".
+func EventsHandler(hub *events.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		sub := hub.Subscribe(uint(userID), c.GetUint("sessionId"))
+		defer sub.Close()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // stop nginx from holding events back
+		c.Status(http.StatusOK)
+		c.SSEvent("connected", gin.H{"user_id": userID})
+		c.Writer.Flush()
+
+		heartbeat := time.NewTicker(eventsHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case event, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+				c.SSEvent(event.Type, event.Data)
+			case <-heartbeat.C:
+				if _, err := c.Writer.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+			}
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"gorm.io/gorm"
+)
+
+// FavoriteRequest represents the request body for adding a favorite.
+// Set dish_id to follow one hall's dish, or recipe_id to follow it at every hall.
+type FavoriteRequest struct {
+	DishID   *uint `json:"dish_id"`
+	RecipeID *uint `json:"recipe_id"`
+}
+
+// GetFavoritesHandler returns the user's favorite dishes and recipes
+func GetFavoritesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		favorites, err := mgr.GetFavorites(uint(userId))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"favorites": favorites})
+	}
+}
+
+// AddFavoriteHandler follows a dish or recipe, so the user is alerted when it's on a menu
+func AddFavoriteHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request FavoriteRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if (request.DishID == nil) == (request.RecipeID == nil) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of dish_id and recipe_id is required"})
+			return
+		}
+
+		userId, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		favorite, err := mgr.AddFavorite(uint(userId), request.DishID, request.RecipeID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if request.DishID != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "dish not found"})
+			} else {
+				c.JSON(http.StatusNotFound, gin.H{"error": "recipe not found"})
+			}
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"favorite": favorite})
+	}
+}
+
+// RemoveFavoriteHandler stops following a dish or recipe
+func RemoveFavoriteHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		favoriteID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || favoriteID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid favorite ID"})
+			return
+		}
+
+		userId, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		if err := mgr.RemoveFavorite(uint(userId), uint(favoriteID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "favorite not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Favorite removed"})
+	}
+}
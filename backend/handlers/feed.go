@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
+)
+
+// FeedRequestQuery represents the query params for the activity feed
+type FeedRequestQuery struct {
+	Before   uint   `form:"before"`    // only activities older than this ID, for paging
+	Limit    int    `form:"limit"`     // default 20
+	Hall     string `form:"hall"`      // only activities at this hall (slug)
+	FriendID uint   `form:"friend_id"` // only this friend's activities
+	Type     string `form:"type"`      // only this type: rating, friendship, favorite or achievement
+}
+
+// GetFeedHandler returns what the user's friends have been up to, newest
+// first. Pass next_cursor as before to get the next page.
+func GetFeedHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var query FeedRequestQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if query.Limit <= 0 || query.Limit > 100 {
+			query.Limit = 20
+		}
+		if query.Type != "" && !slices.Contains(models.ActivityTypes, query.Type) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown activity type: " + query.Type, "types": models.ActivityTypes})
+			return
+		}
+
+		feedQuery := models.FeedQuery{
+			Before:   query.Before,
+			Limit:    query.Limit,
+			FriendID: query.FriendID,
+			Type:     query.Type,
+		}
+		if query.Hall != "" {
+			hall, err := mgr.GetHallByName(query.Hall)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "hall not found"})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			feedQuery.HallID = hall.ID
+		}
+		if query.FriendID > 0 {
+			friends, err := mgr.AreFriends(uint(userID), query.FriendID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if !friends {
+				c.JSON(http.StatusForbidden, gin.H{"error": "not friends with this user"})
+				return
+			}
+		}
+
+		activities, err := mgr.GetFeed(uint(userID), feedQuery)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The feed includes people who aren't the user's friends (their friends' new friends)
+		for i := range activities {
+			activities[i].User.Email = ""
+		}
+
+		// A full page means there may be more
+		var nextCursor *uint
+		if len(activities) == query.Limit {
+			nextCursor = &activities[len(activities)-1].ID
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"activities":  activities,
+			"next_cursor": nextCursor,
+		})
+	}
+}
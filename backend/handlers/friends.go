@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
 	"github.com/gsonntag/bruinbite/search"
+	"gorm.io/gorm"
 )
 
 func GetFriendsHandler(mgr *db.DBManager) gin.HandlerFunc {
@@ -71,8 +76,26 @@ func GetIncomingFriendRequestsHandler(mgr *db.DBManager) gin.HandlerFunc {
 	}
 }
 
-// sendFriendRequestHandler handles sending a friend request
-func SendFriendRequestHandler(mgr *db.DBManager) gin.HandlerFunc {
+// Responds with the status code for a friend request error from the database
+func friendRequestError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, db.ErrFriendRequestNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	case errors.Is(err, db.ErrNotRequestRecipient), errors.Is(err, db.ErrNotRequestSender), errors.Is(err, db.ErrBlocked):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrFriendRequestNotPending), errors.Is(err, db.ErrFriendRequestPending), errors.Is(err, db.ErrAlreadyFriends):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrFriendRequestToSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// SendFriendRequestHandler handles sending a friend request
+func SendFriendRequestHandler(mgr *db.DBManager, notifier notify.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request struct {
 			FriendID uint `json:"friend_id" binding:"required"`
@@ -89,55 +112,111 @@ func SendFriendRequestHandler(mgr *db.DBManager) gin.HandlerFunc {
 			return
 		}
 
-		err = mgr.SendFriendRequest(uint(userIdInt), request.FriendID)
+		friendRequest, err := mgr.SendFriendRequest(uint(userIdInt), request.FriendID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to send friend request"})
+			friendRequestError(c, err, "unable to send friend request")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Friend request sent successfully"})
+		if sender, err := mgr.GetUserByID(uint(userIdInt)); err == nil {
+			sendNotifications(notifier, notify.Message{
+				UserID: request.FriendID,
+				Type:   notify.TypeFriendRequest,
+				Title:  sender.Username + " sent you a friend request",
+				Data:   map[string]interface{}{"request_id": friendRequest.ID, "from_id": sender.ID, "username": sender.Username},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Friend request sent successfully", "request": friendRequest})
 	}
 }
 
-// AcceptFriendRequestHandler handles accepting a friend request
-func AcceptFriendRequestHandler(mgr *db.DBManager) gin.HandlerFunc {
+// FriendRequestAction represents the request body for answering or cancelling a friend request
+type FriendRequestAction struct {
+	RequestID uint `json:"request_id" binding:"required"`
+}
+
+// AcceptFriendRequestHandler handles accepting a friend request sent to the user
+func AcceptFriendRequestHandler(mgr *db.DBManager, notifier notify.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request struct {
-			RequestID uint `json:"request_id" binding:"required"`
-		}
+		var request FriendRequestAction
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		err := mgr.AcceptFriendRequest(request.RequestID)
+		userId, err := strconv.Atoi(c.GetString("userId"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to accept friend request"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
+
+		friendRequest, err := mgr.AcceptFriendRequest(uint(userId), request.RequestID)
+		if err != nil {
+			friendRequestError(c, err, "unable to accept friend request")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Friend request accepted successfully"})
+		if accepter, err := mgr.GetUserByID(friendRequest.ToID); err == nil {
+			sendNotifications(notifier, notify.Message{
+				UserID: friendRequest.FromID,
+				Type:   notify.TypeFriendAccepted,
+				Title:  accepter.Username + " accepted your friend request",
+				Data:   map[string]interface{}{"user_id": accepter.ID, "username": accepter.Username},
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Friend request accepted successfully", "request": friendRequest})
 	}
 }
 
-// DeclineFriendRequestHandler handles declining a friend request
+// DeclineFriendRequestHandler handles declining a friend request sent to the user
 func DeclineFriendRequestHandler(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var request struct {
-			RequestID uint `json:"request_id" binding:"required"`
+		var request FriendRequestAction
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userId, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
 		}
+
+		friendRequest, err := mgr.DeclineFriendRequest(uint(userId), request.RequestID)
+		if err != nil {
+			friendRequestError(c, err, "unable to decline friend request")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Friend request declined successfully", "request": friendRequest})
+	}
+}
+
+// CancelFriendRequestHandler handles taking back a friend request the user sent
+func CancelFriendRequestHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request FriendRequestAction
 		if err := c.ShouldBindJSON(&request); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		err := mgr.DeleteFriendRequest(request.RequestID)
+		userId, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
+
+		friendRequest, err := mgr.CancelFriendRequest(uint(userId), request.RequestID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to decline friend request"})
+			friendRequestError(c, err, "unable to cancel friend request")
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "Friend request declined successfully"})
+		c.JSON(http.StatusOK, gin.H{"message": "Friend request cancelled successfully", "request": friendRequest})
 	}
 }
 
@@ -154,11 +233,29 @@ func SearchUsersHandler(mgr *db.DBManager) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, users)
+
+		visibility, err := loadUserVisibility(mgr, viewerID(c), users)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		visible := []models.User{}
+		for _, user := range users {
+			if user.ID == viewerID(c) || !visibility.searchable(user.ID) {
+				continue
+			}
+			if !visibility.showEmail(user.ID) {
+				user.Email = ""
+			}
+			visible = append(visible, user)
+		}
+		c.JSON(http.StatusOK, visible)
 	}
 }
 
-// BleveSearchUsersHandler handles searching for users using Bleve search with fuzzy matching
+// BleveSearchUsersHandler handles searching for users using Bleve search with fuzzy matching.
+// Users who made their profile private or blocked the searcher are left out, and
+// a full email finds the user with that email if they allow it.
 func BleveSearchUsersHandler(mgr *db.DBManager, userSearchManager *search.BleveUserSearchManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username := c.Query("username")
@@ -177,7 +274,17 @@ func BleveSearchUsersHandler(mgr *db.DBManager, userSearchManager *search.BleveU
 
 		// Track found user IDs to avoid duplicates
 		foundUserIDs := make(map[uint]bool)
-		var allUsers []map[string]interface{}
+		var candidates []models.User
+
+		// An exact email match goes first, it's dropped below unless the user is searchable by email
+		var emailMatchID uint
+		if strings.Contains(username, "@") {
+			if user, err := mgr.GetUserByEmail(strings.TrimSpace(username)); err == nil && user.ID != uint(userIdInt) {
+				emailMatchID = user.ID
+				foundUserIDs[user.ID] = true
+				candidates = append(candidates, *user)
+			}
+		}
 
 		// First: Try Bleve search with fuzzy matching
 		userDocs, err := userSearchManager.SearchUsers(username, uint(userIdInt), 20)
@@ -202,17 +309,7 @@ func BleveSearchUsersHandler(mgr *db.DBManager, userSearchManager *search.BleveU
 				}
 
 				foundUserIDs[uint(userID)] = true
-				user := map[string]interface{}{
-					"ID":              fullUser.ID,
-					"username":        fullUser.Username,
-					"email":           fullUser.Email,
-					"profile_picture": fullUser.ProfilePicture,
-					"CreatedAt":       fullUser.CreatedAt,
-					"UpdatedAt":       fullUser.UpdatedAt,
-					"DeletedAt":       fullUser.DeletedAt,
-					"is_admin":        fullUser.IsAdmin,
-				}
-				allUsers = append(allUsers, user)
+				candidates = append(candidates, *fullUser)
 			}
 		}
 
@@ -229,18 +326,41 @@ func BleveSearchUsersHandler(mgr *db.DBManager, userSearchManager *search.BleveU
 				}
 
 				foundUserIDs[dbUser.ID] = true
-				user := map[string]interface{}{
-					"ID":              dbUser.ID,
-					"username":        dbUser.Username,
-					"email":           dbUser.Email,
-					"profile_picture": dbUser.ProfilePicture,
-					"CreatedAt":       dbUser.CreatedAt,
-					"UpdatedAt":       dbUser.UpdatedAt,
-					"DeletedAt":       dbUser.DeletedAt,
-					"is_admin":        dbUser.IsAdmin,
-				}
-				allUsers = append(allUsers, user)
+				candidates = append(candidates, dbUser)
+			}
+		}
+
+		// Leave out users the searcher isn't allowed to find
+		visibility, err := loadUserVisibility(mgr, uint(userIdInt), candidates)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
+		var allUsers []map[string]interface{}
+		for _, candidate := range candidates {
+			if !visibility.searchable(candidate.ID) {
+				continue
+			}
+			if candidate.ID == emailMatchID && !visibility.settings[candidate.ID].SearchableByEmail {
+				continue
+			}
+
+			// Someone who typed the exact email already knows it
+			email := ""
+			if visibility.showEmail(candidate.ID) || candidate.ID == emailMatchID {
+				email = candidate.Email
+			}
+			user := map[string]interface{}{
+				"ID":              candidate.ID,
+				"username":        candidate.Username,
+				"email":           email,
+				"profile_picture": candidate.ProfilePicture,
+				"CreatedAt":       candidate.CreatedAt,
+				"UpdatedAt":       candidate.UpdatedAt,
+				"DeletedAt":       candidate.DeletedAt,
+				"is_admin":        candidate.IsAdmin,
 			}
+			allUsers = append(allUsers, user)
 		}
 
 		// Limit results to 20 and sort by relevance (Bleve results first, then DB results)
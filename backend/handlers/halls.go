@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// HallRequest represents the request body for creating or updating a hall.
+// Fields left out of an update are not changed.
+type HallRequest struct {
+	Slug        string   `json:"slug"` // only used when creating a hall
+	DisplayName *string  `json:"display_name"`
+	Description *string  `json:"description"`
+	Latitude    *float64 `json:"latitude"`
+	Longitude   *float64 `json:"longitude"`
+	ImageURL    *string  `json:"image_url"`
+	HallType    *string  `json:"hall_type"`
+	Location    *string  `json:"location"`
+}
+
+var slugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// GetHallHandler returns a hall's metadata
+func GetHallHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hall, ok := hallFromParam(c, mgr)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"hall": hall})
+	}
+}
+
+// CreateHallHandler adds a new venue. Ingest also adds venues it finds on its
+// own, so this is mostly useful to set up a hall's metadata ahead of time.
+func CreateHallHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req HallRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		slug := strings.ToLower(strings.TrimSpace(req.Slug))
+		if !slugRegex.MatchString(slug) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "slug must be lowercase words separated by dashes, e.g. the-study-at-hedrick"})
+			return
+		}
+		// Validated before anything is saved, so a bad field doesn't leave a half-made hall behind
+		updates, ok := hallUpdates(c, req)
+		if !ok {
+			return
+		}
+		if _, err := mgr.GetHallByName(slug); err == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "hall already exists"})
+			return
+		}
+
+		var hall models.DiningHall
+		err := mgr.Transaction(func(tx *db.DBManager) error {
+			var err error
+			if hall, err = tx.CreateNewHall(slug); err != nil {
+				return err
+			}
+			return tx.UpdateHall(hall.ID, updates)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		created, err := mgr.GetHallByID(hall.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"hall": created})
+	}
+}
+
+// UpdateHallHandler changes a hall's metadata
+func UpdateHallHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hall, ok := hallFromParam(c, mgr)
+		if !ok {
+			return
+		}
+
+		var req HallRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		updates, ok := hallUpdates(c, req)
+		if !ok {
+			return
+		}
+		if err := mgr.UpdateHall(hall.ID, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		updated, err := mgr.GetHallByID(hall.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Hall updated successfully",
+			"hall":    updated,
+		})
+	}
+}
+
+// Turns the fields set in the request into column updates, responding with an error if any are invalid
+func hallUpdates(c *gin.Context, req HallRequest) (map[string]interface{}, bool) {
+	updates := map[string]interface{}{}
+	if req.DisplayName != nil {
+		name := strings.TrimSpace(*req.DisplayName)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "display_name can't be empty"})
+			return nil, false
+		}
+		updates["display_name"] = name
+	}
+	if req.HallType != nil {
+		if *req.HallType != models.HallTypeResidential && *req.HallType != models.HallTypeASUCLA {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hall_type must be residential or asucla"})
+			return nil, false
+		}
+		updates["hall_type"] = *req.HallType
+	}
+	if req.Latitude != nil {
+		if *req.Latitude < -90 || *req.Latitude > 90 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid latitude"})
+			return nil, false
+		}
+		updates["latitude"] = *req.Latitude
+	}
+	if req.Longitude != nil {
+		if *req.Longitude < -180 || *req.Longitude > 180 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid longitude"})
+			return nil, false
+		}
+		updates["longitude"] = *req.Longitude
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.ImageURL != nil {
+		updates["image_url"] = *req.ImageURL
+	}
+	if req.Location != nil {
+		updates["location"] = *req.Location
+	}
+	return updates, true
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
+)
+
+// HallHoursRequest is one meal period's hours in a request body
+type HallHoursRequest struct {
+	DayOfWeek  int    `json:"day_of_week"`
+	MealPeriod string `json:"meal_period" binding:"required"`
+	Label      string `json:"label"`
+	Opens      string `json:"opens" binding:"required"`
+	Closes     string `json:"closes" binding:"required"`
+}
+
+// HallHoursOverrideRequest represents the request body for overriding a hall's hours on a date
+type HallHoursOverrideRequest struct {
+	Date       string `json:"date" binding:"required"` // YYYY-MM-DD
+	MealPeriod string `json:"meal_period"`             // empty with closed=true closes the hall all day
+	Label      string `json:"label"`
+	Opens      string `json:"opens"`
+	Closes     string `json:"closes"`
+	Closed     bool   `json:"closed"`
+	Note       string `json:"note"`
+}
+
+// GetHallHoursHandler returns a hall's regular weekly hours, its upcoming
+// overrides and its hours on a date (?date=YYYY-MM-DD, today by default)
+func GetHallHoursHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hall, ok := hallFromParam(c, mgr)
+		if !ok {
+			return
+		}
+
+		today := models.DateOf(time.Now().In(mgr.TZ))
+		date := today
+		if dateStr := c.Query("date"); dateStr != "" {
+			var err error
+			if date, err = models.ParseDate(dateStr); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date, expected YYYY-MM-DD"})
+				return
+			}
+		}
+
+		weekly, err := mgr.GetHallHours(hall.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		overrides, err := mgr.GetHallHoursOverrides(hall.ID, today)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		periods, err := mgr.GetHallPeriods(*hall, date)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"hall":      hall.Name,
+			"weekly":    weekly,
+			"overrides": overrides,
+			"date":      date.String(),
+			"periods":   periods,
+		})
+	}
+}
+
+// OpenNowHandler returns the halls that are serving a meal period right now
+func OpenNowHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		now := time.Now().In(mgr.TZ)
+		open, err := mgr.GetOpenHallPeriods(now)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"time":  now,
+			"halls": open,
+		})
+	}
+}
+
+// SetHallHoursHandler replaces a hall's regular weekly hours
+func SetHallHoursHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hall, ok := hallFromParam(c, mgr)
+		if !ok {
+			return
+		}
+
+		var req []HallHoursRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hours := make([]models.HallHours, 0, len(req))
+		for _, h := range req {
+			if h.DayOfWeek < 0 || h.DayOfWeek > 6 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "day_of_week must be 0 (Sunday) to 6 (Saturday)"})
+				return
+			}
+			if err := validateHours(h.Opens, h.Closes); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			period := strings.ToUpper(h.MealPeriod)
+			label := strings.ToUpper(h.Label)
+			if label == "" {
+				label = period
+			}
+			hours = append(hours, models.HallHours{
+				DayOfWeek:  h.DayOfWeek,
+				MealPeriod: period,
+				Label:      label,
+				Opens:      h.Opens,
+				Closes:     h.Closes,
+			})
+		}
+
+		if err := mgr.SetHallHours(hall.ID, hours); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Hours updated successfully",
+			"hours":   hours,
+		})
+	}
+}
+
+// AddHallHoursOverrideHandler overrides a hall's hours on one date, e.g. for a holiday
+func AddHallHoursOverrideHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hall, ok := hallFromParam(c, mgr)
+		if !ok {
+			return
+		}
+
+		var req HallHoursOverrideRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		date, err := models.ParseDate(req.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		if req.MealPeriod == "" && !req.Closed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "meal_period is required unless the hall is closed all day"})
+			return
+		}
+		if !req.Closed {
+			if err := validateHours(req.Opens, req.Closes); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if req.MealPeriod != "" {
+			period := strings.ToUpper(req.MealPeriod)
+			date.MealPeriod = &period
+		}
+
+		override := models.HallHoursOverride{
+			HallID: hall.ID,
+			Date:   date,
+			Label:  strings.ToUpper(req.Label),
+			Opens:  req.Opens,
+			Closes: req.Closes,
+			Closed: req.Closed,
+			Note:   req.Note,
+		}
+		if err := mgr.AddHallHoursOverride(&override); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"override": override})
+	}
+}
+
+// DeleteHallHoursOverrideHandler removes one of a hall's overrides
+func DeleteHallHoursOverrideHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		hall, ok := hallFromParam(c, mgr)
+		if !ok {
+			return
+		}
+
+		overrideID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid override ID"})
+			return
+		}
+
+		err = mgr.DeleteHallHoursOverride(hall.ID, uint(overrideID))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "override not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Override deleted successfully"})
+	}
+}
+
+// Looks up the hall from the :slug path param, responding with an error if it doesn't exist
+func hallFromParam(c *gin.Context, mgr *db.DBManager) (*models.DiningHall, bool) {
+	hall, err := mgr.GetHallByName(c.Param("slug"))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "hall not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return hall, true
+}
+
+func validateHours(opens, closes string) error {
+	if _, err := models.ParseClock(opens); err != nil {
+		return err
+	}
+	_, err := models.ParseClock(closes)
+	return err
+}
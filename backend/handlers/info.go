@@ -21,31 +21,48 @@ func GetCurUserInfoHandler(mgr *db.DBManager) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
 			return
 		}
+		unread, err := mgr.CountUnreadNotifications(uint(userIdInt))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
-			"user": user,
+			"user":                 user,
+			"unread_notifications": unread,
 		})
 	}
 }
 
+// GetUserInfoHandler returns a user's profile. Viewers the profile is hidden
+// from only get the username and picture, with restricted set.
 func GetUserInfoHandler(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		username := c.Param("username")
-		if username == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		user, relationship, settings, ok := loadProfile(c, mgr)
+		if !ok {
 			return
 		}
 
-		user, err := mgr.GetUserByUsername(username)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if !relationship.CanSee(settings.ProfileVisibility) {
+			c.JSON(http.StatusOK, gin.H{
+				"user": gin.H{
+					"ID":              user.ID,
+					"username":        user.Username,
+					"profile_picture": user.ProfilePicture,
+				},
+				"relationship": relationship,
+				"restricted":   true,
+			})
 			return
 		}
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+
+		if !relationship.Self && !relationship.Friends {
+			user.Email = ""
 		}
 		c.JSON(http.StatusOK, gin.H{
-			"user": user,
+			"user":            user,
+			"relationship":    relationship,
+			"restricted":      false,
+			"ratings_visible": relationship.CanSee(settings.RatingsVisibility),
 		})
 	}
 }
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/schedule"
+)
+
+// IngestStatusHandler returns the last and next run of the menu ingest scheduler
+func IngestStatusHandler(scheduler *schedule.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": scheduler.Status()})
+	}
+}
+
+// TriggerIngestHandler starts a menu ingest run in the background
+func TriggerIngestHandler(scheduler *schedule.Scheduler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := scheduler.Trigger(); err != nil {
+			if errors.Is(err, schedule.ErrAlreadyRunning) {
+				c.JSON(http.StatusConflict, gin.H{"error": "ingest is already running"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"message": "Ingest started"})
+	}
+}
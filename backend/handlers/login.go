@@ -3,12 +3,8 @@ package handlers
 import (
 	"errors"
 	"net/http"
-	"os"
-	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/gsonntag/bruinbite/db"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
@@ -45,27 +41,14 @@ func LoginHandler(mgr *db.DBManager) gin.HandlerFunc {
 			return
 		}
 
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			// secret not set
-			jwtSecret = "F4LLB4CK" // just for dev
-		}
-
-		claims := jwt.MapClaims{
-			"sub":      strconv.Itoa(int(user.ID)),
-			"username": user.Username,
-			"exp":      time.Now().Add(24 * time.Hour).Unix(),
-			"iat":      time.Now().Unix(),
-		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString([]byte(jwtSecret))
+		response, err := startSession(c, mgr, user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 			return
 		}
 
 		user.HashedPassword = ""
-		c.JSON(http.StatusOK, gin.H{"token": tokenString, "user": user})
+		response["user"] = user
+		c.JSON(http.StatusOK, response)
 	}
 }
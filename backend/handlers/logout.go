@@ -2,14 +2,43 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gsonntag/bruinbite/db"
 )
 
+// LogoutHandler revokes the session the request was made with
 func LogoutHandler(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Using stateless JWT, so there is nothing to remove on the server's end
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		if err := mgr.RevokeSession(uint(userID), c.GetUint("sessionId")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"message": "logout success"})
 	}
-}
\ No newline at end of file
+}
+
+// LogoutAllHandler revokes every one of the user's sessions, including the current one
+func LogoutAllHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		count, err := mgr.RevokeAllSessions(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log out"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "logged out everywhere", "count": count})
+	}
+}
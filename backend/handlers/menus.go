@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"slices"
 	"sort"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gsonntag/bruinbite/db"
 	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
 )
 
 type MenusQuery struct {
@@ -26,11 +29,6 @@ type DayQuery struct {
 	Year     int    `form:"year" binding:"required"`
 }
 
-func HallHasAllDayBreakfast(hallName string) bool {
-	allPeriodsBreakfast := []string{"the-drey", "rendezvous", "epicuria-at-ackerman", "bruin-cafe"}
-	return slices.Contains(allPeriodsBreakfast, hallName)
-}
-
 func GetMenuHandler(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var query MenusQuery
@@ -46,9 +44,13 @@ func GetMenuHandler(mgr *db.DBManager) gin.HandlerFunc {
 			MealPeriod: query.MealPeriod,
 		}
 
-		if HallHasAllDayBreakfast(query.HallName) {
-			*query.MealPeriod = "BREAKFAST" // internal storage, convert back
+		// Some halls show their menu as e.g. ALL_DAY, look up the period it's stored under
+		storedPeriod, err := mgr.GetStoredMealPeriod(query.HallName, *query.MealPeriod)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
+		*query.MealPeriod = storedPeriod
 
 		menu, err := mgr.GetMenuByHallNameAndDate(query.HallName, date)
 		if err != nil {
@@ -56,8 +58,24 @@ func GetMenuHandler(mgr *db.DBManager) gin.HandlerFunc {
 			return
 		}
 
+		// Optional dietary filters, e.g. include_tags=vegan&exclude_allergens=peanuts,
+		// plus the user's saved preferences if they're logged in
+		filter, _, err := ResolveDietaryFilter(c, mgr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		menu.Dishes = filter.FilterDishes(menu.Dishes)
+
+		stations, err := mgr.GetMenuStations(menu.ID, menu.Dishes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
-			"menu": menu,
+			"menu":     menu,
+			"stations": stations,
 		})
 	}
 }
@@ -92,19 +110,155 @@ func GetHallMealPeriods(mgr *db.DBManager) gin.HandlerFunc {
 			return false
 		})
 
-		// internally, drey, rende, bcafe, epic ackerman all use breakfast for all periods
-		// so if it is one of these, we return "Lunch & Dinner" or "All Day" for Bcafe
-		if len(mealPeriods) > 0 {
-			allPeriodsBreakfast := []string{"the-drey", "rendezvous", "epicuria-at-ackerman"}
-			if query.HallName == "bruin-cafe" {
-				mealPeriods = []string{"ALL_DAY"}
-			} else if slices.Contains(allPeriodsBreakfast, query.HallName) {
-				mealPeriods = []string{"LUNCH_DINNER"}
+		// Some halls store one menu under BREAKFAST for several periods, so
+		// return the labels from their hours instead, e.g. "ALL_DAY" for bcafe
+		labels, err := mgr.GetMealPeriodLabels(query.HallName)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i, period := range mealPeriods {
+			if label, ok := labels[period]; ok {
+				mealPeriods[i] = label
 			}
 		}
+		mealPeriods = slices.Compact(mealPeriods)
 
 		c.JSON(http.StatusOK, gin.H{
 			"periods": mealPeriods,
 		})
 	}
 }
+
+type MenuDiffQuery struct {
+	MenusQuery
+	Weeks int `form:"weeks"` // how many previous weeks to compare against, default 4
+}
+
+type NewDishesQuery struct {
+	HallName string `form:"hall_name"` // optional
+	Days     int    `form:"days"`      // how far back to look, default 7
+	Limit    int    `form:"limit"`     // default 50
+}
+
+// GetMenuDiffHandler compares a hall's menu for a date and meal period with
+// the previous weeks: which dishes are brand new, which are back after a
+// long absence and which regulars were dropped
+func GetMenuDiffHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query MenuDiffQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if query.Weeks == 0 {
+			query.Weeks = 4
+		}
+		if query.Weeks < 1 || query.Weeks > 52 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "weeks must be between 1 and 52"})
+			return
+		}
+
+		storedPeriod, err := mgr.GetStoredMealPeriod(query.HallName, *query.MealPeriod)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		date := models.Date{Day: query.Day, Month: query.Month, Year: query.Year, MealPeriod: &storedPeriod}
+
+		menu, err := mgr.GetMenuByHallNameAndDate(query.HallName, date)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "menu not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		diff, err := mgr.GetMenuDiff(menu, query.Weeks)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only show dishes the user can eat
+		filter, _, err := ResolveDietaryFilter(c, mgr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		diff.New = filterDiffDishes(filter, diff.New)
+		diff.Returning = filterDiffDishes(filter, diff.Returning)
+		diff.Dropped = filterDiffDishes(filter, diff.Dropped)
+
+		c.JSON(http.StatusOK, gin.H{
+			"hall_name": query.HallName,
+			"diff":      diff,
+		})
+	}
+}
+
+// GetNewDishesHandler returns the dishes that were served for the first time
+// recently, for a "new on the menu" feed
+func GetNewDishesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var query NewDishesQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if query.Days <= 0 {
+			query.Days = 7
+		}
+		if query.Limit <= 0 || query.Limit > 200 {
+			query.Limit = 50
+		}
+
+		since := models.DateOf(time.Now().In(mgr.TZ).AddDate(0, 0, -query.Days))
+		dishes, err := mgr.GetNewDishes(since, query.HallName, query.Limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		filter, _, err := ResolveDietaryFilter(c, mgr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		dishes = filter.FilterDishes(dishes)
+
+		results := make([]map[string]interface{}, 0, len(dishes))
+		for _, dish := range dishes {
+			results = append(results, map[string]interface{}{
+				"id":              dish.ID,
+				"name":            dish.Name,
+				"hall_name":       dish.Hall.Name,
+				"location":        dish.Location,
+				"tags":            dish.Tags,
+				"allergens":       dish.Allergens(),
+				"first_seen_date": dish.FirstSeenDate.Format(time.DateOnly),
+				"last_seen_date":  dish.LastSeenDate,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"since":  since.String(),
+			"dishes": results,
+		})
+	}
+}
+
+func filterDiffDishes(filter models.DietaryFilter, dishes []models.DiffDish) []models.DiffDish {
+	if filter.IsEmpty() {
+		return dishes
+	}
+	filtered := []models.DiffDish{}
+	for _, dish := range dishes {
+		if filter.Allows(&dish.Dish) {
+			filtered = append(filtered, dish)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/notify"
+	"gorm.io/gorm"
+)
+
+// NotificationsQuery represents the query params for listing notifications
+type NotificationsQuery struct {
+	Unread bool `form:"unread"` // only unread notifications
+	Before uint `form:"before"` // only notifications older than this ID, for paging
+	Limit  int  `form:"limit"`  // default 50
+}
+
+// NotificationSettingsRequest represents the request body for updating notification settings
+type NotificationSettingsRequest struct {
+	Muted []string `json:"muted"` // notification types the user doesn't want
+}
+
+// Sends notifications without failing the request they came from
+func sendNotifications(notifier notify.Notifier, messages ...notify.Message) {
+	if notifier == nil || len(messages) == 0 {
+		return
+	}
+	if err := notifier.Notify(messages...); err != nil {
+		fmt.Printf("Warning: failed to send notifications: %v\n", err)
+	}
+}
+
+// GetNotificationsHandler returns the user's notifications, newest first
+func GetNotificationsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var query NotificationsQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if query.Limit <= 0 || query.Limit > 100 {
+			query.Limit = 50
+		}
+
+		notifications, err := mgr.GetNotifications(uint(userID), query.Unread, query.Before, query.Limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		unread, err := mgr.CountUnreadNotifications(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"notifications": notifications,
+			"unread_count":  unread,
+		})
+	}
+}
+
+// MarkNotificationReadHandler marks one notification as read
+func MarkNotificationReadHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+		notificationID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || notificationID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification ID"})
+			return
+		}
+
+		if err := mgr.MarkNotificationRead(uint(userID), uint(notificationID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+	}
+}
+
+// MarkAllNotificationsReadHandler marks all of the user's notifications as read
+func MarkAllNotificationsReadHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		count, err := mgr.MarkAllNotificationsRead(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Notifications marked as read",
+			"count":   count,
+		})
+	}
+}
+
+// GetNotificationSettingsHandler returns which notification types the user muted
+func GetNotificationSettingsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		muted, err := mgr.GetNotificationMutes(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"muted": muted,
+			"types": notify.Types,
+		})
+	}
+}
+
+// UpdateNotificationSettingsHandler replaces which notification types the user muted
+func UpdateNotificationSettingsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var req NotificationSettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		muted := cleanList(req.Muted)
+		for _, notificationType := range muted {
+			if !slices.Contains(notify.Types, notificationType) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown notification type: " + notificationType})
+				return
+			}
+		}
+
+		if err := mgr.SetNotificationMutes(uint(userID), muted); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update notification settings"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Notification settings updated successfully",
+			"muted":   muted,
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
+)
+
+// BlockRequest represents the request body for blocking a user
+type BlockRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+// Returns the logged in user's ID, or 0 on routes with OptionalAuthMiddleware
+// when no one is logged in
+func viewerID(c *gin.Context) uint {
+	userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+	if err != nil {
+		return 0
+	}
+	return uint(userID)
+}
+
+// userVisibility decides which users a viewer can find in user search and
+// whether their emails are shown
+type userVisibility struct {
+	viewerID uint
+	blocked  map[uint]bool
+	friends  map[uint]bool
+	settings map[uint]*models.PrivacySettings
+}
+
+// Loads what's needed to filter the users for the viewer
+func loadUserVisibility(mgr *db.DBManager, viewerID uint, users []models.User) (*userVisibility, error) {
+	v := &userVisibility{viewerID: viewerID, blocked: make(map[uint]bool), friends: make(map[uint]bool)}
+
+	blockedIDs, err := mgr.GetBlockedUserIDs(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range blockedIDs {
+		v.blocked[id] = true
+	}
+	friends, err := mgr.GetFriendsByUserID(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, friend := range friends {
+		v.friends[friend.ID] = true
+	}
+
+	userIDs := make([]uint, len(users))
+	for i, user := range users {
+		userIDs[i] = user.ID
+	}
+	v.settings, err = mgr.GetPrivacySettingsForUsers(userIDs)
+	return v, err
+}
+
+func (v *userVisibility) relationship(userID uint) models.Relationship {
+	return models.Relationship{Friends: v.friends[userID], Blocked: v.blocked[userID]}
+}
+
+// searchable returns true if the user can show up in the viewer's search results
+func (v *userVisibility) searchable(userID uint) bool {
+	return v.relationship(userID).CanSee(v.settings[userID].ProfileVisibility)
+}
+
+// ratingsVisible returns true if the user's ratings visibility lets the viewer see their ratings
+func (v *userVisibility) ratingsVisible(userID uint) bool {
+	relationship := v.relationship(userID)
+	relationship.Self = v.viewerID != 0 && userID == v.viewerID
+	return relationship.CanSee(v.settings[userID].RatingsVisibility)
+}
+
+// showEmail returns true if the viewer can see the user's email, which is
+// only themselves and friends. Being searchable by email only lets others
+// find the user when they already know it.
+func (v *userVisibility) showEmail(userID uint) bool {
+	return v.friends[userID] || (v.viewerID != 0 && userID == v.viewerID)
+}
+
+// Looks up the user in the path and how the viewer is related to them. Users
+// who blocked the viewer (or were blocked by them) are reported as not found.
+// Returns false after responding if the profile can't be shown.
+func loadProfile(c *gin.Context, mgr *db.DBManager) (*models.User, models.Relationship, *models.PrivacySettings, bool) {
+	username := c.Param("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		return nil, models.Relationship{}, nil, false
+	}
+
+	user, err := mgr.GetUserByUsername(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, models.Relationship{}, nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, models.Relationship{}, nil, false
+	}
+
+	relationship, err := mgr.GetRelationship(viewerID(c), user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, models.Relationship{}, nil, false
+	}
+	if relationship.Blocked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return nil, models.Relationship{}, nil, false
+	}
+
+	settings, err := mgr.GetPrivacySettings(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, models.Relationship{}, nil, false
+	}
+	return user, relationship, settings, true
+}
+
+// RemoveFriendHandler ends a friendship with the user in the path
+func RemoveFriendHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+		friendID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || friendID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid friend ID"})
+			return
+		}
+
+		if err := mgr.RemoveFriend(uint(userID), uint(friendID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not friends with this user"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to remove friend"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Friend removed"})
+	}
+}
+
+// GetBlocksHandler returns the users the current user blocked
+func GetBlocksHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		blocks, err := mgr.GetBlocks(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"blocks": blocks})
+	}
+}
+
+// BlockUserHandler blocks a user, which also unfriends them
+func BlockUserHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request BlockRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userID, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		block, err := mgr.BlockUser(uint(userID), request.UserID)
+		if err != nil {
+			if errors.Is(err, db.ErrBlockSelf) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to block user"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User blocked", "block": block})
+	}
+}
+
+// UnblockUserHandler removes a block on the user in the path
+func UnblockUserHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+		blockedID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || blockedID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		if err := mgr.UnblockUser(uint(userID), uint(blockedID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user is not blocked"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "unable to unblock user"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "User unblocked"})
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/gsonntag/bruinbite/models"
+)
+
+func TestUserVisibilityShowEmail(t *testing.T) {
+	searchable := models.DefaultPrivacySettings(3)
+	searchable.SearchableByEmail = true
+	v := &userVisibility{
+		viewerID: 1,
+		blocked:  map[uint]bool{},
+		friends:  map[uint]bool{2: true},
+		settings: map[uint]*models.PrivacySettings{
+			1: models.DefaultPrivacySettings(1),
+			2: models.DefaultPrivacySettings(2),
+			3: searchable,
+		},
+	}
+
+	tests := []struct {
+		name   string
+		userID uint
+		want   bool
+	}{
+		{"self", 1, true},
+		{"friend", 2, true},
+		{"stranger searchable by email", 3, false},
+	}
+	for _, tt := range tests {
+		if got := v.showEmail(tt.userID); got != tt.want {
+			t.Errorf("showEmail(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
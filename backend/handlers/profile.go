@@ -8,12 +8,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
 	"github.com/gsonntag/bruinbite/search"
 )
 
@@ -166,6 +168,212 @@ func UpdateProfileHandler(mgr *db.DBManager, userSearchManager *search.BleveUser
 	}
 }
 
+// UpdatePreferencesRequest represents the request body for updating dietary preferences
+type UpdatePreferencesRequest struct {
+	DietType            string   `json:"diet_type"`
+	AvoidAllergens      []string `json:"avoid_allergens"`
+	DislikedIngredients []string `json:"disliked_ingredients"`
+	FavoriteHalls       []string `json:"favorite_halls"`
+}
+
+// GetPreferencesHandler returns the current user's dietary preferences
+func GetPreferencesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		prefs, err := mgr.GetUserPreferences(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get preferences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"preferences": prefs})
+	}
+}
+
+// UpdatePreferencesHandler replaces the current user's dietary preferences
+func UpdatePreferencesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var req UpdatePreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		dietType := strings.ToLower(strings.TrimSpace(req.DietType))
+		if dietType != models.DietNone && models.DietTags(dietType) == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid diet_type, expected vegetarian, vegan or halal"})
+			return
+		}
+
+		allergens := cleanList(req.AvoidAllergens)
+		for _, allergen := range allergens {
+			if !slices.Contains(models.KnownAllergens, allergen) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown allergen: " + allergen})
+				return
+			}
+		}
+
+		prefs := models.UserPreferences{
+			UserID:              uint(userID),
+			DietType:            dietType,
+			AvoidAllergens:      allergens,
+			DislikedIngredients: cleanList(req.DislikedIngredients),
+			FavoriteHalls:       cleanList(req.FavoriteHalls),
+		}
+		if err := mgr.SaveUserPreferences(&prefs); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update preferences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Preferences updated successfully",
+			"preferences": prefs,
+		})
+	}
+}
+
+// UpdateEmailPreferencesRequest represents the request body for choosing which emails to get
+type UpdateEmailPreferencesRequest struct {
+	Digest        bool `json:"digest"`
+	Notifications bool `json:"notifications"`
+}
+
+// GetEmailPreferencesHandler returns which emails the current user gets
+func GetEmailPreferencesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		prefs, err := mgr.GetEmailPreferences(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get email preferences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"email_preferences": prefs})
+	}
+}
+
+// UpdateEmailPreferencesHandler opts the current user in or out of the digest and notification emails
+func UpdateEmailPreferencesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var req UpdateEmailPreferencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		prefs, err := mgr.UpdateEmailPreferences(uint(userID), req.Digest, req.Notifications)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update email preferences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":           "Email preferences updated successfully",
+			"email_preferences": prefs,
+		})
+	}
+}
+
+// UpdatePrivacySettingsRequest represents the request body for changing who can see the user
+type UpdatePrivacySettingsRequest struct {
+	ProfileVisibility string `json:"profile_visibility" binding:"required"` // public, friends or private
+	RatingsVisibility string `json:"ratings_visibility" binding:"required"` // public, friends or private
+	SearchableByEmail bool   `json:"searchable_by_email"`
+}
+
+// GetPrivacySettingsHandler returns who can see the current user's profile and ratings
+func GetPrivacySettingsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		settings, err := mgr.GetPrivacySettings(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get privacy settings"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"privacy": settings})
+	}
+}
+
+// UpdatePrivacySettingsHandler replaces the current user's privacy settings
+func UpdatePrivacySettingsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var req UpdatePrivacySettingsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		profileVisibility := strings.ToLower(strings.TrimSpace(req.ProfileVisibility))
+		ratingsVisibility := strings.ToLower(strings.TrimSpace(req.RatingsVisibility))
+		if !models.ValidVisibility(profileVisibility) || !models.ValidVisibility(ratingsVisibility) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid visibility, expected public, friends or private"})
+			return
+		}
+
+		settings := models.PrivacySettings{
+			UserID:            uint(userID),
+			ProfileVisibility: profileVisibility,
+			RatingsVisibility: ratingsVisibility,
+			SearchableByEmail: req.SearchableByEmail,
+		}
+		if err := mgr.SavePrivacySettings(&settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update privacy settings"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Privacy settings updated successfully",
+			"privacy": settings,
+		})
+	}
+}
+
+// Lowercases and trims every entry, dropping empty ones and duplicates
+func cleanList(list []string) []string {
+	cleaned := []string{}
+	for _, item := range list {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" && !slices.Contains(cleaned, item) {
+			cleaned = append(cleaned, item)
+		}
+	}
+	return cleaned
+}
+
 // UploadProfilePictureHandler handles multipart file uploads for profile pictures
 func UploadProfilePictureHandler(mgr *db.DBManager, userSearchManager *search.BleveUserSearchManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
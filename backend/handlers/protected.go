@@ -1,18 +1,23 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware checks the access token and that its session hasn't been
+// logged out, setting userId and sessionId for the handlers after it
+func AuthMiddleware(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		auth := c.GetHeader("Authorization")
 		if auth == "" {
@@ -28,16 +33,11 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := parts[1]
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
-			secret = "F4LLB4CK" // using fallback secret
-		}
-
 		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
 			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 			}
-			return []byte(secret), nil
+			return []byte(jwtSecret()), nil
 		})
 		if err != nil || !token.Valid {
 			log.Println(err)
@@ -60,7 +60,102 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// Tokens from before sessions existed can't be revoked, so they aren't accepted
+		sid, ok := claims["sid"].(float64)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session expired, please log in again"})
+			c.Abort()
+			return
+		}
+		active, err := mgr.IsSessionActive(uint(sid))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "session revoked"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userId", sub)
+		c.Set("sessionId", uint(sid))
+		c.Next()
+	}
+}
+
+// OptionalAuthMiddleware is AuthMiddleware for public routes that give
+// logged in users a personalized response. Requests without an
+// Authorization header go through without a userId; a bad token is still
+// rejected so the client finds out it's been logged out.
+func OptionalAuthMiddleware(mgr *db.DBManager) gin.HandlerFunc {
+	auth := AuthMiddleware(mgr)
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		auth(c)
+	}
+}
+
+// RequireVerifiedEmailMiddleware stops users who haven't verified their email
+// from using a route, if required is set. It goes after AuthMiddleware.
+func RequireVerifiedEmailMiddleware(mgr *db.DBManager, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !required {
+			c.Next()
+			return
+		}
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			c.Abort()
+			return
+		}
+		user, err := mgr.GetUserByID(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			c.Abort()
+			return
+		}
+		if !user.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "email not verified"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRoleMiddleware only lets users with at least the role use a route.
+// The role is read from the database on every request, not from the token,
+// so revoking a role takes effect right away. It goes after AuthMiddleware.
+func RequireRoleMiddleware(mgr *db.DBManager, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			c.Abort()
+			return
+		}
+		userRole, err := mgr.GetUserRole(uint(userID))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			}
+			c.Abort()
+			return
+		}
+		if !models.HasRole(userRole, role) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "requires " + role + " role"})
+			c.Abort()
+			return
+		}
+		c.Set("role", userRole)
 		c.Next()
 	}
 }
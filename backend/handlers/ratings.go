@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gsonntag/bruinbite/db"
 	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+	"gorm.io/gorm"
 )
 
 type RatingsRequest struct {
@@ -15,7 +20,7 @@ type RatingsRequest struct {
 	Comment *string `json:"comment"`
 }
 
-func SubmitRatingHandler(mgr *db.DBManager) gin.HandlerFunc {
+func SubmitRatingHandler(mgr *db.DBManager, notifier notify.Notifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var request RatingsRequest
 		if err := c.ShouldBindJSON(&request); err != nil {
@@ -40,6 +45,7 @@ func SubmitRatingHandler(mgr *db.DBManager) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		notifyFriendsWhoRated(mgr, notifier, &rating)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Rating submitted successfully"})
 	}
@@ -64,15 +70,23 @@ func GetUserRatingsHandler(mgr *db.DBManager) gin.HandlerFunc {
 	}
 }
 
+// GetUserRatingsFromUsernameHandler returns a user's ratings, if their privacy settings let the viewer see them
 func GetUserRatingsFromUsernameHandler(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		username := c.Param("username")
-		if username == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
+		user, relationship, settings, ok := loadProfile(c, mgr)
+		if !ok {
+			return
+		}
+		if !relationship.CanSee(settings.RatingsVisibility) {
+			if settings.RatingsVisibility == models.VisibilityFriends {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only friends can see this user's ratings"})
+			} else {
+				c.JSON(http.StatusForbidden, gin.H{"error": "this user's ratings are private"})
+			}
 			return
 		}
 
-		ratings, err := mgr.GetAllRatingsByUserIDOrUsername(0, username)
+		ratings, err := mgr.GetAllRatingsByUserIDOrUsername(user.ID, "")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -96,12 +110,15 @@ func GetFriendRatingsHandler(mgr *db.DBManager) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		for i := range friendRatings {
+			friendRatings[i].User.Email = ""
+		}
 
 		c.JSON(http.StatusOK, friendRatings)
 	}
 }
 
-// GetDishRatingsHandler retrieves all ratings made for a dish
+// GetDishRatingsHandler retrieves the ratings made for a dish that the viewer can see
 func GetDishRatingsHandler(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		dishIDStr := c.Query("dish_id")
@@ -116,7 +133,27 @@ func GetDishRatingsHandler(mgr *db.DBManager) gin.HandlerFunc {
 			return
 		}
 
-		ratings, err := mgr.GetAllRatingsByDishID(uint(dishID))
+		// scope=recipe returns the ratings of the same recipe at every hall
+		var ratings []models.Rating
+		if c.Query("scope") == "recipe" {
+			dish, err := mgr.GetDishByID(uint(dishID))
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "dish not found"})
+				return
+			}
+			if dish.RecipeID != nil {
+				ratings, err = mgr.GetAllRatingsByRecipeID(*dish.RecipeID)
+			} else {
+				ratings, err = mgr.GetAllRatingsByDishID(uint(dishID))
+			}
+		} else {
+			ratings, err = mgr.GetAllRatingsByDishID(uint(dishID))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ratings, err = visibleRatings(mgr, viewerID(c), ratings)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -125,3 +162,169 @@ func GetDishRatingsHandler(mgr *db.DBManager) gin.HandlerFunc {
 		c.JSON(http.StatusOK, ratings)
 	}
 }
+
+// Leaves out the ratings whose raters blocked the viewer (or were blocked by
+// them) or hide their ratings from the viewer, and the raters' emails
+func visibleRatings(mgr *db.DBManager, viewerID uint, ratings []models.Rating) ([]models.Rating, error) {
+	raters := make([]models.User, len(ratings))
+	for i, rating := range ratings {
+		raters[i].ID = rating.UserID
+	}
+	visibility, err := loadUserVisibility(mgr, viewerID, raters)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]models.Rating, 0, len(ratings))
+	for _, rating := range ratings {
+		if visibility.ratingsVisible(rating.UserID) {
+			rating.User.Email = ""
+			visible = append(visible, rating)
+		}
+	}
+	return visible, nil
+}
+
+// Tells the rater's friends who rated the same dish about the new rating
+func notifyFriendsWhoRated(mgr *db.DBManager, notifier notify.Notifier, rating *models.Rating) {
+	if mgr.RatingsHiddenFromFriends(rating.UserID) {
+		return
+	}
+	friendIDs, err := mgr.GetFriendIDsWhoRatedDish(rating.UserID, rating.DishID)
+	if err != nil || len(friendIDs) == 0 {
+		return
+	}
+	rated, err := mgr.GetRatingByID(rating.ID)
+	if err != nil {
+		return
+	}
+
+	messages := make([]notify.Message, len(friendIDs))
+	for i, friendID := range friendIDs {
+		messages[i] = notify.Message{
+			UserID: friendID,
+			Type:   notify.TypeFriendRated,
+			Title:  fmt.Sprintf("%s rated %s %d/5", rated.User.Username, rated.Dish.Name, rated.Score),
+			Data:   map[string]interface{}{"rating_id": rated.ID, "dish_id": rated.DishID, "user_id": rated.UserID},
+		}
+		if rated.Comment != nil {
+			messages[i].Body = *rated.Comment
+		}
+	}
+	sendNotifications(notifier, messages...)
+}
+
+// RatingReplyRequest represents the request body for replying to a rating
+type RatingReplyRequest struct {
+	Comment string `json:"comment" binding:"required"`
+}
+
+// GetRatingRepliesHandler returns the replies to a rating the viewer can see
+func GetRatingRepliesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ratingID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || ratingID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rating ID"})
+			return
+		}
+
+		rating, ok := loadVisibleRating(c, mgr, uint(ratingID))
+		if !ok {
+			return
+		}
+		replies, err := mgr.GetRatingReplies(rating.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Replies from users blocked either way are left out
+		blockedIDs, err := mgr.GetBlockedUserIDs(viewerID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		visible := make([]models.RatingReply, 0, len(replies))
+		for _, reply := range replies {
+			if !slices.Contains(blockedIDs, reply.UserID) {
+				reply.User.Email = ""
+				visible = append(visible, reply)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"replies": visible})
+	}
+}
+
+// Looks up a rating the viewer is allowed to see. Ratings by users who blocked
+// the viewer (or were blocked by them) or who hide their ratings from the
+// viewer are reported as not found. Returns false after responding otherwise.
+func loadVisibleRating(c *gin.Context, mgr *db.DBManager, ratingID uint) (*models.Rating, bool) {
+	rating, err := mgr.GetRatingByID(ratingID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rating not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	visible, err := mgr.CanSeeRatings(viewerID(c), rating.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rating not found"})
+		return nil, false
+	}
+	return rating, true
+}
+
+// ReplyToRatingHandler adds a reply to a rating and lets the rater know
+func ReplyToRatingHandler(mgr *db.DBManager, notifier notify.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ratingID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || ratingID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rating ID"})
+			return
+		}
+
+		var request RatingReplyRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		userId, err := strconv.Atoi(c.GetString("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		rating, ok := loadVisibleRating(c, mgr, uint(ratingID))
+		if !ok {
+			return
+		}
+
+		reply := models.RatingReply{RatingID: rating.ID, UserID: uint(userId), Comment: request.Comment}
+		if err := mgr.CreateRatingReply(&reply); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if rating.UserID != reply.UserID {
+			if replier, err := mgr.GetUserByID(reply.UserID); err == nil {
+				sendNotifications(notifier, notify.Message{
+					UserID: rating.UserID,
+					Type:   notify.TypeCommentReply,
+					Title:  fmt.Sprintf("%s replied to your rating of %s", replier.Username, rating.Dish.Name),
+					Body:   reply.Comment,
+					Data:   map[string]interface{}{"rating_id": rating.ID, "reply_id": reply.ID, "dish_id": rating.DishID},
+				})
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Reply submitted successfully", "reply": reply})
+	}
+}
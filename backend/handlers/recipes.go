@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/search"
+	"gorm.io/gorm"
+)
+
+// MergeDishesRequest represents the request body for merging two dishes of the same hall
+type MergeDishesRequest struct {
+	SourceDishID uint `json:"source_dish_id" binding:"required"` // merged away and deleted
+	TargetDishID uint `json:"target_dish_id" binding:"required"` // kept
+}
+
+// SplitDishRequest represents the request body for splitting a dish in two
+type SplitDishRequest struct {
+	Name           string `json:"name" binding:"required"` // name of the new dish
+	MenuIDs        []uint `json:"menu_ids"`                // menu appearances that move to the new dish
+	RatingIDs      []uint `json:"rating_ids"`              // ratings that move to the new dish
+	SeparateRecipe bool   `json:"separate_recipe"`         // give the new dish its own recipe
+}
+
+// MergeRecipesRequest represents the request body for merging two recipes
+type MergeRecipesRequest struct {
+	SourceRecipeID uint `json:"source_recipe_id" binding:"required"`
+	TargetRecipeID uint `json:"target_recipe_id" binding:"required"`
+}
+
+// GetRecipeHandler returns a recipe with its aggregated rating and the dishes serving it at each hall
+func GetRecipeHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recipeID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || recipeID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid recipe id"})
+			return
+		}
+
+		recipe, err := mgr.GetRecipeByID(uint(recipeID))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recipe not found"})
+			return
+		}
+
+		dishes := make([]map[string]interface{}, 0, len(recipe.Dishes))
+		for _, dish := range recipe.Dishes {
+			dishes = append(dishes, map[string]interface{}{
+				"id":             dish.ID,
+				"name":           dish.Name,
+				"hall_name":      dish.Hall.Name,
+				"location":       dish.Location,
+				"average_rating": dish.AverageRating,
+				"last_seen_date": dish.LastSeenDate,
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recipe": map[string]interface{}{
+			"id":             recipe.ID,
+			"name":           recipe.Name,
+			"average_rating": recipe.AverageRating,
+			"rating_count":   recipe.RatingCount,
+			"dishes":         dishes,
+		}})
+	}
+}
+
+// MergeDishesHandler merges a duplicate dish into another dish of the same hall
+func MergeDishesHandler(mgr *db.DBManager, indexer *search.Indexer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MergeDishesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := mgr.MergeDishes(req.SourceDishID, req.TargetDishID); err != nil {
+			respondMergeError(c, err, "dish not found")
+			return
+		}
+
+		dish, err := mgr.GetDishByID(req.TargetDishID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := indexer.DeleteDishFromIndex(req.SourceDishID); err != nil {
+			fmt.Printf("Warning: failed to remove merged dish %d from search index: %v\n", req.SourceDishID, err)
+		}
+		reindexDish(indexer, dish)
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Dishes merged successfully",
+			"dish":    dish,
+		})
+	}
+}
+
+// SplitDishHandler moves some of a dish's menu appearances and ratings to a new dish
+func SplitDishHandler(mgr *db.DBManager, indexer *search.Indexer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dishID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || dishID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dish_id"})
+			return
+		}
+
+		var req SplitDishRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		dish, err := mgr.SplitDish(uint(dishID), req.Name, req.MenuIDs, req.RatingIDs, req.SeparateRecipe)
+		if err != nil {
+			respondMergeError(c, err, "dish not found")
+			return
+		}
+		reindexDish(indexer, dish)
+		if original, err := mgr.GetDishByID(uint(dishID)); err == nil {
+			reindexDish(indexer, original)
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"message": "Dish split successfully",
+			"dish":    dish,
+		})
+	}
+}
+
+// MergeRecipesHandler merges two recipes, e.g. the same food listed under different names at two halls
+func MergeRecipesHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MergeRecipesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := mgr.MergeRecipes(req.SourceRecipeID, req.TargetRecipeID); err != nil {
+			respondMergeError(c, err, "recipe not found")
+			return
+		}
+
+		recipe, err := mgr.GetRecipeByID(req.TargetRecipeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Recipes merged successfully",
+			"recipe":  recipe,
+		})
+	}
+}
+
+// Updates a dish changed by a merge or split in the search index. The
+// database is already updated, so a failure is only logged.
+func reindexDish(indexer *search.Indexer, dish *models.Dish) {
+	if err := indexer.UpdateDishIndex(*dish); err != nil {
+		fmt.Printf("Warning: failed to update dish %d in search index: %v\n", dish.ID, err)
+	}
+}
+
+// Maps merge/split errors to status codes
+func respondMergeError(c *gin.Context, err error, notFoundMessage string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFoundMessage})
+	case errors.Is(err, db.ErrSameDish), errors.Is(err, db.ErrSameRecipe), errors.Is(err, db.ErrDishesInDifferentHalls):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrDishNameTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
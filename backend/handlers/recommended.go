@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"sort"
 	"strconv"
@@ -31,10 +32,19 @@ type RecommendedHallQuery struct {
 // meal period, it is weighted as follows: 2/3 user's
 // opinion, 1/3 consensus opinion.
 //
+// Only halls that are open right now according to their
+// hours are considered.
+//
 // Returns the top 3 halls the user should try for this
 // meal period with their corresponding 1-10 rankings on
 // if the user is projected to like the hall, as well as
 // each hall's top 3 rated dishes.
+//
+// Optional include_tags/exclude_allergens query params
+// and the user's saved dietary preferences limit the
+// dishes considered to ones the user can eat, so halls
+// are only scored on those dishes. Ties go to the
+// user's favorite halls.
 func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userId, err := strconv.Atoi(c.GetString("userId"))
@@ -44,24 +54,48 @@ func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 			return
 		}
 
+		// Find what each hall is serving right now based on its hours
 		now := time.Now().In(mgr.TZ)
-		periods := GetAllowedMealPeriods(now)
-
-		// Fetch all menus for that meal period
-		var menus []models.Menu
-		if err := mgr.DB.Preload("Dishes").Where(
-			"date_day=? AND date_month=? AND date_year=? AND date_meal_period IN ?",
-			now.Day(), int(now.Month()), now.Year(), periods,
-		).Find(&menus).Error; err != nil && err != gorm.ErrRecordNotFound {
+		openPeriods, err := mgr.GetOpenHallPeriods(now)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
+		// Fetch the menus for those meal periods
+		var menus []models.Menu
+		for _, open := range openPeriods {
+			date, err := models.ParseDate(open.Date)
+			if err != nil {
+				continue // should never happen
+			}
+			date.MealPeriod = &open.MealPeriod
+			menu, err := mgr.GetMenuByHallIDAndDate(open.HallID, date)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue // open, but the menu hasn't been loaded
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			menus = append(menus, *menu)
+		}
+
 		if len(menus) == 0 {
 			c.JSON(http.StatusOK, gin.H{"message": "No halls are serving meals at this time."})
 			return
 		}
 
+		// Only consider dishes that pass the user's dietary filters
+		filter, prefs, err := ResolveDietaryFilter(c, mgr)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range menus {
+			menus[i].Dishes = filter.FilterDishes(menus[i].Dishes)
+		}
+
 		dishIDs := make([]uint, 0, 64)
 		for _, m := range menus {
 			for _, d := range m.Dishes {
@@ -70,6 +104,10 @@ func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 		}
 
 		userRatingMap := make(map[uint]float64, len(dishIDs))
+		if len(dishIDs) == 0 && !filter.IsEmpty() {
+			c.JSON(http.StatusOK, gin.H{"message": "No dishes matching your dietary filters are being served at this time."})
+			return
+		}
 		if len(dishIDs) == 0 {
 			c.JSON(http.StatusOK, gin.H{"message": "No halls are serving meals at this time."})
 			return
@@ -85,6 +123,7 @@ func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 			Score     float64           `json:"score"`
 			Basis     string            `json:"basis"`
 			TopDishes []models.Dish     `json:"top_dishes"`
+			Favorite  bool              `json:"favorite"`
 		}
 
 		var results []hallResult
@@ -145,6 +184,7 @@ func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 				Score:     finalScore,
 				Basis:     basis,
 				TopDishes: topDishes,
+				Favorite:  prefs != nil && prefs.IsFavoriteHall(hall.Name),
 			})
 		}
 
@@ -155,6 +195,9 @@ func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 
 		sort.Slice(results, func(i, j int) bool {
 			if results[i].Score == results[j].Score {
+				if results[i].Favorite != results[j].Favorite {
+					return results[i].Favorite
+				}
 				return results[i].Hall.ID < results[j].Hall.ID
 			}
 			return results[i].Score > results[j].Score
@@ -168,40 +211,3 @@ func GetRecommendedHallForUser(mgr *db.DBManager) gin.HandlerFunc {
 		})
 	}
 }
-
-func GetMealPeriodForHall(hallName, mealPeriod string) string {
-	if hallName == "bruin-cafe" {
-		return "ALL_DAY"
-	}
-	if hallName == "epicuria-at-ackerman" || hallName == "rendezvous" || hallName == "the-drey" {
-		return "LUNCH_DINNER"
-	}
-	return mealPeriod
-}
-
-func GetAllowedMealPeriods(now time.Time) []string {
-	mealPeriod := GetActualMealPeriod(now.Hour())
-	var results []string
-	results = append(results, mealPeriod)
-	if mealPeriod != "NONE" && mealPeriod != "LATE_NIGHT" {
-		results = append(results, "ALL_DAY")
-	}
-	if mealPeriod == "LUNCH" || mealPeriod == "DINNER" {
-		results = append(results, "LUNCH_DINNER")
-	}
-	return results
-}
-
-func GetActualMealPeriod(hour int) string {
-	// Source: https://dining.ucla.edu/hours/
-	if hour > 7 && hour < 10 {
-		return "BREAKFAST"
-	} else if hour > 11 && hour < 15 {
-		return "LUNCH"
-	} else if hour > 17 && hour < 21 {
-		return "DINNER"
-	} else if hour < 2 || hour > 21 {
-		return "LATE_NIGHT"
-	}
-	return "NONE"
-}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
+)
+
+// SetRoleRequest represents the request body for changing a user's role
+type SetRoleRequest struct {
+	Role string `json:"role" binding:"required"` // user, moderator or admin
+}
+
+// SetUserRoleHandler grants or revokes a role, e.g. {"role": "moderator"}
+// to grant it or {"role": "user"} to take it away
+func SetUserRoleHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || userID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var request SetRoleRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !models.ValidRole(request.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "unknown role: " + request.Role, "roles": models.Roles})
+			return
+		}
+
+		user, err := mgr.SetUserRole(uint(userID), request.Role)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+				return
+			}
+			if errors.Is(err, db.ErrLastAdmin) {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Role updated",
+			"user_id": user.ID,
+			"role":    user.Role,
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"gorm.io/gorm"
+)
+
+// RefreshRequest represents the request body for getting a new access token
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// SessionResponse is a session as listed on /sessions
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"` // the session the request was made with
+}
+
+// RefreshHandler swaps a refresh token for a new access token and refresh
+// token. The old refresh token stops working, and using it again logs the
+// session out in case it was stolen.
+func RefreshHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request RefreshRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		session, refreshToken, err := mgr.RefreshSession(request.RefreshToken, RefreshTokenTTL)
+		if err != nil {
+			if errors.Is(err, db.ErrInvalidToken) || errors.Is(err, db.ErrSessionRevoked) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "server error"})
+			return
+		}
+
+		user, err := mgr.GetUserByID(session.UserID)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		accessToken, err := newAccessToken(user, session.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, tokenResponse(accessToken, refreshToken))
+	}
+}
+
+// GetSessionsHandler lists the devices the user is logged in on
+func GetSessionsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		sessions, err := mgr.GetActiveSessions(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		current := c.GetUint("sessionId")
+		response := make([]SessionResponse, len(sessions))
+		for i, session := range sessions {
+			response[i] = SessionResponse{Session: session, Current: session.ID == current}
+		}
+		c.JSON(http.StatusOK, gin.H{"sessions": response})
+	}
+}
+
+// RevokeSessionHandler logs out one of the user's devices
+func RevokeSessionHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+		sessionID, err := strconv.Atoi(c.Param("id"))
+		if err != nil || sessionID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid session ID"})
+			return
+		}
+
+		if err := mgr.RevokeSession(uint(userID), uint(sessionID)); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+	}
+}
@@ -1,15 +1,13 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"regexp"
-	"os"
-	"strconv"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/email"
 	"github.com/gsonntag/bruinbite/models"
 	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
@@ -25,7 +23,7 @@ var usernameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{3,16}$`)
 var emailRegex = regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}$`) // standard email regex
 var passwordRegex = regexp.MustCompile(`^.{8,}$`)                                         // 8+ chars
 
-func SignupHandler(mgr *db.DBManager) gin.HandlerFunc {
+func SignupHandler(mgr *db.DBManager, mailer *email.Mailer) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		var request SignupRequest
 		if err := ctx.ShouldBindJSON(&request); err != nil {
@@ -58,6 +56,7 @@ func SignupHandler(mgr *db.DBManager) gin.HandlerFunc {
 			HashedPassword: string(hashedPassword),
 			Email:          request.Email,
 			IsAdmin:        false,
+			Role:           models.RoleUser,
 			Ratings:        []models.Rating{},
 		}
 
@@ -70,27 +69,19 @@ func SignupHandler(mgr *db.DBManager) gin.HandlerFunc {
 			return
 		}
 
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			// secret not set
-			jwtSecret = "F4LLB4CK" // just for dev
+		// The account works without verifying, so a failed email doesn't fail the signup
+		if err := sendVerificationEmail(mgr, mailer, user); err != nil {
+			fmt.Printf("Warning: failed to send verification email: %v\n", err)
 		}
 
-		claims := jwt.MapClaims{
-			"sub":      strconv.Itoa(int(user.ID)),
-			"username": user.Username,
-			"exp":      time.Now().Add(24 * time.Hour).Unix(),
-			"iat":      time.Now().Unix(),
-		}
-
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		tokenString, err := token.SignedString([]byte(jwtSecret))
+		response, err := startSession(ctx, mgr, user)
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
 			return
 		}
 
 		user.HashedPassword = ""
-		ctx.JSON(http.StatusOK, gin.H{"token": tokenString, "user": user})
+		response["user"] = user
+		ctx.JSON(http.StatusOK, response)
 	}
 }
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// Users need this many dishes rated in common before their taste is compared
+const suggestionMinSharedDishes = 3
+
+// Taste matches below this similarity aren't worth suggesting
+const suggestionMinSimilarity = 0.75
+
+// FriendSuggestionsQuery represents the query params for friend suggestions
+type FriendSuggestionsQuery struct {
+	Limit int `form:"limit"` // default 10
+}
+
+// FriendSuggestion is a user the current user might want to add, with why
+type FriendSuggestion struct {
+	User          models.User `json:"user"`
+	MutualFriends int         `json:"mutual_friends"`
+	SharedDishes  int         `json:"shared_dishes"` // dishes both users rated
+	Similarity    float64     `json:"similarity"`    // 0 to 1, how closely their ratings agree
+	Score         float64     `json:"score"`
+	Reasons       []string    `json:"reasons"` // e.g. "4 mutual friends", "you both love Bruin Plate Salmon"
+}
+
+// GetFriendSuggestionsHandler suggests users the viewer might know, ranked by
+// mutual friends and how similarly they rated the same dishes
+func GetFriendSuggestionsHandler(mgr *db.DBManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.ParseUint(c.GetString("userId"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+
+		var query FriendSuggestionsQuery
+		if err := c.ShouldBindQuery(&query); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if query.Limit <= 0 || query.Limit > 50 {
+			query.Limit = 10
+		}
+
+		mutuals, err := mgr.GetMutualFriendCounts(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		matches, err := mgr.GetTasteMatches(uint(userID), suggestionMinSharedDishes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Users that can't be suggested
+		excluded := map[uint]bool{uint(userID): true}
+		blockedIDs, err := mgr.GetBlockedUserIDs(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		pendingIDs, err := mgr.GetPendingFriendRequestUserIDs(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		friends, err := mgr.GetFriendsByUserID(uint(userID))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, id := range append(blockedIDs, pendingIDs...) {
+			excluded[id] = true
+		}
+		for _, friend := range friends {
+			excluded[friend.ID] = true
+		}
+
+		// Score everyone with mutual friends or a close enough taste match
+		scored := make(map[uint]*FriendSuggestion)
+		for candidateID, mutual := range mutuals {
+			if !excluded[candidateID] {
+				scored[candidateID] = &FriendSuggestion{MutualFriends: mutual, Score: float64(mutual)}
+			}
+		}
+		for candidateID, match := range matches {
+			if excluded[candidateID] || match.Similarity() < suggestionMinSimilarity {
+				continue
+			}
+			suggestion, ok := scored[candidateID]
+			if !ok {
+				suggestion = &FriendSuggestion{}
+				scored[candidateID] = suggestion
+			}
+			confidence := min(float64(match.SharedDishes)/5, 1)
+			suggestion.SharedDishes = match.SharedDishes
+			suggestion.Similarity = match.Similarity()
+			suggestion.Score += 3 * match.Similarity() * confidence
+		}
+
+		candidateIDs := make([]uint, 0, len(scored))
+		for candidateID := range scored {
+			candidateIDs = append(candidateIDs, candidateID)
+		}
+		users, err := mgr.GetUsersByIDs(candidateIDs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// Like user search, only users whose profiles the viewer can see are suggested
+		visibility, err := loadUserVisibility(mgr, uint(userID), users)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		suggestions := []FriendSuggestion{}
+		for _, user := range users {
+			if !visibility.searchable(user.ID) {
+				continue
+			}
+			suggestion := scored[user.ID]
+			user.Email = "" // never a friend, so never shown
+			suggestion.User = user
+			suggestions = append(suggestions, *suggestion)
+		}
+		sort.SliceStable(suggestions, func(i, j int) bool {
+			if suggestions[i].Score != suggestions[j].Score {
+				return suggestions[i].Score > suggestions[j].Score
+			}
+			return suggestions[i].User.ID < suggestions[j].User.ID
+		})
+		suggestions = suggestions[:min(len(suggestions), query.Limit)]
+
+		// Only explain the suggestions that are returned
+		for i := range suggestions {
+			suggestions[i].Reasons = suggestionReasons(mgr, &suggestions[i], matches[suggestions[i].User.ID])
+		}
+
+		c.JSON(http.StatusOK, gin.H{"suggestions": suggestions})
+	}
+}
+
+// e.g. ["4 mutual friends", "you both love Bruin Plate Salmon"]
+func suggestionReasons(mgr *db.DBManager, suggestion *FriendSuggestion, match *models.TasteMatch) []string {
+	var reasons []string
+	switch {
+	case suggestion.MutualFriends == 1:
+		reasons = append(reasons, "1 mutual friend")
+	case suggestion.MutualFriends > 1:
+		reasons = append(reasons, fmt.Sprintf("%d mutual friends", suggestion.MutualFriends))
+	}
+
+	if suggestion.Similarity == 0 {
+		return reasons
+	}
+	if match.LovedDishID != nil {
+		if dish, err := mgr.GetDishByID(*match.LovedDishID); err == nil {
+			hallName := dish.Hall.DisplayName
+			if hallName == "" {
+				hallName = models.DisplayNameFromSlug(dish.Hall.Name)
+			}
+			return append(reasons, fmt.Sprintf("you both love %s %s", hallName, dish.Name))
+		}
+	}
+	return append(reasons, fmt.Sprintf("you rated %d of the same dishes similarly", suggestion.SharedDishes))
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/db/dbtest"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+func createTestUser(t *testing.T, mgr *db.DBManager, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", HashedPassword: "x"}
+	if err := mgr.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestGetFriendSuggestionsProfileVisibility(t *testing.T) {
+	mgr := dbtest.New(t)
+	viewer := createTestUser(t, mgr, "viewer")
+	friend := createTestUser(t, mgr, "friend")
+	public := createTestUser(t, mgr, "public")
+	friendsOnly := createTestUser(t, mgr, "friendsonly")
+
+	settings := models.DefaultPrivacySettings(friendsOnly.ID)
+	settings.ProfileVisibility = models.VisibilityFriends
+	settings.SearchableByEmail = true
+	if err := mgr.SavePrivacySettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	// Both candidates are friends of the viewer's friend
+	for _, f := range [][2]uint{{viewer.ID, friend.ID}, {friend.ID, public.ID}, {friend.ID, friendsOnly.ID}} {
+		if err := mgr.CreateFriendship(f[0], f[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/friend-suggestions", func(c *gin.Context) {
+		c.Set("userId", strconv.FormatUint(uint64(viewer.ID), 10))
+	}, GetFriendSuggestionsHandler(mgr))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/friend-suggestions", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		Suggestions []FriendSuggestion `json:"suggestions"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Suggestions) != 1 || response.Suggestions[0].User.ID != public.ID {
+		t.Fatalf("suggestions = %+v, want only %s", response.Suggestions, public.Username)
+	}
+	if email := response.Suggestions[0].User.Email; email != "" {
+		t.Errorf("suggestion shows email %q", email)
+	}
+}
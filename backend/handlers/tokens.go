@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// Access tokens are short-lived since they're only checked against their
+// session, refresh tokens keep a device logged in for a month of inactivity
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+func jwtSecret() string {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		secret = "F4LLB4CK" // using fallback secret, just for dev
+	}
+	return secret
+}
+
+// Signs an access token for the user's session
+func newAccessToken(user *models.User, sessionID uint) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub":      strconv.Itoa(int(user.ID)),
+		"sid":      sessionID,
+		"username": user.Username,
+		"exp":      now.Add(AccessTokenTTL).Unix(),
+		"iat":      now.Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(jwtSecret()))
+}
+
+// The tokens part of login, signup and refresh responses
+func tokenResponse(accessToken string, refreshToken string) gin.H {
+	return gin.H{
+		"token":         accessToken,
+		"refresh_token": refreshToken,
+		"expires_in":    int(AccessTokenTTL.Seconds()),
+	}
+}
+
+// Starts a session for the user on the requesting device
+func startSession(c *gin.Context, mgr *db.DBManager, user *models.User) (gin.H, error) {
+	session, refreshToken, err := mgr.CreateSession(user.ID, c.Request.UserAgent(), c.ClientIP(), RefreshTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := newAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return tokenResponse(accessToken, refreshToken), nil
+}
@@ -0,0 +1,77 @@
+package ingest
+
+import (
+	"fmt"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+)
+
+// NotifyFavorites tells users when dishes they follow are on the menus
+// loaded by the given runs, e.g. "Garlic Noodles at Bruin Plate for dinner today".
+// Menus that were already loaded by an earlier run aren't notified about again.
+func NotifyFavorites(mgr *db.DBManager, notifier notify.Notifier, reports []*IngestReport) error {
+	var menuIDs []uint
+	for _, report := range reports {
+		for _, hall := range report.Halls {
+			menuIDs = append(menuIDs, hall.MenuIDs...)
+		}
+	}
+
+	matches, err := mgr.GetFavoriteMatches(menuIDs)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	today := Today(mgr)
+	labels, err := mgr.GetAllMealPeriodLabels() // hall name -> meal period -> label
+	if err != nil {
+		return err
+	}
+	messages := make([]notify.Message, 0, len(matches))
+	for _, match := range matches {
+		mealPeriod := *match.Date.MealPeriod
+		label := mealPeriod
+		if hallLabel, ok := labels[match.HallName][mealPeriod]; ok {
+			label = hallLabel
+		}
+
+		messages = append(messages, notify.Message{
+			UserID: match.UserID,
+			Type:   notify.TypeFavoriteOnMenu,
+			Title:  favoriteTitle(match, label, today, mgr),
+			Data: map[string]interface{}{
+				"dish_id":     match.DishID,
+				"menu_id":     match.MenuID,
+				"hall_name":   match.HallName,
+				"date":        match.Date.String(),
+				"meal_period": label,
+			},
+		})
+	}
+	return notifier.Notify(messages...)
+}
+
+// e.g. "Garlic Noodles at Bruin Plate for dinner tomorrow"
+func favoriteTitle(match models.FavoriteMatch, label string, today models.Date, mgr *db.DBManager) string {
+	hallName := match.HallDisplayName
+	if hallName == "" {
+		hallName = models.DisplayNameFromSlug(match.HallName)
+	}
+
+	when := "on " + match.Date.Time(mgr.TZ).Weekday().String()
+	if match.Date.SameDay(today) {
+		when = "today"
+	} else if match.Date.SameDay(models.DateOf(today.Time(mgr.TZ).AddDate(0, 0, 1))) {
+		when = "tomorrow"
+	}
+
+	if label == models.PeriodAllDay {
+		return fmt.Sprintf("%s at %s %s", match.DishName, hallName, when)
+	}
+	return fmt.Sprintf("%s at %s for %s %s", match.DishName, hallName, models.MealPeriodWord(label), when)
+}
@@ -0,0 +1,119 @@
+package ingest
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/db/dbtest"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+)
+
+func createTestUser(t *testing.T, mgr *db.DBManager, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", HashedPassword: "x"}
+	if err := mgr.CreateUser(user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func findDish(t *testing.T, mgr *db.DBManager, hallName string, dishName string) models.Dish {
+	t.Helper()
+	var dish models.Dish
+	err := mgr.DB.Joins("JOIN dining_halls ON dining_halls.id = dishes.hall_id").
+		Where("dining_halls.name = ? AND dishes.name = ?", hallName, dishName).
+		First(&dish).Error
+	if err != nil {
+		t.Fatalf("could not find %s at %s: %v", dishName, hallName, err)
+	}
+	return dish
+}
+
+func TestNotifyFavorites(t *testing.T) {
+	mgr := dbtest.New(t)
+	today := Today(mgr)
+	yesterday := models.DateOf(today.Time(mgr.TZ).AddDate(0, 0, -1))
+
+	source := &FixtureSource{
+		Dates: map[string]MenuData{yesterday.String(): {
+			"bruin-plate":    {"LUNCH": {{Name: "Harvest", Items: menuItems("Garlic Noodles", "Orange Chicken")}}},
+			"de-neve-dining": {"DINNER": {{Name: "The Kitchen", Items: menuItems("Garlic Noodles")}}},
+		}},
+		Data: MenuData{
+			"bruin-plate": {"LUNCH": {{Name: "Harvest", Items: menuItems("Garlic Noodles")}}},
+		},
+	}
+
+	// Yesterday's menus create the dishes that users follow
+	if _, err := IngestDate(mgr, source, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	bruinPlateNoodles := findDish(t, mgr, "bruin-plate", "Garlic Noodles")
+	deNeveNoodles := findDish(t, mgr, "de-neve-dining", "Garlic Noodles")
+	orangeChicken := findDish(t, mgr, "bruin-plate", "Orange Chicken")
+
+	dishFan := createTestUser(t, mgr, "dishfan")       // follows the dish on today's menu
+	recipeFan := createTestUser(t, mgr, "recipefan")   // follows the recipe, through the other hall's dish
+	otherHallFan := createTestUser(t, mgr, "deneve")   // follows only the other hall's dish
+	chickenFan := createTestUser(t, mgr, "chickenfan") // follows a dish that isn't on today's menu
+	createTestUser(t, mgr, "nofavorites")
+
+	follows := []struct {
+		user     *models.User
+		dishID   *uint
+		recipeID *uint
+	}{
+		{dishFan, &bruinPlateNoodles.ID, nil},
+		{recipeFan, nil, deNeveNoodles.RecipeID},
+		{otherHallFan, &deNeveNoodles.ID, nil},
+		{chickenFan, &orangeChicken.ID, nil},
+	}
+	for _, follow := range follows {
+		if _, err := mgr.AddFavorite(follow.user.ID, follow.dishID, follow.recipeID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifier := &notify.MemoryNotifier{}
+	report, err := IngestDate(mgr, source, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NotifyFavorites(mgr, notifier, []*IngestReport{report}); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := notifier.Messages()
+	var notified []uint
+	for _, message := range messages {
+		notified = append(notified, message.UserID)
+		if message.Type != notify.TypeFavoriteOnMenu {
+			t.Errorf("message type = %q, want %q", message.Type, notify.TypeFavoriteOnMenu)
+		}
+		if want := "Garlic Noodles at Bruin Plate for lunch today"; message.Title != want {
+			t.Errorf("message title = %q, want %q", message.Title, want)
+		}
+		if message.Data["dish_id"] != bruinPlateNoodles.ID {
+			t.Errorf("message dish_id = %v, want %d", message.Data["dish_id"], bruinPlateNoodles.ID)
+		}
+	}
+	sort.Slice(notified, func(i, j int) bool { return notified[i] < notified[j] })
+	want := []uint{dishFan.ID, recipeFan.ID}
+	if len(notified) != len(want) || notified[0] != want[0] || notified[1] != want[1] {
+		t.Fatalf("notified users %v, want %v", notified, want)
+	}
+
+	// Loading the same menus again doesn't notify anyone again
+	report, err = IngestDate(mgr, source, today)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NotifyFavorites(mgr, notifier, []*IngestReport{report}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(notifier.Messages()); got != len(messages) {
+		t.Errorf("rerun sent %d more messages, want none", got-len(messages))
+	}
+}
@@ -0,0 +1,83 @@
+package ingest
+
+import (
+	"fmt"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// PeriodHours are the hours of one meal period
+type PeriodHours struct {
+	MealPeriod string // the period the menu is stored under
+	Label      string // the period shown to users
+	Opens      string // HH:MM
+	Closes     string // HH:MM
+}
+
+// DefaultMealHours are the usual residential dining hours of each meal period
+// (https://dining.ucla.edu/hours/). Ingest uses them for halls it hasn't seen
+// serve a meal period before; they can be changed afterwards.
+var DefaultMealHours = map[string]PeriodHours{
+	"BREAKFAST":  {MealPeriod: "BREAKFAST", Label: "BREAKFAST", Opens: "07:00", Closes: "10:00"},
+	"LUNCH":      {MealPeriod: "LUNCH", Label: "LUNCH", Opens: "11:00", Closes: "15:00"},
+	"DINNER":     {MealPeriod: "DINNER", Label: "DINNER", Opens: "17:00", Closes: "21:00"},
+	"LATE_NIGHT": {MealPeriod: "LATE_NIGHT", Label: "LATE_NIGHT", Opens: "21:00", Closes: "00:00"},
+}
+
+// DefaultHallHours are the default hours of halls that serve one menu across
+// several meal periods. Their menus are stored under BREAKFAST.
+var DefaultHallHours = map[string][]PeriodHours{
+	string(models.BruinCafe):   {{MealPeriod: "BREAKFAST", Label: models.PeriodAllDay, Opens: "07:00", Closes: "23:00"}},
+	string(models.TheDrey):     {{MealPeriod: "BREAKFAST", Label: models.PeriodLunchDinner, Opens: "11:00", Closes: "21:00"}},
+	string(models.Rendezvous):  {{MealPeriod: "BREAKFAST", Label: models.PeriodLunchDinner, Opens: "11:00", Closes: "21:00"}},
+	string(models.EpicuriaAck): {{MealPeriod: "BREAKFAST", Label: models.PeriodLunchDinner, Opens: "11:00", Closes: "21:00"}},
+}
+
+// defaultHours returns the default hours, for every day of the week, of the
+// meal periods a hall was seen serving
+func defaultHours(hall models.DiningHall, mealPeriods []string) []models.HallHours {
+	periods, special := DefaultHallHours[hall.Name]
+	if !special {
+		for _, mealPeriod := range mealPeriods {
+			if period, ok := DefaultMealHours[mealPeriod]; ok {
+				periods = append(periods, period)
+			}
+		}
+	}
+
+	var hours []models.HallHours
+	for day := 0; day < 7; day++ {
+		for _, period := range periods {
+			hours = append(hours, models.HallHours{
+				HallID:     hall.ID,
+				DayOfWeek:  day,
+				MealPeriod: period.MealPeriod,
+				Label:      period.Label,
+				Opens:      period.Opens,
+				Closes:     period.Closes,
+			})
+		}
+	}
+	return hours
+}
+
+// BackfillHallHours gives halls that have no hours yet (e.g. halls loaded
+// before hours existed) the default hours of the meal periods they have
+// served. Halls that already have hours are left alone.
+func BackfillHallHours(mgr *db.DBManager) error {
+	halls, err := mgr.GetHallsWithoutHours()
+	if err != nil {
+		return err
+	}
+	for _, hall := range halls {
+		periods, err := mgr.GetServedMealPeriods(hall.ID)
+		if err != nil {
+			return err
+		}
+		if err := mgr.SeedHallHours(defaultHours(hall, periods)); err != nil {
+			return fmt.Errorf("hours for %s: %w", hall.Name, err)
+		}
+	}
+	return nil
+}
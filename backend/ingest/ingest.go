@@ -1,115 +1,244 @@
 package ingest
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/events"
 	"github.com/gsonntag/bruinbite/models"
 )
 
-// This is the JSON structure that the scraper provides.
-// It is: hall -> meal period (breakfast, lunch, dinner) -> category (different areas of the dining halls) -> array of item names
-type MenuData map[string]map[string]map[string][]string
+// Today returns the current date in Pacific time (so that each menu is unique by day)
+func Today(mgr *db.DBManager) models.Date {
+	return models.DateOf(time.Now().In(mgr.TZ))
+}
+
+// FetchAndIngest fetches today's menus from the given source and loads them into the database
+func FetchAndIngest(mgr *db.DBManager, source MenuSource) (*IngestReport, error) {
+	return IngestDate(mgr, source, Today(mgr))
+}
 
-// Returns a models.Date object with no meal period defined
-func GetToday() (*models.Date, error) {
-	// Base time off Pacific time, so that each menu is unique by day.
-	loc, err := time.LoadLocation("America/Los_Angeles")
+// IngestDate fetches the menus for a date from the given source and loads
+// them into the database. Each hall is loaded in its own transaction, so a
+// hall that fails doesn't leave half of its menus behind or stop the other
+// halls from loading. Failed halls are listed in the report. An error is
+// only returned if the source itself fails (ErrDateUnavailable if it has no
+// menus for that date).
+func IngestDate(mgr *db.DBManager, source MenuSource, date models.Date) (*IngestReport, error) {
+	date.MealPeriod = nil
+	fmt.Printf("Fetching %s menus from %s...\n", date, source.Name())
+	start := time.Now()
+
+	topLevel, err := source.Fetch(date, Today(mgr))
+	elapsed := time.Since(start)
+	fmt.Printf("Finished fetching menus (%s)\n", elapsed)
 
 	if err != nil {
-		return nil, fmt.Errorf("Could not load Pacific timezone: %w", err)
+		if errors.Is(err, ErrDateUnavailable) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("menu source %s failed: %w", source.Name(), err)
 	}
 
-	now := time.Now().In(loc)
-	dinner := "DINNER"
-	date := models.Date{Day: now.Day(), Month: int(now.Month()), Year: now.Year(), MealPeriod: &dinner}
-	return &date, nil
-}
+	report := &IngestReport{Source: source.Name(), Date: date, StartedAt: start}
 
-func FetchAndIngest(mgr *db.DBManager) error {
-	fmt.Println("Scraping website for latest menus...")
-	start := time.Now()
-	today, err := GetToday()
-	if err != nil {
-		return err
+	// Sorted so that runs are logged in a consistent order
+	hallNames := make([]string, 0, len(topLevel))
+	for hallName := range topLevel {
+		hallNames = append(hallNames, hallName)
 	}
+	sort.Strings(hallNames)
 
-	loaded, err := mgr.HasScraperLoaded(*today)
-	if err != nil {
-		return err
+	for _, hallName := range hallNames {
+		hallReport := ingestHall(mgr, hallName, topLevel[hallName], date)
+		if hallReport.Error != "" {
+			fmt.Printf("[DEBUG] Failed to ingest %s: %s\n", hallName, hallReport.Error)
+		}
+		report.add(hallReport)
 	}
-	if loaded {
-		elapsed := time.Since(start)
-		fmt.Printf("Already scraped today (%s)\n", elapsed)
-		return nil
+
+	report.FinishedAt = time.Now()
+	fmt.Printf("[DEBUG] Finished ingest for %s\n", report)
+	publishMenusAvailable(mgr, report)
+	return report, nil
+}
+
+// Tells connected clients which halls got new menus
+func publishMenusAvailable(mgr *db.DBManager, report *IngestReport) {
+	halls := []map[string]interface{}{}
+	for _, hall := range report.Halls {
+		if len(hall.Periods) > 0 {
+			halls = append(halls, map[string]interface{}{"hall_name": hall.Hall, "meal_periods": hall.Periods})
+		}
+	}
+	if len(halls) == 0 {
+		return
 	}
+	mgr.Publish(events.Event{
+		Type: events.TypeMenusAvailable,
+		Data: map[string]interface{}{"date": report.Date.String(), "halls": halls},
+	})
+}
 
-	cmd := exec.Command("scraper/.venv/bin/python3", "scraper/scraper.py")
-	out, err := cmd.Output()
-	elapsed := time.Since(start)
-	fmt.Printf("Finished scraping (%s)\n", elapsed)
+// IngestRange ingests every date from `from` to `to` (inclusive) that the
+// source has menus for. Dates the source can't provide are skipped.
+func IngestRange(mgr *db.DBManager, source MenuSource, from models.Date, to models.Date) ([]*IngestReport, error) {
+	var reports []*IngestReport
+	for day := from; !to.Before(day); day = models.DateOf(day.Time(mgr.TZ).AddDate(0, 0, 1)) {
+		report, err := IngestDate(mgr, source, day)
+		if errors.Is(err, ErrDateUnavailable) {
+			fmt.Printf("[DEBUG] No menus available for %s\n", day)
+			continue
+		}
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
 
+// Backfill ingests every dated MenuData snapshot (YYYY-MM-DD.json) in dir,
+// oldest first. Menus that are already in the database are skipped, so it
+// is safe to run more than once over the same directory.
+func Backfill(mgr *db.DBManager, dir string) ([]*IngestReport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
 	if err != nil {
-		return fmt.Errorf("scraper failed: %w\noutput:\n%s", err, out)
+		return nil, err
 	}
 
-	var topLevel MenuData
-	if err := json.Unmarshal(out, &topLevel); err != nil {
-		return fmt.Errorf("invalid json from scraper: %w", err)
+	var dates []models.Date
+	for _, file := range files {
+		if date, ok := snapshotDate(file); ok {
+			dates = append(dates, date)
+		}
+	}
+	if len(dates) == 0 {
+		return nil, fmt.Errorf("no dated snapshots (YYYY-MM-DD.json) found in %s", dir)
 	}
+	sort.Slice(dates, func(i, j int) bool { return dates[i].Before(dates[j]) })
 
-	for hallName, mealPeriods := range topLevel {
+	source := &FileSource{Path: dir}
+	var reports []*IngestReport
+	for _, date := range dates {
+		report, err := IngestDate(mgr, source, date)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
 
+// Converts the nutrition facts and allergens of a menu item to the model stored in the database
+func dishNutrition(item MenuItem) *models.DishNutrition {
+	nutrition := &models.DishNutrition{Allergens: item.AllAllergens()}
+	if item.RecipeURL != "" {
+		nutrition.RecipeURL = &item.RecipeURL
+	}
+	if item.Nutrition != nil {
+		if item.Nutrition.ServingSize != "" {
+			nutrition.ServingSize = &item.Nutrition.ServingSize
+		}
+		nutrition.Calories = item.Nutrition.Calories
+		nutrition.ProteinGrams = item.Nutrition.Protein
+		nutrition.CarbsGrams = item.Nutrition.Carbs
+		nutrition.FatGrams = item.Nutrition.Fat
+	}
+	return nutrition
+}
+
+// Loads every meal period of one hall for the day inside a single transaction
+func ingestHall(mgr *db.DBManager, hallName string, mealPeriods HallMenu, day models.Date) HallReport {
+	report := HallReport{Hall: hallName, Periods: []string{}}
+
+	periodNames := make([]string, 0, len(mealPeriods))
+	for mealPeriod := range mealPeriods {
+		periodNames = append(periodNames, mealPeriod)
+	}
+	sort.Strings(periodNames)
+
+	err := mgr.Transaction(func(tx *db.DBManager) error {
 		// Create hall if it doesn't exist
-		hall, err := mgr.CreateNewHall(hallName)
+		hall, err := tx.CreateNewHall(hallName)
 		if err != nil {
 			return err
 		}
 
-		for mealPeriod, locations := range mealPeriods {
+		// Give the hall hours for any meal period it hasn't served before
+		if err := tx.SeedHallHours(defaultHours(hall, periodNames)); err != nil {
+			return fmt.Errorf("hours: %w", err)
+		}
+
+		for _, mealPeriod := range periodNames {
+			date := day
+			date.MealPeriod = &mealPeriod
 
 			// Check if menu has previously been loaded
-			today.MealPeriod = &mealPeriod
-			loaded, err := mgr.HasScraperLoaded(*today)
+			loaded, err := tx.HasMenuLoaded(hallName, date)
 			if err != nil {
 				return err
 			}
-
 			if loaded {
-				// If scraper has already run, we are assuming that
-				// it has loaded all dining halls, so we don't have
-				// to check every dining hall again. (why we return,
-				// not break or continue or something else)
-				fmt.Print("[DEBUG] Scraper already ran for this period.\n")
-				return nil
+				report.SkippedPeriods = append(report.SkippedPeriods, mealPeriod)
+				continue
 			}
 
-			menu := models.Menu{Date: *today, HallID: hall.ID, Dishes: []models.Dish{}}
+			menu := models.Menu{Date: date, HallID: hall.ID}
+			var entries []models.MenuDish
 
-			for hallSubcategory, items := range locations { // hallSubcategory represents the location within the dining hall
-				for _, name := range items {
+			// Stations are kept in the order the source listed them, so the
+			// menu lists dishes in the same order as the dining website
+			for _, stationMenu := range mealPeriods[mealPeriod] {
+				hallSubcategory := stationMenu.Name // hallSubcategory represents the station within the dining hall
+				station, err := tx.GetOrCreateStation(hall.ID, hallSubcategory)
+				if err != nil {
+					return fmt.Errorf("station %q: %w", hallSubcategory, err)
+				}
 
-					// Check if dish exists
-					dish, err := mgr.GetOrCreateDishByName(name, hall.ID, hallSubcategory, *today)
+				for _, item := range stationMenu.Items {
+					dish, created, err := tx.GetOrCreateDishByName(item.Name, hall.ID, hallSubcategory, date)
 					if err != nil {
-						return err
+						return fmt.Errorf("dish %q: %w", item.Name, err)
+					}
+					// Sources without dietary info (e.g. the legacy scraper) leave existing info alone
+					if item.HasDietaryInfo() {
+						tags := item.Tags
+						if tags == nil {
+							tags = []string{}
+						}
+						if err := tx.UpdateDishDietaryInfo(dish.ID, tags, dishNutrition(item)); err != nil {
+							return fmt.Errorf("dish %q dietary info: %w", item.Name, err)
+						}
 					}
-					//fmt.Printf("[DEBUG] Created dish %s in hall %s.\n", dish.Name, hallName)
-					menu.Dishes = append(menu.Dishes, dish)
+					if created {
+						report.NewDishes++
+					} else {
+						report.ReusedDishes++
+					}
+					entries = append(entries, models.MenuDish{DishID: dish.ID, StationID: &station.ID})
 				}
 			}
-			err = mgr.AddMenu(&menu)
-			if err != nil {
-				return err
+
+			if err := tx.AddMenuWithStations(&menu, entries); err != nil {
+				return fmt.Errorf("%s menu: %w", mealPeriod, err)
+			}
+			if err := tx.SetMenuLoaded(hallName, date); err != nil {
+				return fmt.Errorf("%s tracker: %w", mealPeriod, err)
 			}
-			fmt.Printf("[DEBUG] Inserted %s menu (meal period %s) into DB.\n", hallName, *menu.Date.MealPeriod)
+			report.Periods = append(report.Periods, mealPeriod)
+			report.MenuIDs = append(report.MenuIDs, menu.ID)
 		}
-	}
+		return nil
+	})
 
-	mgr.SetScraperLastRan(*today)
-	fmt.Printf("[DEBUG] Finished. Set scraper last ran to now (%d/%d/%d %s).\n", today.Month, today.Day, today.Year, *today.MealPeriod)
-	return nil
+	if err != nil {
+		// Everything was rolled back, so nothing from this run was saved
+		return HallReport{Hall: hallName, Periods: []string{}, Error: err.Error()}
+	}
+	return report
 }
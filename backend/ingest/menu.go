@@ -0,0 +1,217 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// This is the JSON structure that menu sources provide.
+// It is: hall -> meal period (breakfast, lunch, dinner) -> category (different areas of the dining halls) -> array of items
+type MenuData map[string]HallMenu
+
+// The menu of one hall: meal period -> stations
+type HallMenu map[string]Stations
+
+// Stations are the categories (stations) of one meal period in the order the
+// source listed them. In JSON they are an object of station name -> array of
+// items, and the order of its keys is kept.
+type Stations []Station
+
+// Station is one category of a meal period and the items it serves
+type Station struct {
+	Name  string
+	Items []MenuItem
+}
+
+func (s *Stations) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		*s = nil
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("stations must be an object of station name -> items")
+	}
+
+	stations := Stations{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var items []MenuItem
+		if err := dec.Decode(&items); err != nil {
+			return err
+		}
+		stations = append(stations, Station{Name: tok.(string), Items: items})
+	}
+	*s = stations
+	return nil
+}
+
+// Returns the stations with the items added to the station with the given
+// name, which is added at the end if it isn't there yet
+func (s Stations) add(name string, items []MenuItem) Stations {
+	for i := range s {
+		if s[i].Name == name {
+			s[i].Items = append(s[i].Items, items...)
+			return s
+		}
+	}
+	return append(s, Station{Name: name, Items: items})
+}
+
+func (s Stations) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, station := range s {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(station.Name)
+		if err != nil {
+			return nil, err
+		}
+		items, err := json.Marshal(station.Items)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(items)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MenuItem is a single dish on a menu. In JSON an item with only a name is
+// written as a plain string, which is also what the legacy Python scraper
+// prints, so older captured menus can still be read.
+type MenuItem struct {
+	Name      string     `json:"name"`
+	Tags      []string   `json:"tags,omitempty"`
+	Allergens []string   `json:"allergens,omitempty"`
+	RecipeURL string     `json:"recipe_url,omitempty"`
+	Nutrition *Nutrition `json:"nutrition,omitempty"`
+}
+
+// Nutrition facts from a recipe's nutrition page
+type Nutrition struct {
+	ServingSize string   `json:"serving_size,omitempty"`
+	Calories    *int     `json:"calories,omitempty"`
+	Protein     *float64 `json:"protein_g,omitempty"`
+	Carbs       *float64 `json:"carbs_g,omitempty"`
+	Fat         *float64 `json:"fat_g,omitempty"`
+	Allergens   []string `json:"allergens,omitempty"`
+}
+
+func (i *MenuItem) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*i = MenuItem{Name: name}
+		return nil
+	}
+
+	type plainItem MenuItem // avoids recursing into this method
+	var item plainItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return err
+	}
+	*i = MenuItem(item)
+	return nil
+}
+
+func (i MenuItem) MarshalJSON() ([]byte, error) {
+	if !i.HasDietaryInfo() {
+		return json.Marshal(i.Name)
+	}
+	type plainItem MenuItem
+	return json.Marshal(plainItem(i))
+}
+
+// HasDietaryInfo returns true if the item has anything besides its name
+func (i MenuItem) HasDietaryInfo() bool {
+	return len(i.Tags) > 0 || len(i.Allergens) > 0 || i.RecipeURL != "" || i.Nutrition != nil
+}
+
+// AllAllergens returns the allergens from the recipe card and nutrition page combined
+func (i MenuItem) AllAllergens() []string {
+	allergens := append([]string{}, i.Allergens...)
+	if i.Nutrition != nil {
+		for _, allergen := range i.Nutrition.Allergens {
+			if !containsString(allergens, allergen) {
+				allergens = append(allergens, allergen)
+			}
+		}
+	}
+	return allergens
+}
+
+// Returns items with just names
+func menuItems(names ...string) []MenuItem {
+	items := make([]MenuItem, len(names))
+	for i, name := range names {
+		items[i] = MenuItem{Name: name}
+	}
+	return items
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifyDietaryLabel maps the label of a dietary icon on a recipe card or
+// an allergen listed on a nutrition page (e.g. "Vegan Menu Option", "Contains
+// Tree Nuts", "Milk") to a dietary tag or an allergen. Both are empty if the
+// label isn't recognized.
+func ClassifyDietaryLabel(label string) (tag string, allergen string) {
+	label = strings.ToLower(strings.TrimSpace(label))
+
+	switch {
+	case strings.Contains(label, "vegan"):
+		return models.TagVegan, ""
+	case strings.Contains(label, "vegetarian"):
+		return models.TagVegetarian, ""
+	case strings.Contains(label, "halal"):
+		return models.TagHalal, ""
+	case strings.Contains(label, "low carbon"):
+		return models.TagLowCarbon, ""
+	case strings.Contains(label, "high carbon"):
+		return models.TagHighCarbon, ""
+	case strings.Contains(label, "gluten"):
+		return "", models.AllergenGluten
+	case strings.Contains(label, "wheat"):
+		return "", models.AllergenWheat
+	case strings.Contains(label, "dairy"), strings.Contains(label, "milk"):
+		return "", models.AllergenDairy
+	case strings.Contains(label, "egg"):
+		return "", models.AllergenEggs
+	case strings.Contains(label, "soy"):
+		return "", models.AllergenSoy
+	case strings.Contains(label, "peanut"):
+		return "", models.AllergenPeanuts
+	case strings.Contains(label, "tree nut"):
+		return "", models.AllergenTreeNuts
+	case strings.Contains(label, "shellfish"), strings.Contains(label, "crustacean"):
+		return "", models.AllergenShellfish
+	case strings.Contains(label, "fish"):
+		return "", models.AllergenFish
+	case strings.Contains(label, "sesame"):
+		return "", models.AllergenSesame
+	case strings.Contains(label, "alcohol"):
+		return "", models.AllergenAlcohol
+	}
+	return "", ""
+}
@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStationsJSON(t *testing.T) {
+	// Stations aren't in alphabetical order and the items are a mix of plain
+	// names (as printed by the legacy scraper) and items with dietary info
+	raw := `{"Harvest":["Brown Rice",{"name":"Miso Glazed Salmon","allergens":["fish"]}],"Freshly Bowled":["Kale Caesar Salad"],"Bakery":[]}`
+	want := Stations{
+		{Name: "Harvest", Items: []MenuItem{{Name: "Brown Rice"}, {Name: "Miso Glazed Salmon", Allergens: []string{"fish"}}}},
+		{Name: "Freshly Bowled", Items: []MenuItem{{Name: "Kale Caesar Salad"}}},
+		{Name: "Bakery", Items: []MenuItem{}},
+	}
+
+	var stations Stations
+	if err := json.Unmarshal([]byte(raw), &stations); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stations, want) {
+		t.Fatalf("Unmarshal() =\n%#v\nwant\n%#v", stations, want)
+	}
+
+	out, err := json.Marshal(stations)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != raw {
+		t.Errorf("Marshal() = %s, want %s", out, raw)
+	}
+}
+
+func TestStationsJSONInvalid(t *testing.T) {
+	for _, raw := range []string{`["Harvest"]`, `{"Harvest":"Brown Rice"}`, `{"Harvest":[1]}`} {
+		var stations Stations
+		if err := json.Unmarshal([]byte(raw), &stations); err == nil {
+			t.Errorf("Unmarshal(%s) returned no error", raw)
+		}
+	}
+}
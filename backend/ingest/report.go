@@ -0,0 +1,60 @@
+package ingest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// HallReport is the result of ingesting one hall for one day. A hall is
+// ingested in a single transaction, so if Error is set nothing was saved.
+type HallReport struct {
+	Hall           string   `json:"hall"`
+	Periods        []string `json:"periods"`                   // meal periods loaded by this run
+	SkippedPeriods []string `json:"skipped_periods,omitempty"` // meal periods that were already loaded
+	NewDishes      int      `json:"new_dishes"`
+	ReusedDishes   int      `json:"reused_dishes"`
+	Error          string   `json:"error,omitempty"`
+	MenuIDs        []uint   `json:"-"` // menus created by this run
+}
+
+// IngestReport is the result of ingesting every hall provided by a source for one day
+type IngestReport struct {
+	Source       string       `json:"source"`
+	Date         models.Date  `json:"date"`
+	StartedAt    time.Time    `json:"started_at"`
+	FinishedAt   time.Time    `json:"finished_at"`
+	Halls        []HallReport `json:"halls"`
+	NewDishes    int          `json:"new_dishes"`
+	ReusedDishes int          `json:"reused_dishes"`
+	Failures     []string     `json:"failures,omitempty"` // halls that failed to load
+}
+
+// Adds a hall's result to the report totals
+func (r *IngestReport) add(hall HallReport) {
+	r.Halls = append(r.Halls, hall)
+	r.NewDishes += hall.NewDishes
+	r.ReusedDishes += hall.ReusedDishes
+	if hall.Error != "" {
+		r.Failures = append(r.Failures, hall.Hall)
+	}
+}
+
+// Err returns an error describing the failed halls, or nil if every hall loaded
+func (r *IngestReport) Err() error {
+	if len(r.Failures) == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to ingest %d of %d halls: %v", len(r.Failures), len(r.Halls), r.Failures)
+}
+
+func (r *IngestReport) String() string {
+	periods := 0
+	for _, hall := range r.Halls {
+		periods += len(hall.Periods)
+	}
+	return fmt.Sprintf("%d/%d/%d: %d halls, %d meal periods loaded, %d new dishes, %d reused dishes, %d failures (%s)",
+		r.Date.Month, r.Date.Day, r.Date.Year, len(r.Halls), periods, r.NewDishes, r.ReusedDishes,
+		len(r.Failures), r.FinishedAt.Sub(r.StartedAt))
+}
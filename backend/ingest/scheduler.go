@@ -0,0 +1,61 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+	"github.com/gsonntag/bruinbite/schedule"
+)
+
+// Early morning load, plus a retry before lunch in case menus weren't posted yet
+const DefaultIngestTimes = "05:30,10:30"
+
+// UCLA posts menus a few days ahead, so load those too when the source has them
+const DefaultDaysAhead = 2
+
+// NewScheduler creates a scheduler that ingests menus from the source at the
+// Pacific times in $INGEST_TIMES (or DefaultIngestTimes if unset). Each run
+// loads today plus $INGEST_DAYS_AHEAD (or DefaultDaysAhead) future days,
+// then tells users about their favorite dishes on the new menus.
+func NewScheduler(mgr *db.DBManager, source MenuSource, notifier notify.Notifier) (*schedule.Scheduler, error) {
+	timesSpec := os.Getenv("INGEST_TIMES")
+	if timesSpec == "" {
+		timesSpec = DefaultIngestTimes
+	}
+	times, err := schedule.ParseClockTimes(timesSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	daysAhead := DefaultDaysAhead
+	if daysAheadSpec := os.Getenv("INGEST_DAYS_AHEAD"); daysAheadSpec != "" {
+		daysAhead, err = strconv.Atoi(daysAheadSpec)
+		if err != nil || daysAhead < 0 {
+			return nil, errors.New("INGEST_DAYS_AHEAD must be a non-negative number")
+		}
+	}
+
+	return schedule.New("ingest", mgr.TZ, times, func() (interface{}, error) {
+		today := Today(mgr)
+		lastDay := models.DateOf(today.Time(mgr.TZ).AddDate(0, 0, daysAhead))
+		reports, err := IngestRange(mgr, source, today, lastDay)
+		// Halls that did load still get their alerts if others failed
+		if notifyErr := NotifyFavorites(mgr, notifier, reports); notifyErr != nil {
+			fmt.Printf("[DEBUG] Failed to send favorite alerts: %s\n", notifyErr)
+		}
+		if err != nil {
+			return reports, err
+		}
+		for _, report := range reports {
+			if err := report.Err(); err != nil {
+				return reports, err
+			}
+		}
+		return reports, nil
+	}), nil
+}
@@ -0,0 +1,566 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gsonntag/bruinbite/models"
+	"golang.org/x/net/html"
+)
+
+const DiningBaseURL = "https://dining.ucla.edu"
+
+// Halls that the scraper visits. Each one lives at DiningBaseURL/<slug>/
+var DiningHalls = []string{
+	"bruin-plate",
+	"de-neve-dining",
+	"epicuria-at-covel",
+	"bruin-cafe",
+	"cafe-1919",
+	"epicuria-at-ackerman",
+	// "meal-swipe-exchange", // HILL FOOD TRUCKS -- NEEDS EXTRA PROCESSING
+	"rendezvous",
+	"the-drey",
+	"the-study-at-hedrick",
+	"spice-kitchen",
+}
+
+// The hall pages mark each meal period with an empty anchor div. The menu
+// for that period lives in the div immediately following the anchor.
+var mealAnchors = []struct {
+	ID     string
+	Period string
+}{
+	{"breakfastmenu", "BREAKFAST"},
+	{"lunchmenu", "LUNCH"},
+	{"dinnermenu", "DINNER"},
+}
+
+// Scraper fetches hall pages from the UCLA dining website and parses them
+// into MenuData. BaseURL and Client can be swapped out so the scraper can be
+// pointed at a local server serving saved HTML fixtures.
+type Scraper struct {
+	BaseURL string
+	Client  *http.Client
+	Halls   []string
+
+	// If true, the nutrition page linked from every recipe card is also
+	// fetched. Pages are cached by URL for the rest of the scrape, since
+	// several halls often serve the same recipe.
+	FetchNutrition bool
+
+	nutritionMu    sync.Mutex
+	nutritionCache map[string]*Nutrition
+}
+
+// NewScraper creates a scraper for the live dining website
+func NewScraper() *Scraper {
+	return &Scraper{
+		BaseURL:        DiningBaseURL,
+		Client:         &http.Client{Timeout: 30 * time.Second},
+		Halls:          DiningHalls,
+		FetchNutrition: true,
+		nutritionCache: map[string]*Nutrition{},
+	}
+}
+
+// Scrape fetches and parses the menu for every hall. A hall that fails to load
+// is logged and recorded with an empty menu, same as a hall that is closed.
+// If no hall could be scraped at all (e.g. the site is down) an error is
+// returned along with the empty menus.
+// If date is nil the current menus are scraped, otherwise the menus the site
+// has posted for that date (UCLA publishes menus several days ahead).
+func (s *Scraper) Scrape(date *models.Date) (MenuData, error) {
+	// Nutrition facts can change between days, and the cache shouldn't grow
+	// for as long as the server runs
+	s.nutritionMu.Lock()
+	s.nutritionCache = map[string]*Nutrition{}
+	s.nutritionMu.Unlock()
+
+	data := MenuData{}
+	var errs []error
+	for _, hall := range s.Halls {
+		menu, err := s.ScrapeHall(hall, date)
+		if err != nil {
+			fmt.Printf("[DEBUG] Could not scrape %s: %v\n", hall, err)
+			errs = append(errs, fmt.Errorf("%s: %w", hall, err))
+			menu = HallMenu{}
+		}
+		if len(menu) == 0 {
+			fmt.Printf("[DEBUG] %s is either closed or an error was found\n", hall)
+		}
+		data[hall] = menu
+	}
+	if len(s.Halls) > 0 && len(errs) == len(s.Halls) {
+		return data, fmt.Errorf("could not scrape any hall: %w", errors.Join(errs...))
+	}
+	return data, nil
+}
+
+// ScrapeHall fetches and parses the page of a single hall, for the given date if not nil
+func (s *Scraper) ScrapeHall(hall string, date *models.Date) (HallMenu, error) {
+	pageURL := strings.TrimRight(s.BaseURL, "/") + "/" + hall + "/"
+	if date != nil {
+		pageURL += "?date=" + date.String()
+	}
+	resp, err := s.Client.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, pageURL)
+	}
+
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse hall page: %w", err)
+	}
+
+	// The site shows the current menus for dates it hasn't posted yet, so
+	// check that the page is actually for the date that was asked for
+	if date != nil {
+		if shown, ok := PageDate(doc); ok && !shown.SameDay(*date) {
+			return nil, fmt.Errorf("%s shows menus for %s: %w", hall, shown, ErrDateUnavailable)
+		}
+	}
+
+	menu, err := parseHallDocument(doc, pageURL)
+	if err != nil || !s.FetchNutrition {
+		return menu, err
+	}
+
+	for _, categories := range menu {
+		for _, category := range categories {
+			items := category.Items
+			for i := range items {
+				if items[i].RecipeURL == "" {
+					continue
+				}
+				nutrition, err := s.nutrition(items[i].RecipeURL)
+				if err != nil {
+					fmt.Printf("[DEBUG] Could not load nutrition for %s: %v\n", items[i].Name, err)
+					continue
+				}
+				items[i].Nutrition = nutrition
+			}
+		}
+	}
+	return menu, nil
+}
+
+// Returns the nutrition facts on the page, from the cache if it was fetched before
+func (s *Scraper) nutrition(pageURL string) (*Nutrition, error) {
+	s.nutritionMu.Lock()
+	cached, ok := s.nutritionCache[pageURL]
+	s.nutritionMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	resp, err := s.Client.Get(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", resp.StatusCode, pageURL)
+	}
+
+	nutrition, err := ParseNutritionPage(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	s.nutritionMu.Lock()
+	if s.nutritionCache == nil {
+		s.nutritionCache = map[string]*Nutrition{}
+	}
+	s.nutritionCache[pageURL] = nutrition
+	s.nutritionMu.Unlock()
+	return nutrition, nil
+}
+
+// ParseHallPage parses the HTML of a hall page into
+// meal period -> category -> array of items. pageURL is used to resolve
+// relative links to recipe pages.
+func ParseHallPage(r io.Reader, pageURL string) (HallMenu, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse hall page: %w", err)
+	}
+	return parseHallDocument(doc, pageURL)
+}
+
+// PageDate returns the date a hall page shows menus for, taken from the
+// selected entry of its date picker. Returns false if the page has no
+// date picker.
+func PageDate(doc *html.Node) (models.Date, bool) {
+	var date models.Date
+	picker := findFirst(doc, func(n *html.Node) bool {
+		_, selected := attr(n, "selected")
+		isPicker := (isElement(n, "option") && selected) || (isElement(n, "input") && getAttr(n, "type") == "date")
+		if !isPicker {
+			return false
+		}
+		parsed, err := models.ParseDate(strings.TrimSpace(getAttr(n, "value")))
+		date = parsed
+		return err == nil
+	})
+	return date, picker != nil
+}
+
+func parseHallDocument(doc *html.Node, pageURL string) (HallMenu, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page url: %w", err)
+	}
+
+	menu := HallMenu{}
+	for _, anchor := range mealAnchors {
+		anchorNode := findFirst(doc, func(n *html.Node) bool {
+			return n.Type == html.ElementNode && getAttr(n, "id") == anchor.ID
+		})
+		if anchorNode == nil {
+			continue // hall doesn't serve this meal period today
+		}
+
+		container := nextElementSibling(anchorNode)
+		if container == nil || container.Data != "div" {
+			continue
+		}
+
+		categories := parseMealContainer(container, anchor.Period, base)
+		if categories != nil {
+			menu[anchor.Period] = categories
+		}
+	}
+
+	return menu, nil
+}
+
+// Parses the sub-category sections within a meal period container, in page order
+func parseMealContainer(container *html.Node, period string, base *url.URL) Stations {
+	prefix := strings.ToLower(period)
+
+	sections := findAll(container, func(n *html.Node) bool {
+		return isElement(n, "div") && hasClass(n, "force-left-full-width") &&
+			strings.HasPrefix(getAttr(n, "id"), prefix)
+	})
+
+	// Fallback if the ID naming convention isn't strictly "mealname-category"
+	if len(sections) == 0 {
+		sections = findAll(container, func(n *html.Node) bool {
+			return isElement(n, "div") && hasClass(n, "force-left-full-width") && getAttr(n, "id") != ""
+		})
+	}
+
+	if len(sections) == 0 {
+		return nil
+	}
+
+	categories := Stations{}
+	for _, section := range sections {
+		name := categoryName(section, period)
+
+		items := []MenuItem{}
+		cards := findAll(section, func(n *html.Node) bool {
+			return isElement(n, "section") && hasClass(n, "recipe-card")
+		})
+		for _, card := range cards {
+			title := findFirst(card, func(n *html.Node) bool {
+				return isElement(n, "h3") && hasAncestorClass(n, card, "ucla-prose") && hasAncestorClass(n, card, "menu-item-title")
+			})
+			if title == nil {
+				continue
+			}
+			if itemName := textContent(title); itemName != "" {
+				items = append(items, parseRecipeCard(card, itemName, base))
+			}
+		}
+		categories = categories.add(name, items)
+	}
+
+	return categories
+}
+
+// Class of the element in a recipe card that holds its dietary icons
+const dietaryIconsClass = "menu-item-meta-data"
+
+// Reads the dietary icons and nutrition page link of a recipe card. The icons
+// are images whose alt/title text describes them, e.g. "Vegan Menu Option"
+// or "Contains Dairy". Only images inside the icon container are read, so
+// the description of a dish photo isn't mistaken for an icon.
+func parseRecipeCard(card *html.Node, name string, base *url.URL) MenuItem {
+	item := MenuItem{Name: name}
+
+	icons := findAll(card, func(n *html.Node) bool {
+		return isElement(n, "img") && hasAncestorClass(n, card, dietaryIconsClass)
+	})
+	for _, icon := range icons {
+		for _, label := range []string{getAttr(icon, "alt"), getAttr(icon, "title")} {
+			if label == "" {
+				continue
+			}
+			tag, allergen := ClassifyDietaryLabel(label)
+			if tag != "" && !containsString(item.Tags, tag) {
+				item.Tags = append(item.Tags, tag)
+			}
+			if allergen != "" && !containsString(item.Allergens, allergen) {
+				item.Allergens = append(item.Allergens, allergen)
+			}
+		}
+	}
+
+	for _, a := range findAll(card, func(n *html.Node) bool { return isElement(n, "a") }) {
+		if link := recipeLink(a, base); link != "" {
+			item.RecipeURL = link
+			break
+		}
+	}
+
+	return item
+}
+
+// Returns the absolute URL of a link to a recipe's nutrition page, or "" if
+// the link goes somewhere else
+func recipeLink(a *html.Node, base *url.URL) string {
+	href := getAttr(a, "href")
+	if !strings.Contains(href, "recipe") && !strings.Contains(href, "menu-item") {
+		return ""
+	}
+	link, err := base.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return link.String()
+}
+
+var (
+	servingSizeRegexp = regexp.MustCompile(`(?i)serving size\s*:?\s*([^\n]+)`)
+	caloriesRegexp    = regexp.MustCompile(`(?i)calories\s*:?\s*(\d+)`)
+	fatRegexp         = regexp.MustCompile(`(?i)total fat\s*:?\s*([\d.]+)\s*g`)
+	carbsRegexp       = regexp.MustCompile(`(?i)total carbohydrates?\s*:?\s*([\d.]+)\s*g`)
+	proteinRegexp     = regexp.MustCompile(`(?i)protein\s*:?\s*([\d.]+)\s*g`)
+	allergensRegexp   = regexp.MustCompile(`(?i)allergens\*?\s*:?\s*([^\n]+)`)
+)
+
+// ParseNutritionPage parses the nutrition facts label of a recipe page
+func ParseNutritionPage(r io.Reader) (*Nutrition, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse nutrition page: %w", err)
+	}
+	text := blockText(doc)
+
+	nutrition := &Nutrition{}
+	if match := servingSizeRegexp.FindStringSubmatch(text); match != nil {
+		nutrition.ServingSize = strings.TrimSpace(match[1])
+	}
+	if match := caloriesRegexp.FindStringSubmatch(text); match != nil {
+		if calories, err := strconv.Atoi(match[1]); err == nil {
+			nutrition.Calories = &calories
+		}
+	}
+	nutrition.Fat = parseGrams(fatRegexp, text)
+	nutrition.Carbs = parseGrams(carbsRegexp, text)
+	nutrition.Protein = parseGrams(proteinRegexp, text)
+
+	if match := allergensRegexp.FindStringSubmatch(text); match != nil {
+		for _, label := range strings.Split(match[1], ",") {
+			if _, allergen := ClassifyDietaryLabel(label); allergen != "" && !containsString(nutrition.Allergens, allergen) {
+				nutrition.Allergens = append(nutrition.Allergens, allergen)
+			}
+		}
+	}
+
+	return nutrition, nil
+}
+
+func parseGrams(re *regexp.Regexp, text string) *float64 {
+	match := re.FindStringSubmatch(text)
+	if match == nil {
+		return nil
+	}
+	grams, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return nil
+	}
+	return &grams
+}
+
+// Extracts the sub-category name from the h2 inside .cat-heading-box
+func categoryName(section *html.Node, period string) string {
+	heading := findFirst(section, func(n *html.Node) bool {
+		return isElement(n, "h2") && hasAncestorClass(n, section, "category-heading") && hasAncestorClass(n, section, "cat-heading-box")
+	})
+	if heading == nil {
+		// Fallback: try to find any h2 in the section
+		heading = findFirst(section, func(n *html.Node) bool { return isElement(n, "h2") })
+	}
+
+	name := "Unknown Category"
+	if heading != nil {
+		name = textContent(heading)
+	}
+
+	// If the name is still the meal period name (e.g. "BREAKFAST"), try to get a better one from the ID
+	if strings.EqualFold(name, period) {
+		id := getAttr(section, "id")
+		if rest, ok := strings.CutPrefix(id, strings.ToLower(period)+"-"); ok {
+			name = titleCase(strings.ReplaceAll(rest, "-", " "))
+		}
+	}
+
+	return name
+}
+
+// Capitalizes the first letter of every word
+func titleCase(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + strings.ToLower(w[1:])
+	}
+	return strings.Join(words, " ")
+}
+
+func isElement(n *html.Node, tag string) bool {
+	return n.Type == html.ElementNode && n.Data == tag
+}
+
+func getAttr(n *html.Node, key string) string {
+	val, _ := attr(n, key)
+	return val
+}
+
+// Returns the value of an attribute and whether it is present at all (for
+// boolean attributes like "selected")
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any ancestor of n (stopping at root) has the given class
+func hasAncestorClass(n, root *html.Node, class string) bool {
+	for p := n.Parent; p != nil && p != root.Parent; p = p.Parent {
+		if p.Type == html.ElementNode && hasClass(p, class) {
+			return true
+		}
+	}
+	return false
+}
+
+func nextElementSibling(n *html.Node) *html.Node {
+	for s := n.NextSibling; s != nil; s = s.NextSibling {
+		if s.Type == html.ElementNode {
+			return s
+		}
+	}
+	return nil
+}
+
+// Depth-first search for the first node matching the predicate
+func findFirst(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findFirst(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Depth-first search for all nodes matching the predicate, in document order
+func findAll(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if match(n) {
+			found = append(found, n)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return found
+}
+
+// Elements that start a new line in blockText
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "table": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "section": true,
+}
+
+// Returns the text inside a node with one line per block element and
+// whitespace within a line collapsed
+func blockText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		if n.Type == html.ElementNode && (n.Data == "script" || n.Data == "style") {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && blockElements[n.Data] {
+			sb.WriteString("\n")
+		}
+	}
+	walk(n)
+
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the text inside a node with whitespace collapsed
+func textContent(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -0,0 +1,232 @@
+package ingest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gsonntag/bruinbite/models"
+)
+
+func openFixture(t *testing.T, name string) *os.File {
+	t.Helper()
+	f, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestParseHallPage(t *testing.T) {
+	menu, err := ParseHallPage(openFixture(t, "hall.html"), "https://dining.ucla.edu/bruin-plate/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := HallMenu{
+		"BREAKFAST": {
+			{Name: "Freshly Bowled", Items: []MenuItem{
+				{Name: "Steel Cut Oatmeal", Tags: []string{"vegan"}, Allergens: []string{"gluten"}, RecipeURL: "https://dining.ucla.edu/menu-item/?recipe=1234"},
+				{Name: "Greek Yogurt Parfait", Tags: []string{"vegetarian"}, Allergens: []string{"dairy"}},
+			}},
+		},
+		"LUNCH": {
+			// Stations are in page order, not sorted by name
+			{Name: "Lunch", Items: []MenuItem{
+				{Name: "Black Bean Chili", RecipeURL: "https://dining.ucla.edu/menu-item/?recipe=5678"},
+			}},
+			{Name: "Harvest", Items: []MenuItem{
+				{Name: "Roasted Salmon", Allergens: []string{"fish"}},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(menu, want) {
+		t.Errorf("ParseHallPage() =\n%#v\nwant\n%#v", menu, want)
+	}
+}
+
+func TestParseHallPageClosed(t *testing.T) {
+	menu, err := ParseHallPage(openFixture(t, "closed.html"), "https://dining.ucla.edu/rendezvous/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(menu) != 0 {
+		t.Errorf("expected no meal periods for a closed hall, got %v", menu)
+	}
+}
+
+// Serves the fixtures as hall pages: bruin-plate and epicuria-at-covel are
+// open, rendezvous is closed and every other hall is missing. Recipe pages
+// serve the nutrition fixture, and every request for one is counted in
+// nutritionRequests.
+func fixtureServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	nutritionRequests.Store(0)
+	mux := http.NewServeMux()
+	for _, hall := range []string{"/bruin-plate/", "/epicuria-at-covel/"} {
+		mux.HandleFunc(hall, func(w http.ResponseWriter, r *http.Request) {
+			http.ServeFile(w, r, "testdata/hall.html")
+		})
+	}
+	mux.HandleFunc("/rendezvous/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/closed.html")
+	})
+	mux.HandleFunc("/menu-item/", func(w http.ResponseWriter, r *http.Request) {
+		nutritionRequests.Add(1)
+		http.ServeFile(w, r, "testdata/nutrition.html")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+var nutritionRequests atomic.Int32
+
+func TestScrape(t *testing.T) {
+	server := fixtureServer(t)
+
+	tests := []struct {
+		name      string
+		halls     []string
+		wantErr   bool
+		wantMeals map[string]int
+	}{
+		{"open hall", []string{"bruin-plate"}, false, map[string]int{"bruin-plate": 2}},
+		{"closed hall is not an error", []string{"rendezvous"}, false, map[string]int{"rendezvous": 0}},
+		{"some halls fail", []string{"bruin-plate", "de-neve-dining"}, false, map[string]int{"bruin-plate": 2, "de-neve-dining": 0}},
+		{"every hall fails", []string{"de-neve-dining", "the-study-at-hedrick"}, true, map[string]int{"de-neve-dining": 0, "the-study-at-hedrick": 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scraper := &Scraper{BaseURL: server.URL, Client: server.Client(), Halls: tt.halls}
+			data, err := scraper.Scrape(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scrape() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(data) != len(tt.wantMeals) {
+				t.Errorf("Scrape() returned %d halls, want %d", len(data), len(tt.wantMeals))
+			}
+			for hall, meals := range tt.wantMeals {
+				menu, ok := data[hall]
+				if !ok {
+					t.Errorf("Scrape() is missing %s", hall)
+					continue
+				}
+				if len(menu) != meals {
+					t.Errorf("%s has %d meal periods, want %d", hall, len(menu), meals)
+				}
+			}
+		})
+	}
+}
+
+func TestScrapeHallDate(t *testing.T) {
+	server := fixtureServer(t)
+	scraper := &Scraper{BaseURL: server.URL, Client: server.Client()}
+
+	tests := []struct {
+		name    string
+		hall    string
+		date    string
+		wantErr error
+	}{
+		{"current menus", "bruin-plate", "", nil},
+		{"date shown on the page", "bruin-plate", "2024-05-01", nil},
+		{"page shows another date", "bruin-plate", "2024-05-02", ErrDateUnavailable},
+		{"page without a date picker", "rendezvous", "2024-05-02", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var date *models.Date
+			if tt.date != "" {
+				d := mustDate(t, tt.date)
+				date = &d
+			}
+			_, err := scraper.ScrapeHall(tt.hall, date)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ScrapeHall() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestScrapeDateUnavailable(t *testing.T) {
+	server := fixtureServer(t)
+	scraper := &Scraper{BaseURL: server.URL, Client: server.Client(), Halls: []string{"bruin-plate"}}
+
+	date := mustDate(t, "2024-05-02")
+	if _, err := scraper.Scrape(&date); !errors.Is(err, ErrDateUnavailable) {
+		t.Errorf("Scrape() error = %v, want %v", err, ErrDateUnavailable)
+	}
+}
+
+func intPtr(i int) *int { return &i }
+
+func floatPtr(f float64) *float64 { return &f }
+
+func TestParseNutritionPage(t *testing.T) {
+	tests := []struct {
+		fixture string
+		want    *Nutrition
+	}{
+		{"nutrition.html", &Nutrition{
+			ServingSize: "1 cup (240 g)",
+			Calories:    intPtr(180),
+			Fat:         floatPtr(3.5),
+			Carbs:       floatPtr(32),
+			Protein:     floatPtr(6.5),
+			Allergens:   []string{"gluten", "wheat", "tree-nuts"},
+		}},
+		{"nutrition_partial.html", &Nutrition{Calories: intPtr(90)}},
+		{"closed.html", &Nutrition{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			nutrition, err := ParseNutritionPage(openFixture(t, tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(nutrition, tt.want) {
+				t.Errorf("ParseNutritionPage() = %+v, want %+v", nutrition, tt.want)
+			}
+		})
+	}
+}
+
+func TestScrapeNutrition(t *testing.T) {
+	server := fixtureServer(t)
+	scraper := &Scraper{
+		BaseURL:        server.URL,
+		Client:         server.Client(),
+		Halls:          []string{"bruin-plate", "epicuria-at-covel"},
+		FetchNutrition: true,
+	}
+
+	for run := 1; run <= 2; run++ {
+		data, err := scraper.Scrape(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		oatmeal := data["epicuria-at-covel"]["BREAKFAST"][0].Items[0]
+		if oatmeal.Nutrition == nil || oatmeal.Nutrition.Calories == nil || *oatmeal.Nutrition.Calories != 180 {
+			t.Fatalf("run %d: oatmeal nutrition = %+v", run, oatmeal.Nutrition)
+		}
+		if parfait := data["bruin-plate"]["BREAKFAST"][0].Items[1]; parfait.Nutrition != nil {
+			t.Errorf("run %d: parfait has no recipe link but got nutrition %+v", run, parfait.Nutrition)
+		}
+
+		// Both halls serve the same two recipes, so each page is only
+		// fetched once per scrape
+		if got, want := nutritionRequests.Load(), int32(2*run); got != want {
+			t.Errorf("run %d: %d nutrition pages fetched, want %d", run, got, want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package ingest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gsonntag/bruinbite/models"
+)
+
+// ErrDateUnavailable is returned by a source that has no menus for the requested date
+var ErrDateUnavailable = errors.New("menus are not available for this date")
+
+// MenuSource is anything that can produce a MenuData to be ingested, e.g.
+// the live website, a captured JSON file or an in-memory fixture
+type MenuSource interface {
+	// Name is a short description of the source used in logs
+	Name() string
+	// Fetch returns the menus for the given date. today is the current date,
+	// so sources that can only provide the current menus can tell them apart.
+	// Returns ErrDateUnavailable if the source has nothing for that date.
+	Fetch(date models.Date, today models.Date) (MenuData, error)
+}
+
+const (
+	SourceScraper = "scraper"
+	SourceCommand = "command"
+	SourceFile    = "file"
+	SourceFixture = "fixture"
+)
+
+// NewMenuSource creates a source by kind. path is used by the file source
+// (a JSON file or a directory of JSON files) and optionally by the command
+// source (the command to run). An empty kind defaults to the scraper.
+//
+// File sources can hold menus for several days: files named YYYY-MM-DD.json
+// are the menus for that date, any other JSON files are today's menus.
+func NewMenuSource(kind string, path string) (MenuSource, error) {
+	switch strings.ToLower(kind) {
+	case "", SourceScraper:
+		scraper := NewScraper()
+		scraper.FetchNutrition = os.Getenv("SCRAPE_NUTRITION") != "false"
+		return scraper, nil
+	case SourceCommand:
+		if path == "" {
+			return NewCommandSource(), nil
+		}
+		return &CommandSource{Command: path}, nil
+	case SourceFile:
+		if path == "" {
+			return nil, fmt.Errorf("file menu source requires a path")
+		}
+		return &FileSource{Path: path}, nil
+	case SourceFixture:
+		return &FixtureSource{Data: SampleMenu}, nil
+	default:
+		return nil, fmt.Errorf("unknown menu source %q", kind)
+	}
+}
+
+func (s *Scraper) Name() string {
+	return fmt.Sprintf("scraper (%s)", s.BaseURL)
+}
+
+func (s *Scraper) Fetch(date models.Date, today models.Date) (MenuData, error) {
+	if date.SameDay(today) {
+		return s.Scrape(nil)
+	}
+	return s.Scrape(&date)
+}
+
+// CommandSource runs an external command that prints MenuData as JSON to
+// stdout, e.g. the legacy Python/Selenium scraper
+type CommandSource struct {
+	Command string
+	Args    []string
+}
+
+// NewCommandSource creates a source that runs the legacy Python scraper
+func NewCommandSource() *CommandSource {
+	return &CommandSource{
+		Command: "scraper/.venv/bin/python3",
+		Args:    []string{"scraper/scraper.py"},
+	}
+}
+
+func (s *CommandSource) Name() string {
+	return strings.Join(append([]string{s.Command}, s.Args...), " ")
+}
+
+// The legacy scraper can only load the current menus
+func (s *CommandSource) Fetch(date models.Date, today models.Date) (MenuData, error) {
+	if !date.SameDay(today) {
+		return nil, ErrDateUnavailable
+	}
+
+	cmd := exec.Command(s.Command, s.Args...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("scraper failed: %w\noutput:\n%s", err, out)
+	}
+
+	var data MenuData
+	if err := json.Unmarshal(out, &data); err != nil {
+		return nil, fmt.Errorf("invalid json from scraper: %w", err)
+	}
+	return data, nil
+}
+
+// FileSource reads MenuData JSON from disk. Path can be a single file or a
+// directory. A file named YYYY-MM-DD.json holds the menus for that date. Any
+// other JSON files hold today's menus, and if a directory has several of
+// them they are merged in name order (halls in later files replace the same
+// halls in earlier files).
+type FileSource struct {
+	Path string
+}
+
+func (s *FileSource) Name() string {
+	return "file " + s.Path
+}
+
+func (s *FileSource) Fetch(date models.Date, today models.Date) (MenuData, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		fileDate, dated := snapshotDate(s.Path)
+		if (dated && !fileDate.SameDay(date)) || (!dated && !date.SameDay(today)) {
+			return nil, ErrDateUnavailable
+		}
+		return readMenuFile(s.Path)
+	}
+
+	// A snapshot for exactly this date wins
+	datedFile := filepath.Join(s.Path, date.String()+".json")
+	if _, err := os.Stat(datedFile); err == nil {
+		return readMenuFile(datedFile)
+	}
+
+	if !date.SameDay(today) {
+		return nil, ErrDateUnavailable
+	}
+
+	files, err := filepath.Glob(filepath.Join(s.Path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	data := MenuData{}
+	found := false
+	for _, file := range files {
+		if _, dated := snapshotDate(file); dated {
+			continue // menus for some other day
+		}
+		fileData, err := readMenuFile(file)
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for hall, periods := range fileData {
+			data[hall] = periods
+		}
+	}
+	if !found {
+		return nil, ErrDateUnavailable
+	}
+	return data, nil
+}
+
+// Returns the date of a snapshot file named YYYY-MM-DD.json
+func snapshotDate(path string) (models.Date, bool) {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	date, err := models.ParseDate(name)
+	return date, err == nil
+}
+
+func readMenuFile(path string) (MenuData, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var data MenuData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("invalid menu json in %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// FixtureSource returns fixed menus, useful for tests and local development.
+// Data is returned for today, Dates holds menus for other days keyed by YYYY-MM-DD.
+type FixtureSource struct {
+	Data  MenuData
+	Dates map[string]MenuData
+}
+
+func (s *FixtureSource) Name() string {
+	return "fixture"
+}
+
+func (s *FixtureSource) Fetch(date models.Date, today models.Date) (MenuData, error) {
+	if data, ok := s.Dates[date.String()]; ok {
+		return data, nil
+	}
+	if date.SameDay(today) && s.Data != nil {
+		return s.Data, nil
+	}
+	return nil, ErrDateUnavailable
+}
+
+// SampleMenu is a small menu used by the fixture source when seeding a dev database
+var SampleMenu = MenuData{
+	"bruin-plate": {
+		"BREAKFAST": {
+			{Name: "Harvest", Items: []MenuItem{
+				{Name: "Scrambled Eggs", Tags: []string{models.TagVegetarian}, Allergens: []string{models.AllergenEggs}},
+				{Name: "Scrambled Egg Whites", Tags: []string{models.TagVegetarian}, Allergens: []string{models.AllergenEggs}},
+				{Name: "Vegan Scrambled Eggs", Tags: []string{models.TagVegan}, Allergens: []string{models.AllergenSoy}},
+				{Name: "Roasted Red Breakfast Potato Wedges", Tags: []string{models.TagVegan}},
+			}},
+		},
+		"LUNCH": {
+			{Name: "Harvest", Items: menuItems("Chicken Tikka Masala", "Basmati Rice")},
+			{Name: "Freshly Bowled", Items: []MenuItem{
+				{Name: "Kale Caesar Salad", Tags: []string{models.TagVegetarian}, Allergens: []string{models.AllergenDairy, models.AllergenEggs}},
+			}},
+		},
+		"DINNER": {
+			{Name: "Harvest", Items: menuItems("Miso Glazed Salmon", "Brown Rice")},
+			{Name: "Stone Oven", Items: menuItems("Margherita Flatbread")},
+		},
+	},
+	"de-neve-dining": {
+		"LUNCH": {
+			{Name: "The Kitchen", Items: menuItems("Garlic Noodles", "Orange Chicken")},
+			{Name: "The Grill", Items: menuItems("Classic Cheeseburger", "French Fries")},
+		},
+		"DINNER": {
+			{Name: "The Kitchen", Items: menuItems("Beef Bulgogi", "Steamed Rice")},
+			{Name: "Pizzeria", Items: menuItems("Pepperoni Pizza", "Cheese Pizza")},
+		},
+	},
+	"bruin-cafe": {
+		"BREAKFAST": {
+			{Name: "Bakery", Items: menuItems("Blueberry Muffin", "Croissant")},
+			{Name: "Sandwiches", Items: menuItems("Turkey Pesto Panini")},
+		},
+	},
+}
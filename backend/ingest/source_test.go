@@ -0,0 +1,103 @@
+package ingest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gsonntag/bruinbite/models"
+)
+
+func mustDate(t *testing.T, s string) models.Date {
+	t.Helper()
+	date, err := models.ParseDate(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return date
+}
+
+var (
+	datedMenu = MenuData{
+		"bruin-plate": {"LUNCH": {{Name: "Harvest", Items: []MenuItem{{Name: "Chicken Tikka Masala"}, {Name: "Basmati Rice", Tags: []string{"vegan"}}}}}},
+	}
+	mainMenu = MenuData{
+		"bruin-plate":    {"DINNER": {{Name: "Harvest", Items: menuItems("Miso Glazed Salmon")}}},
+		"de-neve-dining": {"DINNER": {{Name: "The Kitchen", Items: menuItems("Beef Bulgogi")}}},
+	}
+	mergedMenu = MenuData{
+		"bruin-plate":    {"DINNER": {{Name: "Harvest", Items: menuItems("Miso Glazed Salmon")}}},
+		"de-neve-dining": {"DINNER": {{Name: "Pizzeria", Items: menuItems("Cheese Pizza")}}},
+	}
+)
+
+type sourceTest struct {
+	name    string
+	source  MenuSource
+	date    string
+	today   string
+	want    MenuData
+	wantErr error // nil means no error, errAny means any error
+}
+
+var errAny = errors.New("any error")
+
+func runSourceTests(t *testing.T, tests []sourceTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.source.Fetch(mustDate(t, tt.date), mustDate(t, tt.today))
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatalf("Fetch() returned no error")
+				}
+				return
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Fetch() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if !reflect.DeepEqual(data, tt.want) {
+				t.Errorf("Fetch() =\n%#v\nwant\n%#v", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	runSourceTests(t, []sourceTest{
+		{"dated file on its date", &FileSource{Path: "testdata/menus/2024-05-01.json"}, "2024-05-01", "2024-05-03", datedMenu, nil},
+		{"dated file on another date", &FileSource{Path: "testdata/menus/2024-05-01.json"}, "2024-05-02", "2024-05-02", nil, ErrDateUnavailable},
+		{"undated file today", &FileSource{Path: "testdata/menus/a-main.json"}, "2024-05-03", "2024-05-03", mainMenu, nil},
+		{"undated file another day", &FileSource{Path: "testdata/menus/a-main.json"}, "2024-05-02", "2024-05-03", nil, ErrDateUnavailable},
+		{"missing file", &FileSource{Path: "testdata/menus/missing.json"}, "2024-05-03", "2024-05-03", nil, errAny},
+		{"directory with a snapshot for the date", &FileSource{Path: "testdata/menus"}, "2024-05-01", "2024-05-01", datedMenu, nil},
+		{"directory merges undated files today", &FileSource{Path: "testdata/menus"}, "2024-05-03", "2024-05-03", mergedMenu, nil},
+		{"directory without a snapshot for the date", &FileSource{Path: "testdata/menus"}, "2024-05-02", "2024-05-03", nil, ErrDateUnavailable},
+		{"directory without menus", &FileSource{Path: "testdata"}, "2024-05-03", "2024-05-03", nil, ErrDateUnavailable},
+	})
+}
+
+func TestFixtureSource(t *testing.T) {
+	source := &FixtureSource{Data: mainMenu, Dates: map[string]MenuData{"2024-05-01": datedMenu}}
+	runSourceTests(t, []sourceTest{
+		{"today", source, "2024-05-03", "2024-05-03", mainMenu, nil},
+		{"dated menus", source, "2024-05-01", "2024-05-03", datedMenu, nil},
+		{"dated menus win over today", source, "2024-05-01", "2024-05-01", datedMenu, nil},
+		{"other day", source, "2024-05-02", "2024-05-03", nil, ErrDateUnavailable},
+		{"no menus for today", &FixtureSource{}, "2024-05-03", "2024-05-03", nil, ErrDateUnavailable},
+	})
+}
+
+func TestCommandSource(t *testing.T) {
+	runSourceTests(t, []sourceTest{
+		{"today", &CommandSource{Command: "sh", Args: []string{"testdata/stub_scraper.sh"}}, "2024-05-03", "2024-05-03", mainMenu, nil},
+		{"other day", &CommandSource{Command: "sh", Args: []string{"testdata/stub_scraper.sh"}}, "2024-05-02", "2024-05-03", nil, ErrDateUnavailable},
+		{"command fails", &CommandSource{Command: "sh", Args: []string{"-c", "exit 1"}}, "2024-05-03", "2024-05-03", nil, errAny},
+		{"invalid json", &CommandSource{Command: "sh", Args: []string{"-c", "echo not json"}}, "2024-05-03", "2024-05-03", nil, errAny},
+	})
+}
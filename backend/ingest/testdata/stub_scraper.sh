@@ -0,0 +1,3 @@
+#!/bin/sh
+# Stands in for the legacy scraper: prints a captured menu to stdout
+cat "$(dirname "$0")/menus/a-main.json"
@@ -12,8 +12,13 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gsonntag/bruinbite/db"
+	"github.com/gsonntag/bruinbite/email"
+	"github.com/gsonntag/bruinbite/events"
 	"github.com/gsonntag/bruinbite/handlers"
 	"github.com/gsonntag/bruinbite/ingest"
+	"github.com/gsonntag/bruinbite/models"
+	"github.com/gsonntag/bruinbite/notify"
+	"github.com/gsonntag/bruinbite/schedule"
 	"github.com/gsonntag/bruinbite/search"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
@@ -27,6 +32,12 @@ var (
 	SearchManager     *search.BleveSearchManager
 	UserSearchManager *search.BleveUserSearchManager
 	Indexer           *search.Indexer
+	IngestScheduler   *schedule.Scheduler
+	DigestScheduler   *schedule.Scheduler
+	Mailer            *email.Mailer
+	Notifier          notify.Notifier
+	EventHub          = events.NewHub()
+	StreamTickets     = events.NewTickets()
 )
 
 func InitializeDatabase() error {
@@ -44,12 +55,45 @@ func InitializeDatabase() error {
 	if DBManager, err = db.NewDBManager(database); err != nil {
 		return err
 	}
-	return DBManager.Migrate()
+	DBManager.Events = EventHub
+	if err := DBManager.Migrate(); err != nil {
+		return err
+	}
+	if err := DBManager.SeedHalls(models.DefaultHalls); err != nil {
+		return err
+	}
+	// Give dishes from before recipes existed a recipe, merging duplicates
+	merged, err := DBManager.NormalizeDishes()
+	if merged > 0 {
+		fmt.Printf("Merged %d duplicate dishes\n", merged)
+	}
+	if err != nil {
+		return err
+	}
+	// Fill in stations and first seen dates for menus loaded before they were recorded
+	if err := DBManager.BackfillMenuStations(); err != nil {
+		return err
+	}
+	if err := DBManager.BackfillFirstSeenDates(); err != nil {
+		return err
+	}
+	// Ratings, favorites and friendships from before the feed existed show up in it
+	if err := DBManager.BackfillActivities(); err != nil {
+		return err
+	}
+	// Halls from before hours existed get the default hours
+	if err := ingest.BackfillHallHours(DBManager); err != nil {
+		return err
+	}
+	// Users flagged as admins before roles existed keep admin access
+	return DBManager.BackfillUserRoles()
 }
 
 func RegisterRoutes(router *gin.Engine) {
 
 	frontendUrl := os.Getenv("FRONTEND_URL")
+	// Only users with a verified email can rate when this is on
+	requireVerifiedEmail := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
 	// CORS is necessary so that frontend can communicate with backend.
 	// Otherwise, it will be viewed as a cross-origin request and will be blocked.
 	router.Use(cors.New(cors.Config{
@@ -67,25 +111,61 @@ func RegisterRoutes(router *gin.Engine) {
 	})
 
 	// Register auth routes
-	router.POST("/signup", handlers.SignupHandler(DBManager))
+	router.POST("/signup", handlers.SignupHandler(DBManager, Mailer))
 	router.POST("/login", handlers.LoginHandler(DBManager))
 
+	// Swaps a refresh token for new tokens, expecting body params: refresh_token
+	router.POST("/refresh", handlers.RefreshHandler(DBManager))
+
+	// Logs out this device, or every device
+	router.POST("/logout",
+		handlers.AuthMiddleware(DBManager),
+		handlers.LogoutHandler(DBManager))
+	router.POST("/logout-all",
+		handlers.AuthMiddleware(DBManager),
+		handlers.LogoutAllHandler(DBManager))
+
+	// Devices the user is logged in on, each can be logged out
+	router.GET("/sessions",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetSessionsHandler(DBManager))
+	router.DELETE("/sessions/:id",
+		handlers.AuthMiddleware(DBManager),
+		handlers.RevokeSessionHandler(DBManager))
+
+	// Email verification, the link in the email leads to a page that posts its token
+	// e.g. {"token": "..."}
+	router.POST("/verify-email/request",
+		handlers.AuthMiddleware(DBManager),
+		handlers.RequestEmailVerificationHandler(DBManager, Mailer))
+	router.POST("/verify-email/confirm",
+		handlers.VerifyEmailHandler(DBManager))
+
+	// Password reset, expecting body params: email, then token and password
+	// e.g. {"token": "...", "password": "new password"}
+	router.POST("/password-reset/request",
+		handlers.RequestPasswordResetHandler(DBManager, Mailer))
+	router.POST("/password-reset/confirm",
+		handlers.ConfirmPasswordResetHandler(DBManager))
+
 	router.GET("/protected",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.ProtectedHandler(DBManager))
 
 	// Register user info route
 	router.GET("/userinfo",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetCurUserInfoHandler(DBManager))
 
 	// Register user info by username route
+	// Signed in viewers may see more depending on the user's privacy settings
 	router.GET("/user/:username",
+		handlers.OptionalAuthMiddleware(DBManager),
 		handlers.GetUserInfoHandler(DBManager))
 
 	// Register hall dishes route
 	router.GET("/allhalldishes",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetAllHallDishesHandler(DBManager))
 
 	// Legacy search route (SQL-based)
@@ -94,115 +174,348 @@ func RegisterRoutes(router *gin.Engine) {
 
 	// Bleve search route (for enhanced search with fuzzy matching, etc.)
 	router.GET("/search",
+		handlers.OptionalAuthMiddleware(DBManager),
 		handlers.BleveSearchHandler(DBManager, SearchManager))
 
+	// Admin endpoints need a logged in user with the role each one asks for,
+	// moderators can fix up dishes and add hours exceptions, everything else is admin only
+	admin := router.Group("/admin", handlers.AuthMiddleware(DBManager))
+
+	// Admin endpoint to grant or revoke a user's role, expecting body params: role
+	// e.g. {"role": "moderator"}
+	admin.PUT("/users/:id/role",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
+		handlers.SetUserRoleHandler(DBManager))
+
 	// Admin endpoint to manually trigger reindexing
-	router.POST("/admin/reindex",
+	admin.POST("/reindex",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
 		handlers.ReindexHandler(Indexer, DBManager, UserSearchManager))
 
 	// Admin endpoint to manually trigger user reindexing only (useful after profile updates)
-	router.POST("/admin/reindex-users",
+	admin.POST("/reindex-users",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
 		handlers.ReindexUsersHandler(DBManager, UserSearchManager))
 
+	// Admin endpoint to manually trigger a menu ingest run
+	admin.POST("/ingest",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
+		handlers.TriggerIngestHandler(IngestScheduler))
+
+	// Admin endpoints to fix up duplicate dishes, moving ratings and menu appearances
+	// expecting body params: source_dish_id, target_dish_id
+	admin.POST("/dishes/merge",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleModerator),
+		handlers.MergeDishesHandler(DBManager, Indexer))
+	// expecting path param: id and body params: name, menu_ids, rating_ids, separate_recipe
+	admin.POST("/dishes/:id/split",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleModerator),
+		handlers.SplitDishHandler(DBManager, Indexer))
+	// expecting body params: source_recipe_id, target_recipe_id
+	admin.POST("/recipes/merge",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleModerator),
+		handlers.MergeRecipesHandler(DBManager))
+
+	// Admin endpoints to add and edit halls
+	// expecting body params: slug (only when adding), display_name, description, latitude, longitude, image_url, hall_type
+	// e.g. {"slug": "the-study-at-hedrick", "display_name": "The Study at Hedrick", "hall_type": "residential"}
+	admin.POST("/halls",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
+		handlers.CreateHallHandler(DBManager))
+	admin.PUT("/halls/:slug",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
+		handlers.UpdateHallHandler(DBManager))
+
+	// Admin endpoints to change hall hours
+	// expecting a body like [{"day_of_week": 1, "meal_period": "LUNCH", "opens": "11:00", "closes": "15:00"}]
+	admin.PUT("/halls/:slug/hours",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
+		handlers.SetHallHoursHandler(DBManager))
+	// expecting body params: date, meal_period (optional), opens, closes, closed, note
+	// e.g. {"date": "2025-11-27", "closed": true, "note": "Thanksgiving"}
+	admin.POST("/halls/:slug/hours/overrides",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleModerator),
+		handlers.AddHallHoursOverrideHandler(DBManager))
+	admin.DELETE("/halls/:slug/hours/overrides/:id",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleModerator),
+		handlers.DeleteHallHoursOverrideHandler(DBManager))
+
+	// Admin endpoint to send today's email digest now (users already sent it are skipped)
+	admin.POST("/digest",
+		handlers.RequireRoleMiddleware(DBManager, models.RoleAdmin),
+		handlers.TriggerDigestHandler(DigestScheduler))
+
+	// Shows when menus were last loaded and when the next load is scheduled
+	router.GET("/ingest/status",
+		handlers.IngestStatusHandler(IngestScheduler))
+
 	// Register ratings route
 	// expecting body params: dish_id, rating, comment (optional)
 	// e.g. {"dish_id": 1, "rating": 4.5, "comment": "Great dish!"}
 	router.POST("/ratings",
-		handlers.AuthMiddleware(),
-		handlers.SubmitRatingHandler(DBManager))
+		handlers.AuthMiddleware(DBManager),
+		handlers.RequireVerifiedEmailMiddleware(DBManager, requireVerifiedEmail),
+		handlers.SubmitRatingHandler(DBManager, Notifier))
+
+	// Replies to a rating's comment, expecting path param: id and body params: comment
+	// e.g. {"comment": "Agreed, the sauce is great"}
+	router.GET("/ratings/:id/replies",
+		handlers.OptionalAuthMiddleware(DBManager),
+		handlers.GetRatingRepliesHandler(DBManager))
+	router.POST("/ratings/:id/replies",
+		handlers.AuthMiddleware(DBManager),
+		handlers.RequireVerifiedEmailMiddleware(DBManager, requireVerifiedEmail),
+		handlers.ReplyToRatingHandler(DBManager, Notifier))
 
 	// Get user ratings route
 	// expecting no params, will return all ratings made by the user
 	router.GET("/userratings",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetUserRatingsHandler(DBManager))
 
 	// Get friend ratings route
 	// expecting no params, will return all ratings made by the user's friends
 	router.GET("/friendratings",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetFriendRatingsHandler(DBManager))
 
+	// Friends' ratings, new friends, favorites and achievements, newest first
+	// optional query params: before (next_cursor from the last page), limit, hall, friend_id, type
+	// e.g. /feed?hall=de-neve-dining&limit=20
+	router.GET("/feed",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetFeedHandler(DBManager))
+
 	// get ratings for specific user
 	// expecting path param: username
 	router.GET("/user/:username/ratings",
+		handlers.OptionalAuthMiddleware(DBManager),
 		handlers.GetUserRatingsFromUsernameHandler(DBManager))
 
 	// Get dish ratings route
 	// expecting path param: dish_id
+	// optional query param scope=recipe returns ratings of the same recipe at every hall
 	router.GET("/dishratings",
+		handlers.OptionalAuthMiddleware(DBManager),
 		handlers.GetDishRatingsHandler(DBManager))
 
 	// Register menu route
 	// expecting query params: hall_id, day, month, year, meal_period
 	// e.g. /menu?hall_id=1&day=1&month=1&year=2023&meal_period=LUNCH
+	// logged in users get their dietary preferences applied unless use_preferences=false
+	// returns the menu along with its dishes grouped by station
 	router.GET("/menu",
+		handlers.OptionalAuthMiddleware(DBManager),
 		handlers.GetMenuHandler(DBManager))
 
+	// Compares a menu with the previous weeks: new, returning and dropped dishes
+	// expecting the same query params as /menu, plus optional weeks (default 4)
+	router.GET("/menu/diff",
+		handlers.OptionalAuthMiddleware(DBManager),
+		handlers.GetMenuDiffHandler(DBManager))
+
+	// Dishes served for the first time recently ("new on the menu")
+	// optional query params: hall_name, days (default 7), limit
+	router.GET("/menu/new",
+		handlers.OptionalAuthMiddleware(DBManager),
+		handlers.GetNewDishesHandler(DBManager))
+
 	// Gets all valid meal periods for a given date
 	router.GET("/hall-meal-periods",
 		handlers.GetHallMealPeriods(DBManager))
 
 	// Gets all valid meal periods for a given date
 	router.GET("/recommended",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetRecommendedHallForUser(DBManager))
 
 	// Get all dining halls with their ratings
 	router.GET("/dining-halls",
 		handlers.GetAllDiningHallsHandler(DBManager))
 
+	// Halls serving a meal period right now
+	router.GET("/halls/open-now",
+		handlers.OpenNowHandler(DBManager))
+
+	// Hall metadata (display name, description, coordinates, image, type)
+	router.GET("/halls/:slug",
+		handlers.GetHallHandler(DBManager))
+
+	// Hall hours, expecting path param: slug and optional query param: date (YYYY-MM-DD)
+	// e.g. /halls/bruin-plate/hours?date=2025-11-27
+	router.GET("/halls/:slug/hours",
+		handlers.GetHallHoursHandler(DBManager))
+
 	// Register friends routes
 	router.GET("/friends",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetFriendsHandler(DBManager))
 	router.GET("/out-friend-requests",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetOutgoingFriendRequestsHandler(DBManager))
 	router.GET("/in-friend-requests",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.GetIncomingFriendRequestsHandler(DBManager))
 
 	// Expecting body params: friend_id
 	// e.g. {"friend_id": 2}
 	router.POST("/send-friend-request",
-		handlers.AuthMiddleware(),
-		handlers.SendFriendRequestHandler(DBManager))
+		handlers.AuthMiddleware(DBManager),
+		handlers.SendFriendRequestHandler(DBManager, Notifier))
 
 	// Expecting body params: request_id
 	// e.g. {"request_id": 1}
 	router.POST("/accept-friend-request",
-		handlers.AuthMiddleware(),
-		handlers.AcceptFriendRequestHandler(DBManager))
+		handlers.AuthMiddleware(DBManager),
+		handlers.AcceptFriendRequestHandler(DBManager, Notifier))
 
 	// Expecting body params: request_id
 	router.POST("/decline-friend-request",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.DeclineFriendRequestHandler(DBManager))
 
+	// Takes back a friend request the user sent, expecting body params: request_id
+	router.POST("/cancel-friend-request",
+		handlers.AuthMiddleware(DBManager),
+		handlers.CancelFriendRequestHandler(DBManager))
+
+	// People the user may know or share a taste in food with
+	// optional query param limit (default 10)
+	router.GET("/friend-suggestions",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetFriendSuggestionsHandler(DBManager))
+
+	// Unfriends the user with the given id
+	router.DELETE("/friends/:id",
+		handlers.AuthMiddleware(DBManager),
+		handlers.RemoveFriendHandler(DBManager))
+
+	// Blocked users, expecting body params: user_id
+	// blocking also unfriends the user and closes pending friend requests
+	router.GET("/blocks",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetBlocksHandler(DBManager))
+	router.POST("/blocks",
+		handlers.AuthMiddleware(DBManager),
+		handlers.BlockUserHandler(DBManager))
+	router.DELETE("/blocks/:id",
+		handlers.AuthMiddleware(DBManager),
+		handlers.UnblockUserHandler(DBManager))
+
 	// dish info based on id
 	router.GET("/dish/:id",
 		handlers.GetDishDetailsHandler(DBManager))
 
+	// every date, hall and meal period a dish was served, with frequency stats
+	// and the next likely appearance, optional query params: weeks, scope=recipe
+	router.GET("/dish/:id/appearances",
+		handlers.GetDishAppearancesHandler(DBManager))
+
+	// recipe info (the same dish across halls) based on id
+	router.GET("/recipe/:id",
+		handlers.GetRecipeHandler(DBManager))
+
 	// Enhanced user search with fuzzy matching and partial search (Bleve-based)
 	router.GET("/search-users",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.BleveSearchUsersHandler(DBManager, UserSearchManager))
 
 	// Legacy user search (SQL-based) - keeping as fallback
 	router.GET("/sql-search-users",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.SearchUsersHandler(DBManager))
 
 	// Profile update routes
 	router.PUT("/profile",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.UpdateProfileHandler(DBManager, UserSearchManager))
 
 	router.POST("/profile/picture",
-		handlers.AuthMiddleware(),
+		handlers.AuthMiddleware(DBManager),
 		handlers.UploadProfilePictureHandler(DBManager, UserSearchManager))
 
+	// Dietary preferences, applied by default to menus, search and recommendations
+	// expecting body params: diet_type, avoid_allergens, disliked_ingredients, favorite_halls
+	// e.g. {"diet_type": "vegetarian", "avoid_allergens": ["peanuts"], "favorite_halls": ["de-neve-dining"]}
+	router.GET("/profile/preferences",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetPreferencesHandler(DBManager))
+	router.PUT("/profile/preferences",
+		handlers.AuthMiddleware(DBManager),
+		handlers.UpdatePreferencesHandler(DBManager))
+
+	// Opt in to emails, expecting body params: digest, notifications
+	// e.g. {"digest": true, "notifications": false}
+	router.GET("/profile/email-preferences",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetEmailPreferencesHandler(DBManager))
+	router.PUT("/profile/email-preferences",
+		handlers.AuthMiddleware(DBManager),
+		handlers.UpdateEmailPreferencesHandler(DBManager))
+
+	// Who can see the profile and ratings, expecting body params: profile_visibility, ratings_visibility, searchable_by_email
+	// e.g. {"profile_visibility": "public", "ratings_visibility": "friends", "searchable_by_email": false}
+	router.GET("/profile/privacy",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetPrivacySettingsHandler(DBManager))
+	router.PUT("/profile/privacy",
+		handlers.AuthMiddleware(DBManager),
+		handlers.UpdatePrivacySettingsHandler(DBManager))
+
+	// Unsubscribe links in emails, expecting query params: token and optional list (digest or notifications)
+	// GET only shows a confirmation page, POST (from it or mail clients' one-click unsubscribe) unsubscribes
+	router.GET("/unsubscribe",
+		handlers.UnsubscribePageHandler(DBManager))
+	router.POST("/unsubscribe",
+		handlers.UnsubscribeHandler(DBManager))
+
+	// Shows when the email digest was last sent and when it's next due
+	router.GET("/digest/status",
+		handlers.DigestStatusHandler(DigestScheduler))
+
+	// Favorite dishes, alerted on when they show up on a new menu
+	// expecting body params: dish_id or recipe_id (to follow the dish at every hall)
+	// e.g. {"recipe_id": 12}
+	router.GET("/favorites",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetFavoritesHandler(DBManager))
+	router.POST("/favorites",
+		handlers.AuthMiddleware(DBManager),
+		handlers.AddFavoriteHandler(DBManager))
+	router.DELETE("/favorites/:id",
+		handlers.AuthMiddleware(DBManager),
+		handlers.RemoveFavoriteHandler(DBManager))
+
+	// Real-time events (notifications, friends' ratings, new menus) as Server-Sent Events.
+	// EventSource can't set headers, so browsers get a single-use ticket from
+	// /events/ticket first and connect to /events?ticket=
+	router.POST("/events/ticket",
+		handlers.AuthMiddleware(DBManager),
+		handlers.StreamTicketHandler(StreamTickets))
+	router.GET("/events",
+		handlers.StreamAuthMiddleware(DBManager, StreamTickets),
+		handlers.EventsHandler(EventHub))
+
+	// In-app notifications, optional query params: unread, before (notification id), limit
+	router.GET("/notifications",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetNotificationsHandler(DBManager))
+	router.POST("/notifications/:id/read",
+		handlers.AuthMiddleware(DBManager),
+		handlers.MarkNotificationReadHandler(DBManager))
+	router.POST("/notifications/read-all",
+		handlers.AuthMiddleware(DBManager),
+		handlers.MarkAllNotificationsReadHandler(DBManager))
+
+	// Muted notification types, expecting body params: muted
+	// e.g. {"muted": ["friend_rated"]}
+	router.GET("/notifications/settings",
+		handlers.AuthMiddleware(DBManager),
+		handlers.GetNotificationSettingsHandler(DBManager))
+	router.PUT("/notifications/settings",
+		handlers.AuthMiddleware(DBManager),
+		handlers.UpdateNotificationSettingsHandler(DBManager))
+
 	// Static file serving for uploads
 	router.Static("/uploads", "./uploads")
 }
@@ -283,6 +596,12 @@ func IndexAllUsers() error {
 func main() {
 	// Parse command line flags
 	reindexFlag := flag.Bool("reindex", false, "Rebuild the search index")
+	menuSourceFlag := flag.String("menu-source", "", "Where to load menus from: scraper, command, file or fixture (defaults to $MENU_SOURCE, then scraper)")
+	menuPathFlag := flag.String("menu-path", "", "JSON file/directory for the file source, or command for the command source (defaults to $MENU_SOURCE_PATH)")
+	backfillFlag := flag.String("backfill", "", "Load every dated menu snapshot (YYYY-MM-DD.json) in this directory into the database, then exit")
+	ingestFromFlag := flag.String("ingest-from", "", "Load menus from the menu source starting at this date (YYYY-MM-DD), then exit")
+	ingestToFlag := flag.String("ingest-to", "", "Last date (YYYY-MM-DD) to load with -ingest-from, defaults to the same day")
+	grantAdminFlag := flag.String("grant-admin", "", "Give the user with this username the admin role, then exit (for setting up the first admin)")
 	flag.Parse()
 
 	// Load go dot env
@@ -297,6 +616,80 @@ func main() {
 		return
 	}
 
+	// The first admin has to be made from the command line, after that admins
+	// can grant roles with /admin/users/:id/role
+	if *grantAdminFlag != "" {
+		user, err := DBManager.GetUserByUsername(*grantAdminFlag)
+		if err != nil {
+			log.Fatalln("User not found", *grantAdminFlag, err)
+		}
+		if _, err := DBManager.SetUserRole(user.ID, models.RoleAdmin); err != nil {
+			log.Fatalln("Failed to grant admin role", err)
+		}
+		log.Printf("Granted admin role to %s (user %d)", user.Username, user.ID)
+		return
+	}
+
+	// Emails go through the backend picked in db.env (logged by default)
+	Mailer, err = email.NewMailerFromEnv(DBManager)
+	if err != nil {
+		log.Fatalln("Invalid email settings", err)
+		return
+	}
+
+	// Notifications are saved for users to see in the app, emailed to users
+	// who opted in, and logged
+	Notifier = notify.Multi{notify.Func(DBManager.CreateNotifications), Mailer, notify.LogNotifier{}}
+
+	// Pick the menu source, flags take priority over env
+	sourceKind := *menuSourceFlag
+	if sourceKind == "" {
+		sourceKind = os.Getenv("MENU_SOURCE")
+	}
+	sourcePath := *menuPathFlag
+	if sourcePath == "" {
+		sourcePath = os.Getenv("MENU_SOURCE_PATH")
+	}
+	menuSource, err := ingest.NewMenuSource(sourceKind, sourcePath)
+	if err != nil {
+		log.Fatalln("Invalid menu source", err)
+		return
+	}
+
+	// One-shot ingest modes, for seeding or importing archived menus.
+	// These run before search is initialized so they can be used while
+	// the server is running (the search index can only be opened once).
+	if *backfillFlag != "" {
+		reports, err := ingest.Backfill(DBManager, *backfillFlag)
+		for _, report := range reports {
+			log.Println("Backfilled", report)
+		}
+		if err != nil {
+			log.Fatalln("Backfill failed", err)
+		}
+		return
+	}
+	if *ingestFromFlag != "" {
+		from, err := models.ParseDate(*ingestFromFlag)
+		if err != nil {
+			log.Fatalln("Invalid -ingest-from date", err)
+		}
+		to := from
+		if *ingestToFlag != "" {
+			if to, err = models.ParseDate(*ingestToFlag); err != nil {
+				log.Fatalln("Invalid -ingest-to date", err)
+			}
+		}
+		reports, err := ingest.IngestRange(DBManager, menuSource, from, to)
+		for _, report := range reports {
+			log.Println("Ingested", report)
+		}
+		if err != nil {
+			log.Fatalln("Ingest failed", err)
+		}
+		return
+	}
+
 	// Initialize search system
 	forceReindex := *reindexFlag
 	err = InitializeSearch(forceReindex)
@@ -305,11 +698,22 @@ func main() {
 		return
 	}
 
-	// Fetch and ingest data if needed
-	err = ingest.FetchAndIngest(DBManager)
+	// Fetch and ingest menus in the background on a schedule, starting with
+	// an immediate run so the server doesn't wait on the scraper to start
+	IngestScheduler, err = ingest.NewScheduler(DBManager, menuSource, Notifier)
 	if err != nil {
-		fmt.Printf("ERR WITH SCRAPER: %v\n", err)
+		log.Fatalln("Invalid ingest schedule", err)
+		return
+	}
+	IngestScheduler.Start(true)
+
+	// Email the morning digest to users who opted in
+	DigestScheduler, err = email.NewDigestScheduler(Mailer)
+	if err != nil {
+		log.Fatalln("Invalid digest schedule", err)
+		return
 	}
+	DigestScheduler.Start(false)
 
 	err = InitializeRouter()
 	if err != nil {
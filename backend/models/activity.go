@@ -0,0 +1,48 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Activity types shown in the friends feed
+const (
+	ActivityRating      = "rating"      // rated a dish
+	ActivityFriendship  = "friendship"  // became friends with OtherUserID
+	ActivityFavorite    = "favorite"    // started following a dish or recipe
+	ActivityAchievement = "achievement" // reached a milestone, see RatingMilestones
+)
+
+// ActivityTypes lists every activity type, for filtering the feed
+var ActivityTypes = []string{ActivityRating, ActivityFriendship, ActivityFavorite, ActivityAchievement}
+
+// RatingMilestones are the rating counts that earn an achievement
+var RatingMilestones = []int{1, 10, 25, 50, 100, 250, 500}
+
+// Activity is something a user did that their friends see in their feed.
+// Activities are only ever appended: Data holds what the feed shows (dish
+// names, scores...) as it was at the time, so the feed is a single query.
+// The one exception is the IDs, which merging or splitting dishes and
+// recipes moves along with the ratings and favorites so the links still work.
+type Activity struct {
+	ID          uint            `gorm:"primaryKey;autoIncrement" json:"id"` // also the feed's cursor, newer activities have higher IDs
+	UserID      uint            `gorm:"not null;index" json:"user_id"`      // who did it
+	User        User            `gorm:"foreignKey:UserID" json:"user"`
+	Type        string          `gorm:"type:text;not null;index" json:"type"`
+	OtherUserID *uint           `gorm:"index" json:"other_user_id,omitempty"` // the new friend, for friendships
+	HallID      *uint           `gorm:"index" json:"hall_id,omitempty"`
+	DishID      *uint           `gorm:"index" json:"dish_id,omitempty"`
+	RecipeID    *uint           `gorm:"index" json:"recipe_id,omitempty"`
+	RatingID    *uint           `gorm:"index" json:"rating_id,omitempty"`
+	Data        json.RawMessage `gorm:"type:jsonb" json:"data,omitempty"` // e.g. dish_name, hall_name, score
+	CreatedAt   time.Time       `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+}
+
+// FeedQuery selects a page of a user's feed
+type FeedQuery struct {
+	Before   uint   // only activities older than this ID, for paging
+	Limit    int    // page size
+	HallID   uint   // only activities at this hall, if set
+	FriendID uint   // only this friend's activities, if set
+	Type     string // only this activity type, if set
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DishAppearance is one menu a dish was served on
+type DishAppearance struct {
+	DishID     uint    `json:"dish_id"`
+	Date       string  `json:"date"` // YYYY-MM-DD
+	Weekday    string  `json:"weekday"`
+	HallName   string  `json:"hall_name"`
+	MealPeriod string  `json:"meal_period"`
+	Opens      *string `json:"opens,omitempty"` // HH:MM the meal period opens at the hall on that weekday, if it has hours
+	Station    *string `json:"station,omitempty"`
+}
+
+// Usual opening times (minutes after midnight) of meal periods, for halls
+// without hours
+var usualPeriodStarts = map[int]int{1: 7 * 60, 2: 11 * 60, 3: 17 * 60}
+
+// Returns when the appearance's meal period starts in minutes after
+// midnight, so meal periods can be put in order through the day. The hall's
+// hours are used if it has them, otherwise the usual time for the period.
+// Unknown periods come first.
+func (a DishAppearance) periodStart() int {
+	if a.Opens != nil {
+		if opens, err := ParseClock(*a.Opens); err == nil {
+			return opens
+		}
+	}
+	return usualPeriodStarts[MealPeriodRank(a.MealPeriod)]
+}
+
+// SortAppearances puts appearances in the order they were served: by date,
+// then by when the meal period starts, then by hall
+func SortAppearances(appearances []DishAppearance) {
+	sort.SliceStable(appearances, func(i, j int) bool {
+		a, b := appearances[i], appearances[j]
+		if a.Date != b.Date {
+			return a.Date < b.Date // YYYY-MM-DD sorts by date
+		}
+		if a.periodStart() != b.periodStart() {
+			return a.periodStart() < b.periodStart()
+		}
+		return a.HallName < b.HallName
+	})
+}
+
+// AppearanceStats summarizes how often a dish is served
+type AppearanceStats struct {
+	TotalAppearances int             `json:"total_appearances"`
+	FirstSeen        string          `json:"first_seen,omitempty"`
+	LastSeen         string          `json:"last_seen,omitempty"`
+	Weeks            int             `json:"weeks"`    // how many weeks back the frequency stats look
+	PerWeek          float64         `json:"per_week"` // days per week it was served, over those weeks
+	UsualWeekday     string          `json:"usual_weekday,omitempty"`
+	UsualMealPeriod  string          `json:"usual_meal_period,omitempty"`
+	Summary          string          `json:"summary"` // e.g. "served 3x/week, usually Tuesday dinner"
+	Next             *NextAppearance `json:"next_appearance,omitempty"`
+}
+
+// NextAppearance is when a dish is expected to be served next
+type NextAppearance struct {
+	Date        string  `json:"date"`
+	MealPeriod  string  `json:"meal_period"`
+	HallName    string  `json:"hall_name"`
+	Basis       string  `json:"basis"`       // "menu" if it's on a menu that's already posted, "pattern" if predicted
+	Probability float64 `json:"probability"` // 1 for posted menus, otherwise how often it was served on that weekday
+}
+
+// ComputeAppearanceStats works out frequency stats and the next likely
+// appearance from a dish's appearances (in SortAppearances order). Frequencies only
+// look at the given number of weeks before today; posted menus after today
+// are used as the next appearance.
+func ComputeAppearanceStats(appearances []DishAppearance, today Date, weeks int, loc *time.Location) AppearanceStats {
+	stats := AppearanceStats{TotalAppearances: len(appearances), Weeks: weeks}
+	if len(appearances) == 0 {
+		stats.Summary = "never served"
+		return stats
+	}
+	stats.FirstSeen = appearances[0].Date
+	stats.LastSeen = appearances[len(appearances)-1].Date
+
+	todayTime := today.Time(loc)
+	windowStart := todayTime.AddDate(0, 0, -7*weeks)
+
+	// Only count each day once for the per week frequency
+	days := make(map[string]bool)
+	type slot struct{ weekday, period, hall string }
+	slotCounts := make(map[slot]int)
+	slotStarts := make(map[slot]int)                 // when the slot's meal period starts, for ordering
+	weekdayWeeks := make(map[string]map[string]bool) // weekday -> weeks (by start date) it was served on that day
+	firstInWindow := time.Time{}
+
+	for _, a := range appearances {
+		date, err := ParseDate(a.Date)
+		if err != nil {
+			continue
+		}
+		t := date.Time(loc)
+
+		if !t.Before(todayTime) && stats.Next == nil {
+			stats.Next = &NextAppearance{Date: a.Date, MealPeriod: a.MealPeriod, HallName: a.HallName, Basis: "menu", Probability: 1}
+		}
+		if t.Before(windowStart) || !t.Before(todayTime) {
+			continue
+		}
+		if firstInWindow.IsZero() {
+			firstInWindow = t
+		}
+
+		days[a.Date] = true
+		slotCounts[slot{a.Weekday, a.MealPeriod, a.HallName}]++
+		slotStarts[slot{a.Weekday, a.MealPeriod, a.HallName}] = a.periodStart()
+		if weekdayWeeks[a.Weekday] == nil {
+			weekdayWeeks[a.Weekday] = make(map[string]bool)
+		}
+		weekStart := t.AddDate(0, 0, -int(t.Weekday()))
+		weekdayWeeks[a.Weekday][weekStart.Format(time.DateOnly)] = true
+	}
+
+	if len(days) == 0 {
+		stats.Summary = fmt.Sprintf("not served in the last %d weeks, last seen %s", weeks, stats.LastSeen)
+		return stats
+	}
+
+	// Dishes that only started being served recently shouldn't look rarer than they are
+	observedWeeks := float64(weeks)
+	if sinceFirst := todayTime.Sub(firstInWindow).Hours() / (24 * 7); sinceFirst < observedWeeks {
+		observedWeeks = max(sinceFirst, 1)
+	}
+	stats.PerWeek = float64(int(float64(len(days))/observedWeeks*10)) / 10
+
+	// The most common weekday/meal period, ties going to the earliest in the week (and day)
+	slots := make([]slot, 0, len(slotCounts))
+	for s := range slotCounts {
+		slots = append(slots, s)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		if slotCounts[slots[i]] != slotCounts[slots[j]] {
+			return slotCounts[slots[i]] > slotCounts[slots[j]]
+		}
+		if slots[i].weekday != slots[j].weekday {
+			return weekdayNumber(slots[i].weekday) < weekdayNumber(slots[j].weekday)
+		}
+		if slotStarts[slots[i]] != slotStarts[slots[j]] {
+			return slotStarts[slots[i]] < slotStarts[slots[j]]
+		}
+		if slots[i].period != slots[j].period {
+			return slots[i].period < slots[j].period
+		}
+		return slots[i].hall < slots[j].hall
+	})
+	usual := slots[0]
+	stats.UsualWeekday = usual.weekday
+	stats.UsualMealPeriod = usual.period
+	stats.Summary = fmt.Sprintf("served %gx/week, usually %s %s", stats.PerWeek, usual.weekday, MealPeriodWord(usual.period))
+
+	if stats.Next == nil {
+		// Predict the next time it's that weekday
+		next := todayTime.AddDate(0, 0, 1)
+		for next.Weekday().String() != usual.weekday {
+			next = next.AddDate(0, 0, 1)
+		}
+		stats.Next = &NextAppearance{
+			Date:        DateOf(next).String(),
+			MealPeriod:  usual.period,
+			HallName:    usual.hall,
+			Basis:       "pattern",
+			Probability: min(float64(int(float64(len(weekdayWeeks[usual.weekday]))/observedWeeks*100))/100, 1),
+		}
+	}
+	return stats
+}
+
+func weekdayNumber(name string) int {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if day.String() == name {
+			return int(day)
+		}
+	}
+	return 7
+}
+
+// MenuDiff is how a menu compares to the same hall and meal period over the previous weeks
+type MenuDiff struct {
+	Date       string     `json:"date"`
+	MealPeriod string     `json:"meal_period"`
+	Weeks      int        `json:"weeks"`
+	New        []DiffDish `json:"new"`       // never served before
+	Returning  []DiffDish `json:"returning"` // back after not being served for all those weeks
+	Dropped    []DiffDish `json:"dropped"`   // usually served on this weekday, but not this time
+}
+
+// DiffDish is a dish in a MenuDiff
+type DiffDish struct {
+	Dish
+	LastServed *string `json:"last_served,omitempty"` // YYYY-MM-DD, before the menu's date
+}
@@ -0,0 +1,127 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func appearance(date string, period string, hall string, opens string) DishAppearance {
+	d, err := ParseDate(date)
+	if err != nil {
+		panic(err)
+	}
+	a := DishAppearance{Date: date, Weekday: d.Time(time.UTC).Weekday().String(), HallName: hall, MealPeriod: period}
+	if opens != "" {
+		a.Opens = &opens
+	}
+	return a
+}
+
+func TestSortAppearances(t *testing.T) {
+	appearances := []DishAppearance{
+		appearance("2024-05-15", "DINNER", "bruin-plate", ""),
+		appearance("2024-05-15", "LUNCH", "de-neve-dining", ""),
+		appearance("2024-05-15", "LUNCH", "bruin-plate", ""),
+		appearance("2024-05-15", "ALL_DAY", "bruin-cafe", "10:00"),
+		appearance("2024-05-15", "BREAKFAST", "bruin-plate", "07:00"),
+		appearance("2024-05-14", "DINNER", "bruin-plate", ""),
+	}
+	SortAppearances(appearances)
+
+	var got []string
+	for _, a := range appearances {
+		got = append(got, a.Date+" "+a.MealPeriod+" "+a.HallName)
+	}
+	want := []string{
+		"2024-05-14 DINNER bruin-plate",
+		"2024-05-15 BREAKFAST bruin-plate",
+		"2024-05-15 ALL_DAY bruin-cafe",
+		"2024-05-15 LUNCH bruin-plate",
+		"2024-05-15 LUNCH de-neve-dining",
+		"2024-05-15 DINNER bruin-plate",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortAppearances() =\n%v\nwant\n%v", got, want)
+	}
+}
+
+func TestComputeAppearanceStats(t *testing.T) {
+	today, _ := ParseDate("2024-05-15") // a Wednesday
+
+	tests := []struct {
+		name        string
+		appearances []DishAppearance
+		wantSummary string
+		wantNext    *NextAppearance
+	}{
+		{
+			name:        "never served",
+			wantSummary: "never served",
+		},
+		{
+			name:        "not served recently",
+			appearances: []DishAppearance{appearance("2024-01-02", "LUNCH", "bruin-plate", "")},
+			wantSummary: "not served in the last 4 weeks, last seen 2024-01-02",
+		},
+		{
+			// LUNCH comes before DINNER in the day even though it doesn't alphabetically
+			name: "ties go to the earlier meal period",
+			appearances: []DishAppearance{
+				appearance("2024-05-07", "LUNCH", "bruin-plate", ""),
+				appearance("2024-05-07", "DINNER", "bruin-plate", ""),
+				appearance("2024-05-14", "LUNCH", "bruin-plate", ""),
+				appearance("2024-05-14", "DINNER", "bruin-plate", ""),
+			},
+			wantSummary: "served 1.7x/week, usually Tuesday lunch",
+			wantNext:    &NextAppearance{Date: "2024-05-21", MealPeriod: "LUNCH", HallName: "bruin-plate", Basis: "pattern", Probability: 1},
+		},
+		{
+			// The hall's hours put its breakfast before bruin-cafe's all day menu
+			name: "ties use the hall's hours",
+			appearances: []DishAppearance{
+				appearance("2024-05-07", "BREAKFAST", "bruin-plate", "07:00"),
+				appearance("2024-05-07", "ALL_DAY", "bruin-cafe", "10:00"),
+			},
+			wantSummary: "served 0.8x/week, usually Tuesday breakfast",
+			wantNext:    &NextAppearance{Date: "2024-05-21", MealPeriod: "BREAKFAST", HallName: "bruin-plate", Basis: "pattern", Probability: 0.87},
+		},
+		{
+			name: "most common slot wins",
+			appearances: []DishAppearance{
+				appearance("2024-04-22", "DINNER", "de-neve-dining", ""),
+				appearance("2024-04-26", "LUNCH", "bruin-plate", ""),
+				appearance("2024-04-29", "DINNER", "de-neve-dining", ""),
+				appearance("2024-05-06", "DINNER", "de-neve-dining", ""),
+				appearance("2024-05-13", "DINNER", "de-neve-dining", ""),
+			},
+			wantSummary: "served 1.5x/week, usually Monday dinner",
+			wantNext:    &NextAppearance{Date: "2024-05-20", MealPeriod: "DINNER", HallName: "de-neve-dining", Basis: "pattern", Probability: 1},
+		},
+		{
+			name: "posted menus are the next appearance",
+			appearances: []DishAppearance{
+				appearance("2024-05-08", "DINNER", "bruin-plate", ""),
+				appearance("2024-05-15", "LUNCH", "bruin-plate", ""),
+				appearance("2024-05-15", "DINNER", "bruin-plate", ""),
+			},
+			wantSummary: "served 1x/week, usually Wednesday dinner",
+			wantNext:    &NextAppearance{Date: "2024-05-15", MealPeriod: "LUNCH", HallName: "bruin-plate", Basis: "menu", Probability: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := ComputeAppearanceStats(tt.appearances, today, 4, time.UTC)
+			if stats.TotalAppearances != len(tt.appearances) {
+				t.Errorf("TotalAppearances = %d, want %d", stats.TotalAppearances, len(tt.appearances))
+			}
+			if stats.Summary != tt.wantSummary {
+				t.Errorf("Summary = %q, want %q", stats.Summary, tt.wantSummary)
+			}
+			if !reflect.DeepEqual(stats.Next, tt.wantNext) {
+				t.Errorf("Next = %+v, want %+v", stats.Next, tt.wantNext)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Dietary tags stored in Dish.Tags
+const (
+	TagVegan      = "vegan"
+	TagVegetarian = "vegetarian"
+	TagHalal      = "halal"
+	TagLowCarbon  = "low-carbon"
+	TagHighCarbon = "high-carbon"
+)
+
+// Allergens stored in DishNutrition.Allergens
+const (
+	AllergenGluten    = "gluten"
+	AllergenWheat     = "wheat"
+	AllergenDairy     = "dairy"
+	AllergenEggs      = "eggs"
+	AllergenSoy       = "soy"
+	AllergenPeanuts   = "peanuts"
+	AllergenTreeNuts  = "tree-nuts"
+	AllergenFish      = "fish"
+	AllergenShellfish = "shellfish"
+	AllergenSesame    = "sesame"
+	AllergenAlcohol   = "alcohol"
+)
+
+// KnownAllergens lists every allergen ingest can recognize
+var KnownAllergens = []string{
+	AllergenGluten, AllergenWheat, AllergenDairy, AllergenEggs, AllergenSoy, AllergenPeanuts,
+	AllergenTreeNuts, AllergenFish, AllergenShellfish, AllergenSesame, AllergenAlcohol,
+}
+
+// Diet types a user can pick in their preferences
+const (
+	DietNone       = ""
+	DietVegetarian = "vegetarian"
+	DietVegan      = "vegan"
+	DietHalal      = "halal"
+)
+
+// DishNutrition holds the nutrition facts and allergens of a dish, taken from
+// the dish's recipe card and nutrition page on the dining website
+type DishNutrition struct {
+	ID           uint           `gorm:"primaryKey;autoIncrement" json:"-"`
+	DishID       uint           `gorm:"not null;uniqueIndex" json:"dish_id"`
+	ServingSize  *string        `gorm:"type:text" json:"serving_size,omitempty"`
+	Calories     *int           `json:"calories,omitempty"`
+	ProteinGrams *float64       `gorm:"type:numeric(7,2)" json:"protein_g,omitempty"`
+	CarbsGrams   *float64       `gorm:"type:numeric(7,2)" json:"carbs_g,omitempty"`
+	FatGrams     *float64       `gorm:"type:numeric(7,2)" json:"fat_g,omitempty"`
+	Allergens    pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"allergens"`
+	RecipeURL    *string        `gorm:"type:text" json:"recipe_url,omitempty"`
+	UpdatedAt    time.Time      `json:"updated_at"`
+}
+
+// Allergens returns the allergens of the dish, or nil if they are unknown
+func (d *Dish) Allergens() []string {
+	if d.Nutrition == nil {
+		return nil
+	}
+	return d.Nutrition.Allergens
+}
+
+// DietaryFilter restricts which dishes are shown based on their dietary tags and allergens
+type DietaryFilter struct {
+	Diet             string   `json:"diet,omitempty"`              // one of the Diet* constants
+	IncludeTags      []string `json:"include_tags,omitempty"`      // dish must have all of these tags (vegan counts as vegetarian)
+	ExcludeAllergens []string `json:"exclude_allergens,omitempty"` // dish must have none of these allergens
+	AvoidIngredients []string `json:"avoid_ingredients,omitempty"` // dish name/description must not mention these
+}
+
+// IsEmpty returns true if the filter allows every dish
+func (f DietaryFilter) IsEmpty() bool {
+	return f.Diet == DietNone && len(f.IncludeTags) == 0 && len(f.ExcludeAllergens) == 0 && len(f.AvoidIngredients) == 0
+}
+
+// DietTags returns the tags of which a dish needs at least one to fit the diet
+func DietTags(diet string) []string {
+	switch diet {
+	case DietVegetarian:
+		return []string{TagVegetarian, TagVegan} // vegan dishes are vegetarian too
+	case DietVegan:
+		return []string{TagVegan}
+	case DietHalal:
+		return []string{TagHalal}
+	}
+	return nil
+}
+
+// TagsSatisfying returns the tags of which a dish needs at least one to count
+// as having the given tag, e.g. vegan dishes count as vegetarian
+func TagsSatisfying(tag string) []string {
+	if strings.EqualFold(tag, TagVegetarian) {
+		return []string{TagVegetarian, TagVegan}
+	}
+	return []string{tag}
+}
+
+// Merge returns a filter that only allows dishes allowed by both filters.
+// If both have a diet, f's diet is kept.
+func (f DietaryFilter) Merge(other DietaryFilter) DietaryFilter {
+	merged := DietaryFilter{
+		Diet:             f.Diet,
+		IncludeTags:      appendMissing(append([]string{}, f.IncludeTags...), other.IncludeTags...),
+		ExcludeAllergens: appendMissing(append([]string{}, f.ExcludeAllergens...), other.ExcludeAllergens...),
+		AvoidIngredients: appendMissing(append([]string{}, f.AvoidIngredients...), other.AvoidIngredients...),
+	}
+	if merged.Diet == DietNone {
+		merged.Diet = other.Diet
+	}
+	return merged
+}
+
+// Allows returns true if the dish passes the filter. When allergens are being
+// excluded, dishes whose allergens are unknown are not allowed, since there
+// is no way to tell that they are safe.
+func (f DietaryFilter) Allows(dish *Dish) bool {
+	if dietTags := DietTags(f.Diet); dietTags != nil {
+		fits := false
+		for _, tag := range dietTags {
+			fits = fits || containsFold(dish.Tags, tag)
+		}
+		if !fits {
+			return false
+		}
+	}
+
+	for _, ingredient := range f.AvoidIngredients {
+		if strings.Contains(strings.ToLower(dish.Name), ingredient) ||
+			(dish.Description != nil && strings.Contains(strings.ToLower(*dish.Description), ingredient)) {
+			return false
+		}
+	}
+
+	for _, tag := range f.IncludeTags {
+		has := false
+		for _, satisfying := range TagsSatisfying(tag) {
+			has = has || containsFold(dish.Tags, satisfying)
+		}
+		if !has {
+			return false
+		}
+	}
+
+	if len(f.ExcludeAllergens) > 0 {
+		if dish.Nutrition == nil {
+			return false
+		}
+		for _, allergen := range f.ExcludeAllergens {
+			if containsFold(dish.Nutrition.Allergens, allergen) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// FilterDishes returns the dishes that pass the filter
+func (f DietaryFilter) FilterDishes(dishes []Dish) []Dish {
+	if f.IsEmpty() {
+		return dishes
+	}
+	filtered := make([]Dish, 0, len(dishes))
+	for i := range dishes {
+		if f.Allows(&dishes[i]) {
+			filtered = append(filtered, dishes[i])
+		}
+	}
+	return filtered
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends the values that aren't already in the list
+func appendMissing(list []string, values ...string) []string {
+	for _, value := range values {
+		if !containsFold(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
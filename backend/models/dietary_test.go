@@ -0,0 +1,33 @@
+package models
+
+import "testing"
+
+func TestDietaryFilterAllows(t *testing.T) {
+	vegan := &Dish{Name: "Vegan Scrambled Eggs", Tags: []string{TagVegan}}
+	vegetarian := &Dish{Name: "Kale Caesar Salad", Tags: []string{TagVegetarian}}
+	meat := &Dish{Name: "Orange Chicken", Tags: []string{}}
+
+	tests := []struct {
+		name   string
+		filter DietaryFilter
+		dish   *Dish
+		want   bool
+	}{
+		{"empty filter", DietaryFilter{}, meat, true},
+		{"vegan dish fits vegetarian diet", DietaryFilter{Diet: DietVegetarian}, vegan, true},
+		{"vegetarian dish doesn't fit vegan diet", DietaryFilter{Diet: DietVegan}, vegetarian, false},
+		{"vegan dish has the vegetarian tag", DietaryFilter{IncludeTags: []string{TagVegetarian}}, vegan, true},
+		{"vegetarian dish has the vegetarian tag", DietaryFilter{IncludeTags: []string{TagVegetarian}}, vegetarian, true},
+		{"meat dish doesn't have the vegetarian tag", DietaryFilter{IncludeTags: []string{TagVegetarian}}, meat, false},
+		{"vegetarian dish doesn't have the vegan tag", DietaryFilter{IncludeTags: []string{TagVegan}}, vegetarian, false},
+		{"tags are compared ignoring case", DietaryFilter{IncludeTags: []string{"Vegetarian"}}, vegan, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Allows(tt.dish); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
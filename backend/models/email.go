@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// EmailPreferences are the emails a user opted in to. Every user gets an
+// unsubscribe token the first time their preferences are loaded, so emails
+// can link to a one-click unsubscribe without logging in.
+type EmailPreferences struct {
+	ID               uint       `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID           uint       `gorm:"not null;uniqueIndex" json:"user_id"`
+	Digest           bool       `gorm:"not null;default:false" json:"digest"`        // morning digest of today's menus
+	Notifications    bool       `gorm:"not null;default:false" json:"notifications"` // an email for every notification
+	UnsubscribeToken string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	LastDigestDate   *time.Time `gorm:"type:date" json:"last_digest_date,omitempty"` // so a digest is never sent twice on one day
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// EmailRecipient is a user to send an email to
+type EmailRecipient struct {
+	UserID           uint
+	Username         string
+	Email            string
+	UnsubscribeToken string
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Favorite is a dish, or a recipe (the dish at every hall), a user follows.
+// Exactly one of DishID and RecipeID is set.
+type Favorite struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint      `gorm:"not null;index;uniqueIndex:idx_favorite_dish;uniqueIndex:idx_favorite_recipe" json:"user_id"`
+	DishID    *uint     `gorm:"uniqueIndex:idx_favorite_dish" json:"dish_id,omitempty"`
+	Dish      *Dish     `gorm:"foreignKey:DishID" json:"dish,omitempty"`
+	RecipeID  *uint     `gorm:"uniqueIndex:idx_favorite_recipe" json:"recipe_id,omitempty"`
+	Recipe    *Recipe   `gorm:"foreignKey:RecipeID" json:"recipe,omitempty"`
+	CreatedAt time.Time `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+}
+
+// FavoriteMatch is a favorite dish found on a menu
+type FavoriteMatch struct {
+	UserID          uint
+	DishID          uint
+	DishName        string
+	MenuID          uint
+	HallName        string
+	HallDisplayName string
+	Date            Date
+}
@@ -0,0 +1,38 @@
+package models
+
+import "slices"
+
+// Friend request states. A request starts pending and is then accepted or
+// declined by the recipient, or cancelled by the sender. The other states are
+// final, a new request has to be sent to try again.
+const (
+	FriendRequestPending   = "pending"
+	FriendRequestAccepted  = "accepted"
+	FriendRequestDeclined  = "declined"
+	FriendRequestCancelled = "cancelled"
+)
+
+// The states a friend request can move to from each state
+var friendRequestTransitions = map[string][]string{
+	FriendRequestPending: {FriendRequestAccepted, FriendRequestDeclined, FriendRequestCancelled},
+}
+
+// CanTransition returns true if the request can move to the status
+func (r *FriendRequest) CanTransition(status string) bool {
+	return slices.Contains(friendRequestTransitions[r.Status], status)
+}
+
+// TasteMatch is how closely another user's ratings agree with a user's on the
+// dishes they both rated
+type TasteMatch struct {
+	UserID       uint
+	SharedDishes int     // dishes both users rated
+	AvgDiff      float64 // mean difference between their scores, 0 to 5
+	LovedDishID  *uint   // the dish both rated 4 or higher that they liked most together, if any
+}
+
+// Similarity returns 1 when the users rated every shared dish the same, down
+// to 0 when they always disagreed as much as possible
+func (t TasteMatch) Similarity() float64 {
+	return 1 - t.AvgDiff/5
+}
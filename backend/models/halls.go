@@ -1,29 +1,52 @@
 package models
 
+import "strings"
+
 // https://stackoverflow.com/questions/19335215/what-is-a-slug
 
 type HallSlug string
 
 const (
-	DeNeveDining  HallSlug = "de-neve-dining"
-	BruinCafe     HallSlug = "bruin-cafe"
-	BruinPlate    HallSlug = "bruin-plate"
-	Cafe1919      HallSlug = "cafe-1919"
-	EpicuriaCovel HallSlug = "epicuria-at-covel"
-	EpicuriaAck   HallSlug = "epicuria-at-ackerman"
-	Rendezvous    HallSlug = "rendezvous"
-	TheDrey       HallSlug = "the-drey"
-	SpiceKitchen  HallSlug = "spice-kitchen"
+	DeNeveDining      HallSlug = "de-neve-dining"
+	BruinCafe         HallSlug = "bruin-cafe"
+	BruinPlate        HallSlug = "bruin-plate"
+	Cafe1919          HallSlug = "cafe-1919"
+	EpicuriaCovel     HallSlug = "epicuria-at-covel"
+	EpicuriaAck       HallSlug = "epicuria-at-ackerman"
+	Rendezvous        HallSlug = "rendezvous"
+	TheDrey           HallSlug = "the-drey"
+	SpiceKitchen      HallSlug = "spice-kitchen"
+	TheStudyAtHedrick HallSlug = "the-study-at-hedrick"
+)
+
+// Who runs a hall
+const (
+	HallTypeResidential = "residential" // UCLA Dining, on the hill
+	HallTypeASUCLA      = "asucla"      // ASUCLA, on campus
 )
 
-var HallNameMap = map[HallSlug]string{
-	DeNeveDining:  "De Neve",
-	BruinCafe:     "Bruin Café",
-	BruinPlate:    "Bruin Plate",
-	Cafe1919:      "Café 1919",
-	EpicuriaCovel: "Epicuria",
-	EpicuriaAck:   "Epic at Ackerman",
-	Rendezvous:    "Rendezvous",
-	TheDrey:       "The Drey",
-	SpiceKitchen:  "Spice Kitchen at Bruin Bowl",
+// DefaultHalls are added to the database when it is set up. After that the
+// database is the source of truth: halls are edited with the admin endpoints,
+// and new venues found by ingest are added automatically.
+var DefaultHalls = []DiningHall{
+	{Name: string(DeNeveDining), DisplayName: "De Neve", HallType: HallTypeResidential},
+	{Name: string(BruinCafe), DisplayName: "Bruin Café", HallType: HallTypeResidential},
+	{Name: string(BruinPlate), DisplayName: "Bruin Plate", HallType: HallTypeResidential},
+	{Name: string(Cafe1919), DisplayName: "Café 1919", HallType: HallTypeResidential},
+	{Name: string(EpicuriaCovel), DisplayName: "Epicuria", HallType: HallTypeResidential},
+	{Name: string(EpicuriaAck), DisplayName: "Epic at Ackerman", HallType: HallTypeASUCLA},
+	{Name: string(Rendezvous), DisplayName: "Rendezvous", HallType: HallTypeResidential},
+	{Name: string(TheDrey), DisplayName: "The Drey", HallType: HallTypeResidential},
+	{Name: string(SpiceKitchen), DisplayName: "Spice Kitchen at Bruin Bowl", HallType: HallTypeResidential},
+	{Name: string(TheStudyAtHedrick), DisplayName: "The Study at Hedrick", HallType: HallTypeResidential},
+}
+
+// DisplayNameFromSlug makes a readable name for a hall that doesn't have one
+// yet, e.g. "the-study-at-hedrick" -> "The Study At Hedrick"
+func DisplayNameFromSlug(slug string) string {
+	words := strings.Fields(strings.ReplaceAll(slug, "-", " "))
+	for i, w := range words {
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
 }
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meal period labels for halls that serve one menu across several meal
+// periods. Their pages only have a breakfast section, so their menus are
+// stored under BREAKFAST and shown to users with one of these labels.
+const (
+	PeriodAllDay      = "ALL_DAY"
+	PeriodLunchDinner = "LUNCH_DINNER"
+)
+
+// HallHours are the regular hours of one meal period at a hall on one day of the week
+type HallHours struct {
+	ID         uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	HallID     uint   `gorm:"not null;uniqueIndex:idx_hall_hours_period" json:"hall_id"`
+	DayOfWeek  int    `gorm:"not null;uniqueIndex:idx_hall_hours_period" json:"day_of_week"`           // 0 = Sunday, like time.Weekday
+	MealPeriod string `gorm:"type:text;not null;uniqueIndex:idx_hall_hours_period" json:"meal_period"` // the period the menu is stored under
+	Label      string `gorm:"type:text;not null" json:"label"`                                         // the period shown to users, e.g. ALL_DAY
+	Opens      string `gorm:"type:text;not null" json:"opens"`                                         // HH:MM
+	Closes     string `gorm:"type:text;not null" json:"closes"`                                        // HH:MM, at or before Opens if it closes after midnight
+}
+
+// HallHoursOverride changes a hall's hours on one date, e.g. for holidays or
+// finals week. With Date.MealPeriod set it replaces (or adds, or with Closed
+// removes) that meal period's hours. Without a meal period and with Closed
+// set, the hall is closed all day apart from other overrides for that date.
+type HallHoursOverride struct {
+	ID     uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	HallID uint   `gorm:"not null;index" json:"hall_id"`
+	Date   Date   `gorm:"embedded;embeddedPrefix:date_" json:"date"`
+	Label  string `gorm:"type:text" json:"label,omitempty"`
+	Opens  string `gorm:"type:text" json:"opens,omitempty"`
+	Closes string `gorm:"type:text" json:"closes,omitempty"`
+	Closed bool   `gorm:"not null;default:false" json:"closed"`
+	Note   string `gorm:"type:text" json:"note,omitempty"` // e.g. "Thanksgiving"
+}
+
+// HallPeriod is a meal period a hall serves on a specific date
+type HallPeriod struct {
+	HallID     uint      `json:"hall_id"`
+	HallName   string    `json:"hall_name"`
+	Date       string    `json:"date"` // YYYY-MM-DD of the day the period starts
+	MealPeriod string    `json:"meal_period"`
+	Label      string    `json:"label"`
+	Opens      time.Time `json:"opens"`
+	Closes     time.Time `json:"closes"`
+}
+
+// ParseClock parses a HH:MM time of day into minutes after midnight
+func ParseClock(s string) (int, error) {
+	hourStr, minuteStr, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	hour, err := strconv.Atoi(hourStr)
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("invalid hour in %q", s)
+	}
+	minute, err := strconv.Atoi(minuteStr)
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid minute in %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// Window returns when the hours open and close on the given date. If they
+// close at or before they open, they close on the next day.
+func (h HallHours) Window(date Date, loc *time.Location) (time.Time, time.Time, error) {
+	opens, err := ParseClock(h.Opens)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	closes, err := ParseClock(h.Closes)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if closes <= opens {
+		closes += 24 * 60
+	}
+	midnight := date.Time(loc)
+	return midnight.Add(time.Duration(opens) * time.Minute), midnight.Add(time.Duration(closes) * time.Minute), nil
+}
+
+// ApplyOverrides returns the hours for a date given the regular hours for its
+// day of the week and the overrides for that date
+func ApplyOverrides(weekly []HallHours, overrides []HallHoursOverride) []HallHours {
+	hours := weekly
+	for _, override := range overrides {
+		if override.Date.MealPeriod == nil && override.Closed {
+			hours = nil
+			break
+		}
+	}
+
+	for _, override := range overrides {
+		if override.Date.MealPeriod == nil {
+			continue
+		}
+		period := *override.Date.MealPeriod
+
+		var updated []HallHours
+		label := period
+		for _, h := range hours {
+			if h.MealPeriod == period {
+				label = h.Label
+				continue
+			}
+			updated = append(updated, h)
+		}
+		if !override.Closed {
+			if override.Label != "" {
+				label = override.Label
+			}
+			updated = append(updated, HallHours{
+				HallID:     override.HallID,
+				DayOfWeek:  int(override.Date.Time(time.UTC).Weekday()),
+				MealPeriod: period,
+				Label:      label,
+				Opens:      override.Opens,
+				Closes:     override.Closes,
+			})
+		}
+		hours = updated
+	}
+	return hours
+}
+
+// MealPeriodWord is a meal period or label for use in sentences,
+// e.g. LUNCH_DINNER -> "lunch & dinner"
+func MealPeriodWord(period string) string {
+	switch period {
+	case PeriodAllDay:
+		return "(all day)"
+	case PeriodLunchDinner:
+		return "lunch & dinner"
+	}
+	return strings.ReplaceAll(strings.ToLower(period), "_", " ")
+}
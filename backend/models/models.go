@@ -1,6 +1,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/lib/pq"
@@ -16,6 +17,46 @@ type Date struct {
 	MealPeriod *string `gorm:"column:meal_period;type:text" json:"meal_period,omitempty"`
 }
 
+// DateOf returns the date of t (in t's location) with no meal period
+func DateOf(t time.Time) Date {
+	return Date{Day: t.Day(), Month: int(t.Month()), Year: t.Year()}
+}
+
+// ParseDate parses a YYYY-MM-DD date with no meal period
+func ParseDate(s string) (Date, error) {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return Date{}, err
+	}
+	return DateOf(t), nil
+}
+
+// Time returns midnight of the date in loc
+func (d Date) Time(loc *time.Location) time.Time {
+	return time.Date(d.Year, time.Month(d.Month), d.Day, 0, 0, 0, 0, loc)
+}
+
+// String formats the date (ignoring the meal period) as YYYY-MM-DD
+func (d Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// SameDay returns true if both dates are the same day (meal periods are ignored)
+func (d Date) SameDay(other Date) bool {
+	return d.Year == other.Year && d.Month == other.Month && d.Day == other.Day
+}
+
+// Before returns true if the day is before other's day (meal periods are ignored)
+func (d Date) Before(other Date) bool {
+	if d.Year != other.Year {
+		return d.Year < other.Year
+	}
+	if d.Month != other.Month {
+		return d.Month < other.Month
+	}
+	return d.Day < other.Day
+}
+
 // Used to track when the scraper has last fully run, but also has additional capability
 // since it stores a key so it could be used for other things if they need date tracking
 // Embedded means that GORM will create columns like "last_run_at_day", "last_run_at_meal_period" etc
@@ -42,6 +83,11 @@ func mealPeriodRank(p *string) int {
 	}
 }
 
+// MealPeriodRank orders meal periods through the day, unknown ones first
+func MealPeriodRank(period string) int {
+	return mealPeriodRank(&period)
+}
+
 // Will return true if the LastRunAt param is after the input
 func (u *UpdateTracker) IsEqualOrAfter(date Date) bool {
 	if u.LastRunAt.Year != date.Year {
@@ -65,7 +111,9 @@ type User struct {
 	HashedPassword         string          `gorm:"type:text;not null" json:"-"`
 	Email                  string          `gorm:"type:text;unique;not null" json:"email"`
 	ProfilePicture         *string         `gorm:"type:text" json:"profile_picture,omitempty"`
-	IsAdmin                bool            `gorm:"not null;default:false" json:"is_admin"`
+	IsAdmin                bool            `gorm:"not null;default:false" json:"is_admin"`              // kept in sync with Role, true for admins
+	Role                   string          `gorm:"type:text;not null;default:'user';index" json:"role"` // see Roles
+	EmailVerified          bool            `gorm:"not null;default:false" json:"email_verified"`
 	Ratings                []Rating        `gorm:"foreignKey:UserID" json:"ratings,omitempty"`
 	FriendRequestsSent     []FriendRequest `gorm:"foreignKey:FromID" json:"friend_requests_sent,omitempty"`   // requests sent by this user
 	FriendRequestsReceived []FriendRequest `gorm:"foreignKey:ToID" json:"friend_requests_received,omitempty"` // requests received by this user
@@ -80,35 +128,52 @@ type Friendship struct {
 }
 
 // FriendRequest represents a friend request sent from one user to another
+// Requests are kept after they're answered, only one can be pending between the same two users
+// in either direction (see DBManager.Migrate).
 type FriendRequest struct {
-	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	FromID    uint      `gorm:"not null;index" json:"from_id"` // the user who sent the friend request
-	ToID      uint      `gorm:"not null;index" json:"to_id"`   // the user who received the friend request
-	CreatedAt time.Time `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
-	Status    string    `gorm:"type:text;not null;default:'pending'" json:"status"` // e.g. "pending", "accepted", "declined"
-	FromUser  User      `gorm:"foreignKey:FromID" json:"from_user,omitempty"`       // the user who sent the request
-	ToUser    User      `gorm:"foreignKey:ToID" json:"to_user,omitempty"`           // the user who received the request
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	FromID      uint       `gorm:"not null;index" json:"from_id"` // the user who sent the friend request
+	ToID        uint       `gorm:"not null;index" json:"to_id"`   // the user who received the friend request
+	CreatedAt   time.Time  `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+	Status      string     `gorm:"type:text;not null;default:'pending'" json:"status"`          // see FriendRequestPending
+	RespondedAt *time.Time `gorm:"type:timestamp with time zone" json:"responded_at,omitempty"` // when it stopped being pending
+	FromUser    User       `gorm:"foreignKey:FromID" json:"from_user,omitempty"`                // the user who sent the request
+	ToUser      User       `gorm:"foreignKey:ToID" json:"to_user,omitempty"`                    // the user who received the request
 }
 
+// DiningHall is a dining hall or other venue. Name is the slug used by the
+// dining website (e.g. "de-neve-dining"), Slug always matches it.
 type DiningHall struct {
-	ID       uint    `gorm:"primaryKey;autoIncrement" json:"id"`
-	Name     string  `gorm:"type:text;not null" json:"name"`
-	Location *string `gorm:"type:text" json:"location,omitempty"`
-	Dishes   []Dish  `gorm:"foreignKey:HallID" json:"dishes,omitempty"`
-	Menus    []Menu  `gorm:"foreignKey:HallID" json:"menus,omitempty"`
+	ID          uint     `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name        string   `gorm:"type:text;not null" json:"name"`
+	Slug        string   `gorm:"type:text;not null;default:'';index" json:"slug"`
+	DisplayName string   `gorm:"type:text;not null;default:''" json:"display_name"`
+	Description *string  `gorm:"type:text" json:"description,omitempty"`
+	Latitude    *float64 `json:"latitude,omitempty"`
+	Longitude   *float64 `json:"longitude,omitempty"`
+	ImageURL    *string  `gorm:"type:text" json:"image_url,omitempty"`
+	HallType    string   `gorm:"type:text;not null;default:''" json:"hall_type"` // HallTypeResidential or HallTypeASUCLA
+	Location    *string  `gorm:"type:text" json:"location,omitempty"`
+	Dishes      []Dish   `gorm:"foreignKey:HallID" json:"dishes,omitempty"`
+	Menus       []Menu   `gorm:"foreignKey:HallID" json:"menus,omitempty"`
 }
 
 type Dish struct {
-	ID            uint           `gorm:"primaryKey;autoIncrement" json:"id"`
-	HallID        uint           `gorm:"not null;index" json:"hall_id"`
-	Hall          DiningHall     `gorm:"foreignKey:HallID" json:"-"`
-	Name          string         `gorm:"type:text;not null;index" json:"name"`
-	Description   *string        `gorm:"type:text" json:"description,omitempty"`
-	AverageRating float64        `gorm:"type:numeric(7,5);not null;default:0.00000" json:"average_rating"`
-	Tags          pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"tags"`
-	Location      *string        `gorm:"type:text" json:"location,omitempty"`
-	LastSeenDate  Date           `gorm:"embedded;embeddedPrefix:last_seen_date_" json:"last_seen_date"` // see explanation of embedded above
-	Ratings       []Rating       `gorm:"foreignKey:DishID" json:"ratings,omitempty"`
+	ID             uint           `gorm:"primaryKey;autoIncrement" json:"id"`
+	HallID         uint           `gorm:"not null;index" json:"hall_id"`
+	Hall           DiningHall     `gorm:"foreignKey:HallID" json:"-"`
+	Name           string         `gorm:"type:text;not null;index" json:"name"`
+	NormalizedName string         `gorm:"type:text;not null;default:'';index" json:"-"` // see NormalizeDishName
+	RecipeID       *uint          `gorm:"index" json:"recipe_id,omitempty"`
+	Recipe         *Recipe        `gorm:"foreignKey:RecipeID" json:"recipe,omitempty"`
+	Description    *string        `gorm:"type:text" json:"description,omitempty"`
+	AverageRating  float64        `gorm:"type:numeric(7,5);not null;default:0.00000" json:"average_rating"`
+	Tags           pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"tags"`
+	Location       *string        `gorm:"type:text" json:"location,omitempty"`
+	LastSeenDate   Date           `gorm:"embedded;embeddedPrefix:last_seen_date_" json:"last_seen_date"` // see explanation of embedded above
+	FirstSeenDate  *time.Time     `gorm:"type:date;index" json:"first_seen_date,omitempty"`              // a plain date so "new since" queries stay cheap
+	Ratings        []Rating       `gorm:"foreignKey:DishID" json:"ratings,omitempty"`
+	Nutrition      *DishNutrition `gorm:"foreignKey:DishID" json:"nutrition,omitempty"`
 }
 
 type Menu struct {
@@ -129,3 +194,13 @@ type Rating struct {
 	Comment   *string   `gorm:"type:text" json:"comment,omitempty"`
 	CreatedAt time.Time `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
 }
+
+// RatingReply is a reply to the comment on a rating
+type RatingReply struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	RatingID  uint      `gorm:"not null;index" json:"rating_id"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"user"`
+	Comment   string    `gorm:"type:text;not null" json:"comment"`
+	CreatedAt time.Time `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+}
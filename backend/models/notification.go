@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Notification is an in-app notification, see the notify package for the types
+type Notification struct {
+	ID        uint            `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint            `gorm:"not null;index" json:"user_id"`
+	Type      string          `gorm:"type:text;not null" json:"type"`
+	Title     string          `gorm:"type:text;not null" json:"title"`
+	Body      string          `gorm:"type:text;not null;default:''" json:"body,omitempty"`
+	Data      json.RawMessage `gorm:"type:jsonb" json:"data,omitempty"` // e.g. the dish_id, for the client to link to
+	ReadAt    *time.Time      `gorm:"type:timestamp with time zone" json:"read_at,omitempty"`
+	CreatedAt time.Time       `gorm:"type:timestamp with time zone;not null;default:now();index" json:"created_at"`
+}
+
+// NotificationMute stops a user from getting one type of notification
+type NotificationMute struct {
+	ID     uint   `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_notification_mute" json:"user_id"`
+	Type   string `gorm:"type:text;not null;uniqueIndex:idx_notification_mute" json:"type"`
+}
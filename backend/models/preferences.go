@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserPreferences is a user's dietary profile. It is applied by default to
+// the menus, search results and recommendations the user sees.
+type UserPreferences struct {
+	ID                  uint           `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID              uint           `gorm:"not null;uniqueIndex" json:"user_id"`
+	DietType            string         `gorm:"type:text;not null;default:''" json:"diet_type"` // one of the Diet* constants
+	AvoidAllergens      pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"avoid_allergens"`
+	DislikedIngredients pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"disliked_ingredients"`
+	FavoriteHalls       pq.StringArray `gorm:"type:text[];not null;default:'{}'" json:"favorite_halls"` // hall names (slugs)
+	UpdatedAt           time.Time      `json:"updated_at"`
+}
+
+// DietaryFilter returns the filter that applies the preferences to dishes
+func (p *UserPreferences) DietaryFilter() DietaryFilter {
+	ingredients := make([]string, len(p.DislikedIngredients))
+	for i, ingredient := range p.DislikedIngredients {
+		ingredients[i] = strings.ToLower(ingredient)
+	}
+	return DietaryFilter{
+		Diet:             p.DietType,
+		ExcludeAllergens: p.AvoidAllergens,
+		AvoidIngredients: ingredients,
+	}
+}
+
+// IsFavoriteHall returns true if the hall (by name/slug) is one of the user's favorites
+func (p *UserPreferences) IsFavoriteHall(hallName string) bool {
+	return containsFold(p.FavoriteHalls, hallName)
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// Who can see part of a user's profile
+const (
+	VisibilityPublic  = "public"  // everyone, including logged out visitors
+	VisibilityFriends = "friends" // only the user's friends
+	VisibilityPrivate = "private" // only the user
+)
+
+// Visibilities lists every visibility from most to least open
+var Visibilities = []string{VisibilityPublic, VisibilityFriends, VisibilityPrivate}
+
+// ValidVisibility returns true if visibility is one of Visibilities
+func ValidVisibility(visibility string) bool {
+	return slices.Contains(Visibilities, visibility)
+}
+
+// PrivacySettings control who can see a user's profile and ratings and find
+// them in user search. Users without saved settings get DefaultPrivacySettings.
+type PrivacySettings struct {
+	ID                uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID            uint      `gorm:"not null;uniqueIndex" json:"user_id"`
+	ProfileVisibility string    `gorm:"type:text;not null;default:'public'" json:"profile_visibility"`
+	RatingsVisibility string    `gorm:"type:text;not null;default:'public'" json:"ratings_visibility"`
+	SearchableByEmail bool      `gorm:"not null;default:false" json:"searchable_by_email"` // others can find the user by typing their exact email
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// DefaultPrivacySettings returns the settings of a user who hasn't changed them
+func DefaultPrivacySettings(userID uint) *PrivacySettings {
+	return &PrivacySettings{
+		UserID:            userID,
+		ProfileVisibility: VisibilityPublic,
+		RatingsVisibility: VisibilityPublic,
+	}
+}
+
+// Relationship is how a viewer is related to the user they're looking at
+type Relationship struct {
+	Self    bool `json:"self"`
+	Friends bool `json:"friends"`
+	Blocked bool `json:"blocked"` // either of them blocked the other
+}
+
+// CanSee returns true if a viewer with the relationship can see something
+// with the visibility. Blocked users can't see anything.
+func (r Relationship) CanSee(visibility string) bool {
+	switch {
+	case r.Self:
+		return true
+	case r.Blocked:
+		return false
+	case visibility == VisibilityPublic:
+		return true
+	case visibility == VisibilityFriends:
+		return r.Friends
+	default:
+		return false
+	}
+}
+
+// Block stops one user from seeing or contacting another. Blocking also
+// ends their friendship and any pending friend requests between them.
+type Block struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	BlockerID uint      `gorm:"not null;uniqueIndex:idx_blocks_pair" json:"blocker_id"`
+	BlockedID uint      `gorm:"not null;uniqueIndex:idx_blocks_pair;index" json:"blocked_id"`
+	CreatedAt time.Time `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+	Blocked   User      `gorm:"foreignKey:BlockedID" json:"blocked,omitempty"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Recipe is what ties together the dishes that are really the same food:
+// the same dish served at several halls, or listed under slightly different
+// names. Ratings for all of its dishes are aggregated here.
+type Recipe struct {
+	ID             uint    `gorm:"primaryKey;autoIncrement" json:"id"`
+	Name           string  `gorm:"type:text;not null" json:"name"`
+	NormalizedName string  `gorm:"type:text;not null;index" json:"normalized_name"`
+	AverageRating  float64 `gorm:"type:numeric(7,5);not null;default:0.00000" json:"average_rating"`
+	RatingCount    int64   `gorm:"not null;default:0" json:"rating_count"`
+	Dishes         []Dish  `gorm:"foreignKey:RecipeID" json:"dishes,omitempty"`
+}
+
+// DishAlias is another name a hall has listed a dish under. When dishes are
+// merged, the merged away dish's name becomes an alias of the remaining dish
+// so ingest keeps adding menus to it.
+type DishAlias struct {
+	ID             uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	HallID         uint   `gorm:"not null;uniqueIndex:idx_dish_alias_name" json:"hall_id"`
+	NormalizedName string `gorm:"type:text;not null;uniqueIndex:idx_dish_alias_name" json:"normalized_name"`
+	DishID         uint   `gorm:"not null;index" json:"dish_id"`
+}
+
+var nonAlphanumeric = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// NormalizeDishName reduces a dish name to the form used to tell if two names
+// are the same dish: lowercase, with punctuation and extra spaces removed.
+// e.g. "Chicken Tikka Masala " and "chicken tikka-masala" are both "chicken tikka masala"
+func NormalizeDishName(name string) string {
+	return strings.TrimSpace(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), " "))
+}
@@ -0,0 +1,26 @@
+package models
+
+import "slices"
+
+// Roles a user can have, each one can do everything the roles before it can
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // can fix up dishes and hall hours
+	RoleAdmin     = "admin"     // can do everything, including granting roles
+)
+
+// Roles lists every role from least to most privileged
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// ValidRole returns true if role is one of Roles
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// HasRole returns true if a user with role can do what required allows.
+// Unknown roles can't do anything.
+func HasRole(role string, required string) bool {
+	have := slices.Index(Roles, role)
+	need := slices.Index(Roles, required)
+	return have >= 0 && need >= 0 && have >= need
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Session is one logged in device. Access tokens name their session, so
+// revoking it logs the device out; the refresh token is rotated on every use
+// and only its hash is stored.
+type Session struct {
+	ID                uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID            uint       `gorm:"not null;index" json:"user_id"`
+	RefreshTokenHash  string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	PreviousTokenHash string     `gorm:"type:text;not null;default:'';index" json:"-"` // the token it replaced, to catch reuse of a stolen one
+	UserAgent         string     `gorm:"type:text;not null;default:''" json:"user_agent"`
+	IPAddress         string     `gorm:"type:text;not null;default:''" json:"ip_address"`
+	CreatedAt         time.Time  `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+	LastUsedAt        time.Time  `gorm:"type:timestamp with time zone;not null;default:now()" json:"last_used_at"`
+	ExpiresAt         time.Time  `gorm:"type:timestamp with time zone;not null" json:"expires_at"`
+	RevokedAt         *time.Time `gorm:"type:timestamp with time zone" json:"revoked_at,omitempty"`
+}
+
+// Active returns true if the session hasn't been revoked or expired
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,27 @@
+package models
+
+// Station is a section of a dining hall that dishes are served at, e.g.
+// "Harvest" or "Flex Bar". The dining website calls these sub-categories.
+type Station struct {
+	ID     uint   `gorm:"primaryKey;autoIncrement" json:"id"`
+	HallID uint   `gorm:"not null;uniqueIndex:idx_station_hall_name" json:"hall_id"`
+	Name   string `gorm:"type:text;not null;uniqueIndex:idx_station_hall_name" json:"name"`
+}
+
+// MenuDish is the menu_dishes join table between menus and dishes. Besides
+// linking the two, it records which station served the dish on that menu
+// and where on the menu it was listed.
+type MenuDish struct {
+	MenuID    uint     `gorm:"primaryKey" json:"menu_id"`
+	DishID    uint     `gorm:"primaryKey" json:"dish_id"`
+	StationID *uint    `gorm:"index" json:"station_id,omitempty"` // nil for menus loaded before stations existed
+	Station   *Station `gorm:"foreignKey:StationID" json:"station,omitempty"`
+	Position  int      `gorm:"not null;default:0" json:"position"` // order the dish was listed in
+}
+
+// MenuStation is a station and the dishes it served on a menu
+type MenuStation struct {
+	ID     *uint  `json:"id,omitempty"`
+	Name   string `json:"name"`
+	Dishes []Dish `json:"dishes"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// UserToken purposes
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken is a single-use, expiring token emailed to a user, e.g. to
+// verify their email. Only a hash of the token is stored.
+type UserToken struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    uint       `gorm:"not null;index" json:"user_id"`
+	Purpose   string     `gorm:"type:text;not null" json:"purpose"`
+	TokenHash string     `gorm:"type:text;not null;uniqueIndex" json:"-"`
+	Email     string     `gorm:"type:text;not null;index" json:"email"` // the address it was sent to
+	ExpiresAt time.Time  `gorm:"type:timestamp with time zone;not null" json:"expires_at"`
+	UsedAt    *time.Time `gorm:"type:timestamp with time zone" json:"used_at,omitempty"`
+	CreatedAt time.Time  `gorm:"type:timestamp with time zone;not null;default:now()" json:"created_at"`
+}
@@ -0,0 +1,84 @@
+package notify
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// Notification types
+const (
+	TypeFriendRequest  = "friend_request"  // someone sent the user a friend request
+	TypeFriendAccepted = "friend_accepted" // someone accepted the user's friend request
+	TypeFriendRated    = "friend_rated"    // a friend rated a dish the user rated
+	TypeFavoriteOnMenu = "favorite_on_menu"
+	TypeCommentReply   = "comment_reply" // someone replied to the user's rating
+)
+
+// Types lists every notification type, e.g. for validating mute settings
+var Types = []string{TypeFriendRequest, TypeFriendAccepted, TypeFriendRated, TypeFavoriteOnMenu, TypeCommentReply}
+
+// Message is something a user should be told about
+type Message struct {
+	UserID uint                   `json:"user_id"`
+	Type   string                 `json:"type"`
+	Title  string                 `json:"title"`
+	Body   string                 `json:"body,omitempty"`
+	Data   map[string]interface{} `json:"data,omitempty"` // e.g. the dish_id, for the client to link to
+}
+
+// Notifier delivers messages to users. Implementations can store them,
+// send them somewhere else or just keep them in memory for tests.
+type Notifier interface {
+	Notify(messages ...Message) error
+}
+
+// Func lets a plain function be used as a Notifier
+type Func func(messages ...Message) error
+
+func (f Func) Notify(messages ...Message) error {
+	return f(messages...)
+}
+
+// LogNotifier only logs messages
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(messages ...Message) error {
+	for _, message := range messages {
+		log.Printf("[notify] user %d: %s (%s)", message.UserID, message.Title, message.Type)
+	}
+	return nil
+}
+
+// MemoryNotifier keeps every message it's given, e.g. for tests
+type MemoryNotifier struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (n *MemoryNotifier) Notify(messages ...Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, messages...)
+	return nil
+}
+
+// Messages returns a copy of the messages received so far
+func (n *MemoryNotifier) Messages() []Message {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]Message(nil), n.messages...)
+}
+
+// Multi sends messages to every notifier in it, returning all of their errors
+type Multi []Notifier
+
+func (m Multi) Notify(messages ...Message) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(messages...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
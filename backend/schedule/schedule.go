@@ -0,0 +1,200 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrAlreadyRunning = errors.New("job is already running")
+
+// ClockTime is a time of day (in whatever location the scheduler runs in)
+type ClockTime struct {
+	Hour   int
+	Minute int
+}
+
+func (c ClockTime) String() string {
+	return fmt.Sprintf("%02d:%02d", c.Hour, c.Minute)
+}
+
+// ParseClockTimes parses a comma separated list of 24 hour times, e.g. "05:30,10:30"
+func ParseClockTimes(s string) ([]ClockTime, error) {
+	var times []ClockTime
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		hourStr, minuteStr, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid time %q, expected HH:MM", part)
+		}
+		hour, err := strconv.Atoi(hourStr)
+		if err != nil || hour < 0 || hour > 23 {
+			return nil, fmt.Errorf("invalid hour in %q", part)
+		}
+		minute, err := strconv.Atoi(minuteStr)
+		if err != nil || minute < 0 || minute > 59 {
+			return nil, fmt.Errorf("invalid minute in %q", part)
+		}
+		times = append(times, ClockTime{Hour: hour, Minute: minute})
+	}
+	if len(times) == 0 {
+		return nil, errors.New("no times given")
+	}
+	return times, nil
+}
+
+// NextRun returns the first of the given times of day that is strictly after now
+func NextRun(now time.Time, times []ClockTime, loc *time.Location) time.Time {
+	now = now.In(loc)
+	var next time.Time
+	// Looking at today and tomorrow is always enough to find the next one
+	for dayOffset := 0; dayOffset <= 1; dayOffset++ {
+		for _, t := range times {
+			candidate := time.Date(now.Year(), now.Month(), now.Day()+dayOffset, t.Hour, t.Minute, 0, 0, loc)
+			if candidate.After(now) && (next.IsZero() || candidate.Before(next)) {
+				next = candidate
+			}
+		}
+	}
+	return next
+}
+
+// Job is the work done on every run. The returned result is kept in the
+// status so it can be shown by a status endpoint.
+type Job func() (interface{}, error)
+
+// Status describes the last and next run of a scheduler
+type Status struct {
+	Name        string      `json:"name"`
+	Running     bool        `json:"running"`
+	Times       []string    `json:"times"`
+	Timezone    string      `json:"timezone"`
+	LastStarted *time.Time  `json:"last_started,omitempty"`
+	LastEnded   *time.Time  `json:"last_ended,omitempty"`
+	LastError   string      `json:"last_error,omitempty"`
+	LastResult  interface{} `json:"last_result,omitempty"`
+	NextRun     *time.Time  `json:"next_run,omitempty"`
+}
+
+// Scheduler runs a job every day at a set of times of day. A lock makes sure
+// runs never overlap, whether they were scheduled or triggered manually.
+type Scheduler struct {
+	name  string
+	loc   *time.Location
+	times []ClockTime
+	job   Job
+
+	runLock sync.Mutex // held for the duration of a run
+	mu      sync.Mutex // protects status
+	status  Status
+	stop    chan struct{}
+}
+
+func New(name string, loc *time.Location, times []ClockTime, job Job) *Scheduler {
+	timeStrings := make([]string, len(times))
+	for i, t := range times {
+		timeStrings[i] = t.String()
+	}
+	return &Scheduler{
+		name:  name,
+		loc:   loc,
+		times: times,
+		job:   job,
+		status: Status{
+			Name:     name,
+			Times:    timeStrings,
+			Timezone: loc.String(),
+		},
+		stop: make(chan struct{}),
+	}
+}
+
+// Start runs the scheduler loop in the background. If runNow is true, a run
+// is started immediately instead of waiting for the first scheduled time.
+func (s *Scheduler) Start(runNow bool) {
+	go func() {
+		if runNow {
+			s.run()
+		}
+		for {
+			next := NextRun(time.Now(), s.times, s.loc)
+			s.mu.Lock()
+			s.status.NextRun = &next
+			s.mu.Unlock()
+
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+				s.run()
+			case <-s.stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the scheduler loop. A run that is in progress is not interrupted.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+}
+
+// Trigger starts a run in the background right away. It returns
+// ErrAlreadyRunning instead if a run is in progress.
+func (s *Scheduler) Trigger() error {
+	if !s.runLock.TryLock() {
+		return ErrAlreadyRunning
+	}
+	go func() {
+		defer s.runLock.Unlock()
+		s.execute()
+	}()
+	return nil
+}
+
+// Runs the job unless a run is already in progress
+func (s *Scheduler) run() {
+	if !s.runLock.TryLock() {
+		log.Printf("[%s] Skipping scheduled run, previous run is still in progress", s.name)
+		return
+	}
+	defer s.runLock.Unlock()
+	s.execute()
+}
+
+// Must be called with runLock held
+func (s *Scheduler) execute() {
+	started := time.Now().In(s.loc)
+	s.mu.Lock()
+	s.status.Running = true
+	s.status.LastStarted = &started
+	s.mu.Unlock()
+
+	result, err := s.job()
+
+	ended := time.Now().In(s.loc)
+	s.mu.Lock()
+	s.status.Running = false
+	s.status.LastEnded = &ended
+	s.status.LastResult = result
+	s.status.LastError = ""
+	if err != nil {
+		s.status.LastError = err.Error()
+		log.Printf("[%s] Run failed: %v", s.name, err)
+	}
+	s.mu.Unlock()
+}
+
+// Status returns a copy of the current status
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/blevesearch/bleve/v2"
 	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
 	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
 	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
 	"github.com/blevesearch/bleve/v2/analysis/token/porter"
@@ -32,6 +33,14 @@ type DishDocument struct {
 	HallName    string    `json:"hall_name"`
 	Location    string    `json:"location"`
 	LastSeen    time.Time `json:"last_seen"`
+	Tags        []string  `json:"tags"`
+	Allergens   []string  `json:"allergens"`
+}
+
+// BleveType makes Bleve index dish documents with the "dish" mapping from
+// buildIndexMapping instead of the default dynamic mapping
+func (d DishDocument) BleveType() string {
+	return "dish"
 }
 
 // Convert dish model to dish document
@@ -65,6 +74,8 @@ func DishToDocument(dish models.Dish, hallName string) DishDocument {
 		HallName:    hallName,
 		Location:    location,
 		LastSeen:    lastSeen,
+		Tags:        dish.Tags,
+		Allergens:   dish.Allergens(),
 	}
 }
 
@@ -153,6 +164,15 @@ func buildIndexMapping() (mapping.IndexMapping, error) {
 	locationFieldMapping.Analyzer = standard.Name
 	dishMapping.AddFieldMappingsAt("location", locationFieldMapping)
 
+	// Dietary tags and allergens are matched exactly (e.g. "tree-nuts" shouldn't be split up)
+	tagsFieldMapping := bleve.NewTextFieldMapping()
+	tagsFieldMapping.Analyzer = keyword.Name
+	dishMapping.AddFieldMappingsAt("tags", tagsFieldMapping)
+
+	allergensFieldMapping := bleve.NewTextFieldMapping()
+	allergensFieldMapping.Analyzer = keyword.Name
+	dishMapping.AddFieldMappingsAt("allergens", allergensFieldMapping)
+
 	// Add document mapping to index
 	indexMapping.AddDocumentMapping("dish", dishMapping)
 	indexMapping.DefaultAnalyzer = standard.Name
@@ -184,8 +204,10 @@ func (m *BleveSearchManager) DeleteDish(id string) error {
 	return m.index.Delete(id)
 }
 
-// SearchDishes searches for dishes matching the query
-func (m *BleveSearchManager) SearchDishes(queryString string, hallFilter string, limit int) ([]DishDocument, error) {
+// SearchDishes searches for dishes matching the query. Dishes must also have
+// every tag in filter.IncludeTags and none of the allergens in filter.ExcludeAllergens,
+// and fit filter.Diet. filter.AvoidIngredients is left to the caller.
+func (m *BleveSearchManager) SearchDishes(queryString string, hallFilter string, filter models.DietaryFilter, limit int) ([]DishDocument, error) {
 	// Clean query string
 	queryString = strings.TrimSpace(queryString)
 	if queryString == "" {
@@ -218,6 +240,36 @@ func (m *BleveSearchManager) SearchDishes(queryString string, hallFilter string,
 		finalQuery = queryDisjunction
 	}
 
+	// Add dietary filters
+	if !filter.IsEmpty() {
+		dietaryQuery := bleve.NewBooleanQuery()
+		dietaryQuery.AddMust(finalQuery)
+		if dietTags := models.DietTags(filter.Diet); dietTags != nil {
+			dietQuery := bleve.NewDisjunctionQuery()
+			for _, tag := range dietTags {
+				tagQuery := bleve.NewTermQuery(tag)
+				tagQuery.SetField("tags")
+				dietQuery.AddQuery(tagQuery)
+			}
+			dietaryQuery.AddMust(dietQuery)
+		}
+		for _, tag := range filter.IncludeTags {
+			includeQuery := bleve.NewDisjunctionQuery()
+			for _, satisfying := range models.TagsSatisfying(tag) {
+				tagQuery := bleve.NewTermQuery(satisfying)
+				tagQuery.SetField("tags")
+				includeQuery.AddQuery(tagQuery)
+			}
+			dietaryQuery.AddMust(includeQuery)
+		}
+		for _, allergen := range filter.ExcludeAllergens {
+			allergenQuery := bleve.NewTermQuery(allergen)
+			allergenQuery.SetField("allergens")
+			dietaryQuery.AddMustNot(allergenQuery)
+		}
+		finalQuery = dietaryQuery
+	}
+
 	// Create search request
 	searchRequest := bleve.NewSearchRequest(finalQuery)
 	searchRequest.Size = limit
@@ -260,12 +312,33 @@ func (m *BleveSearchManager) SearchDishes(queryString string, hallFilter string,
 			dish.Location = location
 		}
 
+		dish.Tags = stringsField(hit.Fields["tags"])
+		dish.Allergens = stringsField(hit.Fields["allergens"])
+
 		dishes = append(dishes, dish)
 	}
 
 	return dishes, nil
 }
 
+// Array fields come back from Bleve as a single string if they have one
+// value, or as []interface{} if they have more
+func stringsField(field interface{}) []string {
+	switch value := field.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if str, ok := v.(string); ok {
+				values = append(values, str)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
 // Count returns the number of documents in the index
 func (m *BleveSearchManager) Count() (uint64, error) {
 	return m.index.DocCount()
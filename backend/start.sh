@@ -8,32 +8,32 @@ if ! command -v go >/dev/null 2>&1; then
   echo "Error: Go is not installed. Please install Go from https://go.dev/dl/"
 fi
 
-if ! command -v python3 >/dev/null 2>&1; then
-  echo "Error: Python3 is not installed. Please install Python 3 from https://www.python.org/downloads/"
-fi
-
-# Create the virtual env for the Python script
-VENV_DIR="./scraper/.venv"
-if [ ! -d "$VENV_DIR" ]; then
-    python3 -m venv "$VENV_DIR"
-    echo "Created Python virtual environment in $VENV_DIR"
-fi
-
-# TODO: How to make this run only if needed
-source "$VENV_DIR/bin/activate"
-
-pip3 install --upgrade pip > /dev/null
-
-# Install selenium if not already installed
-if ! pip3 show selenium >/dev/null 2>&1; then
-  echo "Installing Selenium (PIP package)..."
-  pip3 install selenium
-fi
-
-# Install bs4 (really installs beautifulsoup4) if not already installed
-if ! pip3 show bs4 >/dev/null 2>&1; then
-  echo "Installing bs4 (PIP package)..."
-  pip3 install bs4
+# Python is only needed for the legacy Selenium scraper in ./scraper.
+# The backend scrapes menus natively in Go, so this is skipped if Python is missing.
+if command -v python3 >/dev/null 2>&1; then
+  # Create the virtual env for the Python script
+  VENV_DIR="./scraper/.venv"
+  if [ ! -d "$VENV_DIR" ]; then
+      python3 -m venv "$VENV_DIR"
+      echo "Created Python virtual environment in $VENV_DIR"
+  fi
+
+  # TODO: How to make this run only if needed
+  source "$VENV_DIR/bin/activate"
+
+  pip3 install --upgrade pip > /dev/null
+
+  # Install selenium if not already installed
+  if ! pip3 show selenium >/dev/null 2>&1; then
+    echo "Installing Selenium (PIP package)..."
+    pip3 install selenium
+  fi
+
+  # Install bs4 (really installs beautifulsoup4) if not already installed
+  if ! pip3 show bs4 >/dev/null 2>&1; then
+    echo "Installing bs4 (PIP package)..."
+    pip3 install bs4
+  fi
 fi
 
 cd "$ROOT"
@@ -68,10 +68,8 @@ export default function LoginForm({ onClose, onLoginSuccess }) {
         // pre-existing login, use `username` to represent either username or email (whatever the user enters)
         await login(formData.username, formData.password)
       } else {
-        // submitting register form
+        // submitting register form, which also logs the new user in
         await signup(formData.username, formData.email, formData.password)
-        // After successful signup, automatically log in
-        await login(formData.username, formData.password)
       }
       setError('')
       onLoginSuccess?.();
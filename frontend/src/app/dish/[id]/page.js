@@ -19,8 +19,10 @@ export default function DishDetail() {
     const fetchDishData = async () => {
       try {
         //two endpoitns - one for ratings and other for fetching dish info
+        // logged in users also see ratings only shared with friends
+        const token = localStorage.getItem('jwt');
         const [ratingsResponse, dishResponse] = await Promise.all([
-          api.get('/dishratings', null, {dish_id}),
+          api.get('/dishratings', token, {dish_id}),
           api.get(`/dish/${dish_id}`)
         ]);
 
@@ -7,7 +7,8 @@ import { api } from '../../utils/api'
 
 // get user info from api
 function getUserInfo(username) {
-    return api.get(`/user/${username}`)
+    const token = localStorage.getItem('jwt');
+    return api.get(`/user/${username}`, token)
     .then(response => {
         if (!response.ok) {
             throw new Error('Failed to fetch user info');
@@ -18,8 +19,13 @@ function getUserInfo(username) {
 
 // get user ratings from api
 function getUserRatings(username) {
-    return api.get(`/user/${username}/ratings`)
+    const token = localStorage.getItem('jwt');
+    return api.get(`/user/${username}/ratings`, token)
     .then(response => {
+        // the user's privacy settings hide their ratings
+        if (response.status === 403) {
+            return [];
+        }
         if (!response.ok) {
             throw new Error('Failed to fetch user ratings');
         }
@@ -12,7 +12,11 @@ export async function signup(username, email, password) {
         const err = await response.json();
         throw new Error(err.error || "Signup failed");
       }
-      return response.json();
+      // signing up already logs the new user in
+      const { token, refresh_token, user } = await response.json();
+      localStorage.setItem("jwt", token);
+      localStorage.setItem("refresh_token", refresh_token);
+      return user;
 }
 
 export async function login(username, password) {
@@ -24,11 +28,21 @@ export async function login(username, password) {
         const err = await response.json();
         throw new Error(err.error || "Login failed");
     }
-    const { token, user } = await response.json();
+    const { token, refresh_token, user } = await response.json();
     localStorage.setItem("jwt", token);
+    localStorage.setItem("refresh_token", refresh_token);
     return user;
 }
 
 export async function logout() {
-  localStorage.removeItem("jwt") // stateless JWT, remove token on client's end
+  const token = localStorage.getItem("jwt")
+  if (token) {
+    try {
+      await api.post('/logout', token) // ends the session so the refresh token stops working
+    } catch (error) {
+      // still log out locally if the server can't be reached
+    }
+  }
+  localStorage.removeItem("jwt")
+  localStorage.removeItem("refresh_token")
 }
\ No newline at end of file
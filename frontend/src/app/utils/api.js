@@ -5,7 +5,7 @@ function buildQueryParams(params = {}) {
     return query ? `?${query}` : ''
 }
 
-async function request(endpoint, method = 'GET',  token = null, data = null) {
+async function request(endpoint, method = 'GET',  token = null, data = null, retry = true) {
     const headers = {
         'Content-Type': 'application/json',
         'ngrok-skip-browser-warning': 'any-value'
@@ -27,13 +27,71 @@ async function request(endpoint, method = 'GET',  token = null, data = null) {
         url += buildQueryParams(data)
 
     try {
-        return await fetch(url, config)
+        const response = await fetch(url, config)
+
+        // Access tokens are short lived, so get a new one and try again once
+        if (response.status === 401 && token && retry) {
+            const newToken = await refreshAccessToken()
+            if (newToken)
+                return request(endpoint, method, newToken, data, false)
+        }
+        return response
     } catch (error) {
         console.error('API Error:', error.message)
         throw error
     }
 }
 
+// Shared between requests that fail at the same time, since a refresh token only works once
+let refreshing = null
+
+export function refreshAccessToken() {
+    if (!refreshing) {
+        const staleToken = localStorage.getItem('refresh_token')
+        refreshing = withRefreshLock(() => doRefresh(staleToken)).finally(() => { refreshing = null })
+    }
+    return refreshing
+}
+
+// Other tabs share the refresh token too, so only one tab refreshes at a time
+function withRefreshLock(refresh) {
+    if (typeof navigator !== 'undefined' && navigator.locks)
+        return navigator.locks.request('bruinbite-refresh', refresh)
+    return refresh()
+}
+
+async function doRefresh(staleToken) {
+    const refreshToken = localStorage.getItem('refresh_token')
+    if (!refreshToken)
+        return null
+    // Another tab refreshed while this one waited for the lock
+    if (refreshToken !== staleToken)
+        return localStorage.getItem('jwt')
+
+    try {
+        const response = await fetch(`${BASE_URL}/refresh`, {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+                'ngrok-skip-browser-warning': 'any-value'
+            },
+            body: JSON.stringify({ refresh_token: refreshToken })
+        })
+        if (!response.ok) {
+            localStorage.removeItem('jwt')
+            localStorage.removeItem('refresh_token')
+            return null
+        }
+        const { token, refresh_token } = await response.json()
+        localStorage.setItem('jwt', token)
+        localStorage.setItem('refresh_token', refresh_token)
+        return token
+    } catch (error) {
+        console.error('API Error:', error.message)
+        return null
+    }
+}
+
 
 export const api = {
     get: (endpoint, token = null, data = null) => request(endpoint, 'GET', token, data),